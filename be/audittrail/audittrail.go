@@ -0,0 +1,357 @@
+// audittrail records every create, update and delete of the HR entities as an
+// immutable audit.AuditLog in the database of the tenant, written in the
+// transaction of the change so that no change goes unrecorded.
+//
+// The Plugin reads the caller (security.WithClaims), the tenant
+// (tenantscope.WithTenant) and the request ID (logger.WithRequestID) from the
+// statement context. Fields hidden from the API by json:"-", such as password
+// hashes, are recorded as changed with a masked value.
+package audittrail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"vngom/datascope"
+	"vngom/logger"
+	"vngom/models/account"
+	"vngom/models/audit"
+	"vngom/models/bases"
+	"vngom/models/department"
+	"vngom/models/employee"
+	"vngom/models/personal"
+	"vngom/security"
+	"vngom/tenantscope"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrImmutable = errors.New("audit records cannot be changed")
+
+const (
+	beforeKey = "audittrail:before"
+	masked    = "******"
+)
+
+// tracked are the models whose changes are recorded
+var tracked = map[reflect.Type]bool{}
+
+func init() {
+	for _, model := range []interface{}{
+		employee.Employee{},
+		personal.PersonalInfo{},
+		department.Department{},
+		account.Account{},
+	} {
+		tracked[reflect.TypeOf(model)] = true
+	}
+}
+
+// bookkeeping fields are not recorded as changes, the AuditLog tells who changed what and when
+var bookkeeping = map[string]bool{
+	"CreatedOn":       true,
+	"CreatedBy":       true,
+	"ModifiedOn":      true,
+	"ModifiedBy":      true,
+	tenantscope.Field: true,
+}
+
+// Change is the value of a field before and after a change, nil on creation and deletion
+type Change struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type purgeKey struct{}
+
+// WithPurge lets the deletes run with ctx remove audit records and leaves them
+// unrecorded, for purging every row of a tenant
+func WithPurge(ctx context.Context) context.Context {
+	return context.WithValue(ctx, purgeKey{}, true)
+}
+
+func purging(db *gorm.DB) bool {
+	purge, _ := db.Statement.Context.Value(purgeKey{}).(bool)
+	return purge
+}
+
+// Plugin records the changes of the tracked models and refuses to change the AuditLog
+type Plugin struct{}
+
+func NewPlugin() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return "audittrail"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction").Register("audittrail:create", afterCreate); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:begin_transaction").Before("gorm:update").Register("audittrail:before_update", before); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").Register("audittrail:update", afterUpdate); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:begin_transaction").Before("gorm:delete").Register("audittrail:before_delete", beforeDelete); err != nil {
+		return err
+	}
+	return callbacks.Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction").Register("audittrail:delete", afterDelete)
+}
+
+func isTracked(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil && tracked[db.Statement.Schema.ModelType]
+}
+
+func afterCreate(db *gorm.DB) {
+	if !isTracked(db) {
+		return
+	}
+	var logs []audit.AuditLog
+	each(db.Statement.ReflectValue, func(row reflect.Value) {
+		logs = appendLog(logs, db, audit.ActionCreate, row, nil, snapshot(db, row))
+	})
+	write(db, logs)
+}
+
+func beforeDelete(db *gorm.DB) {
+	if !purging(db) {
+		before(db)
+	}
+}
+
+// before loads the rows an update or a delete is about to change
+func before(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	if db.Statement.Schema.ModelType == reflect.TypeOf(audit.AuditLog{}) {
+		db.AddError(ErrImmutable)
+		return
+	}
+	if !tracked[db.Statement.Schema.ModelType] {
+		return
+	}
+	var exprs []clause.Expression
+	if c, ok := db.Statement.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok {
+			exprs = append(exprs, where.Exprs...)
+		}
+	}
+	if ids := primaryKeys(db, db.Statement.ReflectValue); len(ids) > 0 {
+		exprs = append(exprs, clause.IN{Column: primaryKey(db), Values: ids})
+	}
+	if len(exprs) == 0 && !db.AllowGlobalUpdate {
+		// gorm refuses the statement
+		return
+	}
+	rows, err := load(db, db.Statement.Context, exprs)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	db.InstanceSet(beforeKey, rows)
+}
+
+func afterUpdate(db *gorm.DB) {
+	old, ok := loaded(db)
+	if !ok {
+		return
+	}
+	// the updated rows may have left the data scope of the caller
+	rows, err := load(db, datascope.WithScope(db.Statement.Context, datascope.Unrestricted), []clause.Expression{
+		clause.IN{Column: primaryKey(db), Values: primaryKeys(db, old)},
+	})
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	updated := map[interface{}]reflect.Value{}
+	each(rows, func(row reflect.Value) {
+		updated[primaryKeyOf(db, row)] = row
+	})
+	var logs []audit.AuditLog
+	each(old, func(row reflect.Value) {
+		if next, ok := updated[primaryKeyOf(db, row)]; ok {
+			logs = appendLog(logs, db, audit.ActionUpdate, row, snapshot(db, row), snapshot(db, next))
+		}
+	})
+	write(db, logs)
+}
+
+func afterDelete(db *gorm.DB) {
+	old, ok := loaded(db)
+	if !ok || db.RowsAffected == 0 {
+		return
+	}
+	var logs []audit.AuditLog
+	each(old, func(row reflect.Value) {
+		logs = appendLog(logs, db, audit.ActionDelete, row, snapshot(db, row), nil)
+	})
+	write(db, logs)
+}
+
+// loaded returns the rows loaded by before, when the statement succeeded
+func loaded(db *gorm.DB) (reflect.Value, bool) {
+	if !isTracked(db) {
+		return reflect.Value{}, false
+	}
+	value, ok := db.InstanceGet(beforeKey)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	rows := value.(reflect.Value)
+	return rows, rows.Len() > 0
+}
+
+// load returns a slice of the rows of the model matching exprs, read in the transaction of the statement
+func load(db *gorm.DB, ctx context.Context, exprs []clause.Expression) (reflect.Value, error) {
+	rows := reflect.New(reflect.SliceOf(db.Statement.Schema.ModelType))
+	tx := db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	if len(exprs) > 0 {
+		tx = tx.Clauses(clause.Where{Exprs: exprs})
+	}
+	if err := tx.Find(rows.Interface()).Error; err != nil {
+		return reflect.Value{}, err
+	}
+	return rows.Elem(), nil
+}
+
+func write(db *gorm.DB, logs []audit.AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	if err := db.Session(&gorm.Session{NewDB: true}).Create(&logs).Error; err != nil {
+		db.AddError(fmt.Errorf("failed to record audit trail: %w", err))
+	}
+}
+
+// appendLog appends the record of the change of row, unless no field changed
+func appendLog(logs []audit.AuditLog, db *gorm.DB, action string, row reflect.Value, old map[string]field, new map[string]field) []audit.AuditLog {
+	changes := diff(old, new)
+	if len(changes) == 0 {
+		return logs
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		db.AddError(err)
+		return logs
+	}
+	ctx := db.Statement.Context
+	entry := audit.AuditLog{
+		ID:        bases.NewID(),
+		Entity:    db.Statement.Schema.Name,
+		EntityID:  fmt.Sprint(primaryKeyOf(db, row)),
+		Action:    action,
+		Tenant:    tenantscope.FromContext(ctx),
+		RequestID: logger.RequestID(ctx),
+		Changes:   string(encoded),
+		CreatedOn: time.Now().UTC(),
+	}
+	if claims := security.ClaimsFromContext(ctx); claims != nil {
+		entry.Actor = claims.Username
+	}
+	return append(logs, entry)
+}
+
+// field is the value of a field of a row, a sensitive value is compared but never recorded
+type field struct {
+	value     interface{}
+	zero      bool
+	sensitive bool
+}
+
+func (f field) recorded() interface{} {
+	if f.sensitive {
+		return masked
+	}
+	return f.value
+}
+
+// snapshot returns the recorded fields of a row by field name
+func snapshot(db *gorm.DB, row reflect.Value) map[string]field {
+	ret := map[string]field{}
+	for _, f := range db.Statement.Schema.Fields {
+		if f.DBName == "" || f.PrimaryKey || bookkeeping[f.Name] {
+			continue
+		}
+		value, zero := f.ValueOf(db.Statement.Context, row)
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC()
+		}
+		ret[f.Name] = field{value: value, zero: zero, sensitive: f.Tag.Get("json") == "-"}
+	}
+	return ret
+}
+
+// diff returns the changed fields. Without old values the change is a
+// creation, without new values a deletion, and zero values are left out.
+func diff(old map[string]field, new map[string]field) map[string]Change {
+	ret := map[string]Change{}
+	for name, n := range new {
+		o, ok := old[name]
+		switch {
+		case !ok && n.zero:
+		case !ok:
+			ret[name] = Change{New: n.recorded()}
+		case !equal(o.value, n.value):
+			ret[name] = Change{Old: o.recorded(), New: n.recorded()}
+		}
+	}
+	for name, o := range old {
+		if _, ok := new[name]; !ok && !o.zero {
+			ret[name] = Change{Old: o.recorded()}
+		}
+	}
+	return ret
+}
+
+func equal(a interface{}, b interface{}) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
+
+func each(rows reflect.Value, fn func(row reflect.Value)) {
+	rows = reflect.Indirect(rows)
+	switch rows.Kind() {
+	case reflect.Struct:
+		fn(rows)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rows.Len(); i++ {
+			fn(reflect.Indirect(rows.Index(i)))
+		}
+	}
+}
+
+func primaryKey(db *gorm.DB) clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: db.Statement.Schema.PrioritizedPrimaryField.DBName}
+}
+
+func primaryKeyOf(db *gorm.DB, row reflect.Value) interface{} {
+	value, _ := db.Statement.Schema.PrioritizedPrimaryField.ValueOf(db.Statement.Context, row)
+	return value
+}
+
+// primaryKeys returns the non zero primary keys of the rows
+func primaryKeys(db *gorm.DB, rows reflect.Value) []interface{} {
+	var ids []interface{}
+	each(rows, func(row reflect.Value) {
+		if row.Type() != db.Statement.Schema.ModelType {
+			return
+		}
+		if value, zero := db.Statement.Schema.PrioritizedPrimaryField.ValueOf(db.Statement.Context, row); !zero {
+			ids = append(ids, value)
+		}
+	})
+	return ids
+}
@@ -0,0 +1,142 @@
+package audittrail_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"vngom/audittrail"
+	"vngom/datascope"
+	"vngom/logger"
+	"vngom/models/account"
+	"vngom/models/audit"
+	"vngom/models/department"
+	"vngom/models/employee"
+	"vngom/repo"
+	"vngom/security"
+	"vngom/tenantscope"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type testRepo struct {
+	db *gorm.DB
+}
+
+func (r *testRepo) GetDb() *gorm.DB                { return r.db }
+func (r *testRepo) GetTenant() string              { return "acme" }
+func (r *testRepo) GetDbName() string              { return "acme" }
+func (r *testRepo) Ping(ctx context.Context) error { return nil }
+
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(datascope.NewPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(audittrail.NewPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&account.Account{}, &department.Department{}, &employee.Employee{}, &audit.AuditLog{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func changesOf(t *testing.T, entry audit.AuditLog) map[string]audittrail.Change {
+	var changes map[string]audittrail.Change
+	assert.NoError(t, json.Unmarshal([]byte(entry.Changes), &changes))
+	return changes
+}
+
+func TestAuditTrail(t *testing.T) {
+	db := newTestDb(t)
+	ctx := security.WithClaims(context.Background(), &security.Claims{Username: "alice"})
+	ctx = logger.WithRequestID(tenantscope.WithTenant(ctx, "acme"), "req-1")
+	ctx = datascope.WithScope(ctx, datascope.Unrestricted)
+	r := &testRepo{db: db}
+
+	acc := account.Account{Username: "bob", Password: "hash-1"}
+	acc.ID = uuid.New()
+	assert.NoError(t, db.WithContext(ctx).Create(&acc).Error)
+	_, err := repo.For[account.Account](r).WithContext(ctx).Update(acc.ID, time.Time{}, map[string]interface{}{
+		"Password": "hash-2",
+		"Email":    "bob@example.com",
+	})
+	assert.NoError(t, err)
+	// an update changing nothing is not recorded
+	assert.NoError(t, db.WithContext(ctx).Model(&acc).Update("Username", "bob").Error)
+
+	logs, err := audittrail.List(ctx, r, audittrail.Query{Entity: "Account", EntityID: acc.ID.String()})
+	assert.NoError(t, err)
+	if assert.Len(t, logs, 2) {
+		update, create := logs[0], logs[1]
+		assert.Equal(t, audit.ActionUpdate, update.Action)
+		assert.Equal(t, "alice", update.Actor)
+		assert.Equal(t, "acme", update.Tenant)
+		assert.Equal(t, "req-1", update.RequestID)
+		assert.Equal(t, map[string]audittrail.Change{
+			"Password": {Old: "******", New: "******"},
+			"Email":    {Old: "", New: "bob@example.com"},
+		}, changesOf(t, update))
+		assert.NotContains(t, update.Changes, "hash-")
+
+		assert.Equal(t, audit.ActionCreate, create.Action)
+		changes := changesOf(t, create)
+		assert.Equal(t, audittrail.Change{New: "bob"}, changes["Username"])
+		assert.Equal(t, audittrail.Change{New: "******"}, changes["Password"])
+		assert.NotContains(t, changes, "Email")
+		assert.NotContains(t, create.Changes, "hash-")
+	}
+
+	// models keyed by an auto incremented ID and deletions
+	d := department.Department{ID: 7, Code: "HQ", LevelCode: "7"}
+	assert.NoError(t, db.WithContext(ctx).Create(&d).Error)
+	assert.NoError(t, db.WithContext(ctx).Delete(&department.Department{}, 7).Error)
+	logs, err = audittrail.List(ctx, r, audittrail.Query{Entity: "Department", Action: audit.ActionDelete})
+	assert.NoError(t, err)
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, "7", logs[0].EntityID)
+		assert.Equal(t, audittrail.Change{Old: "HQ"}, changesOf(t, logs[0])["Code"])
+	}
+
+	// the records cannot be changed
+	err = db.WithContext(ctx).Model(&audit.AuditLog{}).Where("1 = 1").Update("Actor", "mallory").Error
+	assert.ErrorIs(t, err, audittrail.ErrImmutable)
+	err = db.WithContext(ctx).Where("1 = 1").Delete(&audit.AuditLog{}).Error
+	assert.ErrorIs(t, err, audittrail.ErrImmutable)
+
+	logs, err = audittrail.List(ctx, r, audittrail.Query{Actor: "alice", From: time.Now().Add(-time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, logs, 4)
+
+	// a purge deletes them, unrecorded
+	purge := audittrail.WithPurge(ctx)
+	assert.NoError(t, db.WithContext(purge).Where("1 = 1").Delete(&department.Department{}).Error)
+	assert.NoError(t, db.WithContext(purge).Where("1 = 1").Delete(&audit.AuditLog{}).Error)
+	var count int64
+	assert.NoError(t, db.Model(&audit.AuditLog{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestAuditTrailFollowsTheTransaction(t *testing.T) {
+	db := newTestDb(t)
+	e := employee.Employee{Code: "E1"}
+	e.ID = uuid.New()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("User", "Personal").Create(&e).Error; err != nil {
+			return err
+		}
+		return gorm.ErrInvalidData
+	})
+	assert.ErrorIs(t, err, gorm.ErrInvalidData)
+	var count int64
+	assert.NoError(t, db.Model(&audit.AuditLog{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
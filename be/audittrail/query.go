@@ -0,0 +1,52 @@
+package audittrail
+
+import (
+	"context"
+	"time"
+
+	"vngom/models/audit"
+	"vngom/repo"
+
+	"gorm.io/gorm/clause"
+)
+
+// Query selects audit records, empty criteria match every record
+type Query struct {
+	Entity    string
+	EntityID  string
+	Action    string
+	Actor     string
+	RequestID string
+	// From and To bound CreatedOn, To is excluded
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// List returns the audit records of the tenant matching q, the most recent first
+func List(ctx context.Context, r repo.IRepo, q Query) ([]audit.AuditLog, error) {
+	// the zero fields of a struct condition are ignored
+	tx := r.GetDb().WithContext(ctx).Where(&audit.AuditLog{
+		Entity:    q.Entity,
+		EntityID:  q.EntityID,
+		Action:    q.Action,
+		Actor:     q.Actor,
+		RequestID: q.RequestID,
+	})
+	if !q.From.IsZero() {
+		tx = tx.Where("created_on >= ?", q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("created_on < ?", q.To.UTC())
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+	var logs []audit.AuditLog
+	err := tx.Order(clause.OrderByColumn{Column: clause.Column{Name: "created_on"}, Desc: true}).Find(&logs).Error
+	return logs, err
+}
@@ -0,0 +1,190 @@
+package authz
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"vngom/datascope"
+	"vngom/fiber_wrapper"
+	"vngom/models/account"
+	"vngom/models/rbac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const defaultCacheTTL = time.Minute
+
+var (
+	ErrRoleNotFound   = errors.New("role not found")
+	ErrSystemRole     = errors.New("system roles cannot be modified")
+	ErrUnknownPerm    = errors.New("unknown permission")
+	ErrRoleCodeExists = errors.New("role code already exists")
+	ErrUnknownScope   = errors.New("unknown data scope")
+	ErrNoAccount      = errors.New("account not found")
+)
+
+type cacheEntry struct {
+	permissions []string
+	scope       *datascope.Scope
+	revision    int64
+	expiresAt   time.Time
+}
+
+// Authorizer resolves the permissions and data scope of the caller from the tenant database.
+// They are cached per tenant and account, call Invalidate after changing roles or passwords.
+type Authorizer struct {
+	ttl   time.Duration
+	lock  sync.RWMutex
+	cache map[string]cacheEntry
+}
+
+func NewAuthorizer(ttl time.Duration) *Authorizer {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return &Authorizer{
+		ttl:   ttl,
+		cache: make(map[string]cacheEntry),
+	}
+}
+
+// Authorize implements fiber_wrapper.Authorizer
+func (a *Authorizer) Authorize(c fiber_wrapper.IAppContext, permission string) error {
+	user := c.GetUser()
+	if user == nil {
+		return fiber.ErrUnauthorized
+	}
+	// a token is only valid for the tenant it was issued by
+	if user.Tenant != c.GetTenant() {
+		return fiber.ErrForbidden
+	}
+	entry, err := a.accessOf(c, user.AccountID)
+	if err != nil {
+		return err
+	}
+	// the password changed since the token was issued
+	if user.Revision != entry.revision {
+		return fiber.ErrUnauthorized
+	}
+	for _, granted := range entry.permissions {
+		if Match(granted, permission) {
+			// queries issued with the request context only reach the departments of the caller
+			ctx := c.GetApp().UserContext()
+			c.GetApp().SetUserContext(datascope.WithScope(ctx, entry.scope))
+			return nil
+		}
+	}
+	return fiber.NewError(fiber.StatusForbidden, "missing permission "+permission)
+}
+
+func (a *Authorizer) accessOf(c fiber_wrapper.IAppContext, accountID uuid.UUID) (cacheEntry, error) {
+	key := c.GetTenant() + "/" + accountID.String()
+	a.lock.RLock()
+	entry, ok := a.cache[key]
+	a.lock.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry, nil
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return entry, err
+	}
+	permissions, err := GetPermissions(r.GetDb(), accountID)
+	if err != nil {
+		return entry, err
+	}
+	scope, err := datascope.Resolve(r.GetDb(), accountID)
+	if err != nil {
+		return entry, err
+	}
+	var acc account.Account
+	err = r.GetDb().Select("password_changed_on").Where("id = ?", accountID).Take(&acc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return entry, fiber.ErrUnauthorized
+	}
+	if err != nil {
+		return entry, err
+	}
+	entry = cacheEntry{permissions: permissions, scope: scope, revision: acc.TokenRevision(), expiresAt: time.Now().Add(a.ttl)}
+	a.lock.Lock()
+	a.cache[key] = entry
+	a.lock.Unlock()
+	return entry, nil
+}
+
+// Invalidate drops the cached permissions of every account of a tenant
+func (a *Authorizer) Invalidate(tenant string) {
+	prefix := tenant + "/"
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for key := range a.cache {
+		if strings.HasPrefix(key, prefix) {
+			delete(a.cache, key)
+		}
+	}
+}
+
+// GetPermissions returns the permission codes granted to an account through its roles
+func GetPermissions(db *gorm.DB, accountID uuid.UUID) ([]string, error) {
+	var codes []string
+	roleIDs := db.Model(&rbac.AccountRole{}).Select("role_id").Where("account_id = ?", accountID)
+	permissionIDs := db.Model(&rbac.RolePermission{}).Select("permission_id").Where("role_id IN (?)", roleIDs)
+	err := db.Model(&rbac.Permission{}).Where("id IN (?)", permissionIDs).Pluck("code", &codes).Error
+	return codes, err
+}
+
+// Migrate creates the authorization tables in a tenant database
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&rbac.Permission{}, &rbac.Role{}, &rbac.RolePermission{}, &rbac.AccountRole{})
+}
+
+// SeedDefaultRoles registers every known permission and the tenant-admin role.
+// It is safe to call on every tenant creation or upgrade.
+func SeedDefaultRoles(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for code, description := range Permissions {
+			if _, err := ensurePermission(tx, code, description); err != nil {
+				return err
+			}
+		}
+		var role rbac.Role
+		err := tx.Where(&rbac.Role{Code: TenantAdminRole}).First(&role).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			role = rbac.Role{
+				Code:        TenantAdminRole,
+				Name:        "Tenant administrator",
+				Description: "Full access to the tenant",
+				IsSystem:    true,
+				DataScope:   rbac.DataScopeAll,
+			}
+			role.ID = uuid.New()
+			role.CreatedOn = time.Now().UTC()
+			err = tx.Create(&role).Error
+		}
+		if err != nil {
+			return err
+		}
+		var all rbac.Permission
+		if err := tx.Where(&rbac.Permission{Code: PermAll}).First(&all).Error; err != nil {
+			return err
+		}
+		return tx.Where(&rbac.RolePermission{RoleID: role.ID, PermissionID: all.ID}).
+			FirstOrCreate(&rbac.RolePermission{RoleID: role.ID, PermissionID: all.ID}).Error
+	})
+}
+
+func ensurePermission(tx *gorm.DB, code string, description string) (*rbac.Permission, error) {
+	var p rbac.Permission
+	err := tx.Where(&rbac.Permission{Code: code}).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		p = rbac.Permission{Code: code, Description: description}
+		p.ID = uuid.New()
+		p.CreatedOn = time.Now().UTC()
+		err = tx.Create(&p).Error
+	}
+	return &p, err
+}
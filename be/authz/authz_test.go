@@ -0,0 +1,99 @@
+package authz_test
+
+import (
+	"testing"
+
+	"vngom/authz"
+	"vngom/models/account"
+	"vngom/models/rbac"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authz.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&account.Account{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newAccount(t *testing.T, db *gorm.DB, username string) uuid.UUID {
+	acc := account.Account{Username: username, Email: username + "@example.com"}
+	acc.ID = uuid.New()
+	assert.NoError(t, db.Create(&acc).Error)
+	return acc.ID
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, authz.Match("*", "employee.read"))
+	assert.True(t, authz.Match("employee.read", "employee.read"))
+	assert.True(t, authz.Match("employee.*", "employee.write"))
+	assert.False(t, authz.Match("employee.*", "employees.read"))
+	assert.False(t, authz.Match("employee.read", "employee.write"))
+	assert.False(t, authz.Match("department.read", "employee.read"))
+}
+
+func TestSeedDefaultRoles(t *testing.T) {
+	db := newTestDb(t)
+	assert.NoError(t, authz.SeedDefaultRoles(db))
+	// seeding twice must not duplicate anything
+	assert.NoError(t, authz.SeedDefaultRoles(db))
+
+	roles, err := authz.ListRoles(db)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+	assert.Equal(t, authz.TenantAdminRole, roles[0].Code)
+	assert.True(t, roles[0].IsSystem)
+
+	admin := newAccount(t, db, "admin")
+	assert.NoError(t, authz.AssignRole(db, admin, roles[0].ID))
+	// roles are only granted to existing accounts
+	assert.ErrorIs(t, authz.AssignRole(db, uuid.New(), roles[0].ID), authz.ErrNoAccount)
+	permissions, err := authz.GetPermissions(db, admin)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{authz.PermAll}, permissions)
+
+	assert.ErrorIs(t, authz.DeleteRole(db, roles[0].ID), authz.ErrSystemRole)
+}
+
+func TestCustomRole(t *testing.T) {
+	db := newTestDb(t)
+	assert.NoError(t, authz.SeedDefaultRoles(db))
+
+	role := rbac.Role{Code: "hr-viewer", Name: "HR viewer"}
+	// a code listed twice is granted once
+	assert.NoError(t, authz.CreateRole(db, &role, []string{authz.PermEmployeeRead, authz.PermDepartmentRead, authz.PermEmployeeRead}, "admin"))
+	assert.ElementsMatch(t, []string{authz.PermEmployeeRead, authz.PermDepartmentRead}, role.Permissions)
+	assert.ErrorIs(t, authz.CreateRole(db, &rbac.Role{Code: "hr-viewer"}, nil, "admin"), authz.ErrRoleCodeExists)
+	assert.ErrorIs(t, authz.CreateRole(db, &rbac.Role{Code: "other"}, []string{"payroll.read"}, "admin"), authz.ErrUnknownPerm)
+
+	manager := newAccount(t, db, "manager")
+	assert.NoError(t, authz.AssignRole(db, manager, role.ID))
+	permissions, err := authz.GetPermissions(db, manager)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{authz.PermEmployeeRead, authz.PermDepartmentRead}, permissions)
+
+	_, err = authz.UpdateRole(db, role.ID, rbac.Role{Name: "HR viewer"}, []string{authz.PermEmployeeRead}, "admin")
+	assert.NoError(t, err)
+	permissions, err = authz.GetPermissions(db, manager)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{authz.PermEmployeeRead}, permissions)
+
+	assert.NoError(t, authz.RevokeRole(db, manager, role.ID))
+	permissions, err = authz.GetPermissions(db, manager)
+	assert.NoError(t, err)
+	assert.Empty(t, permissions)
+
+	assert.NoError(t, authz.DeleteRole(db, role.ID))
+	assert.ErrorIs(t, authz.DeleteRole(db, role.ID), authz.ErrRoleNotFound)
+}
@@ -0,0 +1,51 @@
+package authz
+
+import "strings"
+
+// Permission codes are "<resource>.<action>", a role holding "<resource>.*"
+// or "*" is granted every action of the resource or everything.
+const (
+	PermAll = "*"
+
+	PermEmployeeRead    = "employee.read"
+	PermEmployeeWrite   = "employee.write"
+	PermDepartmentRead  = "department.read"
+	PermDepartmentWrite = "department.write"
+	PermPersonalRead    = "personal.read"
+	PermPersonalWrite   = "personal.write"
+	PermAccountRead     = "account.read"
+	PermAccountWrite    = "account.write"
+	PermRoleRead        = "role.read"
+	PermRoleWrite       = "role.write"
+	PermAuditRead       = "audit.read"
+)
+
+// TenantAdminRole is the system role seeded into every tenant, it holds PermAll
+const TenantAdminRole = "tenant-admin"
+
+// Permissions lists every permission known by the application with its description
+var Permissions = map[string]string{
+	PermAll:             "Full access to the tenant",
+	PermEmployeeRead:    "View employees",
+	PermEmployeeWrite:   "Create, update and delete employees",
+	PermDepartmentRead:  "View departments",
+	PermDepartmentWrite: "Create, update and delete departments",
+	PermPersonalRead:    "View personal information",
+	PermPersonalWrite:   "Update personal information",
+	PermAccountRead:     "View accounts",
+	PermAccountWrite:    "Create, update and delete accounts",
+	PermRoleRead:        "View roles and permissions",
+	PermRoleWrite:       "Manage roles and role assignments",
+	PermAuditRead:       "View the audit trail of data changes",
+}
+
+// Match reports whether a granted permission covers the required one
+func Match(granted string, required string) bool {
+	if granted == PermAll || granted == required {
+		return true
+	}
+	if resource, ok := strings.CutSuffix(granted, ".*"); ok {
+		return strings.HasPrefix(required, resource+".")
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package authz
+
+import (
+	"errors"
+	"time"
+
+	"vngom/models/account"
+	"vngom/models/rbac"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ListRoles returns the roles of the tenant with their permission codes
+func ListRoles(db *gorm.DB) ([]rbac.Role, error) {
+	var roles []rbac.Role
+	if err := db.Order("code").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if err := loadPermissions(db, &roles[i]); err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+// ListPermissions returns every permission registered in the tenant
+func ListPermissions(db *gorm.DB) ([]rbac.Permission, error) {
+	var permissions []rbac.Permission
+	err := db.Order("code").Find(&permissions).Error
+	return permissions, err
+}
+
+// CreateRole creates a custom role granted the given permission codes
+func CreateRole(db *gorm.DB, role *rbac.Role, permissionCodes []string, createdBy string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&rbac.Role{}).Where("code = ?", role.Code).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrRoleCodeExists
+		}
+		if err := validateDataScope(role); err != nil {
+			return err
+		}
+		permissions, err := findPermissions(tx, permissionCodes)
+		if err != nil {
+			return err
+		}
+		role.ID = uuid.New()
+		role.IsSystem = false
+		role.CreatedOn = time.Now().UTC()
+		role.CreatedBy = createdBy
+		if err := tx.Create(role).Error; err != nil {
+			return err
+		}
+		return setPermissions(tx, role, permissions)
+	})
+}
+
+// UpdateRole changes the name, description, data scope, two-factor requirement and permissions of a custom role
+func UpdateRole(db *gorm.DB, id uuid.UUID, changes rbac.Role, permissionCodes []string, modifiedBy string) (*rbac.Role, error) {
+	var role rbac.Role
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := getRole(tx, id, &role); err != nil {
+			return err
+		}
+		if role.IsSystem {
+			return ErrSystemRole
+		}
+		permissions, err := findPermissions(tx, permissionCodes)
+		if err != nil {
+			return err
+		}
+		if err := validateDataScope(&changes); err != nil {
+			return err
+		}
+		role.Name = changes.Name
+		role.Description = changes.Description
+		role.DataScope = changes.DataScope
+		role.RequireTwoFactor = changes.RequireTwoFactor
+		role.ModifiedOn = time.Now().UTC()
+		role.ModifiedBy = modifiedBy
+		if err := tx.Save(&role).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&rbac.RolePermission{}).Error; err != nil {
+			return err
+		}
+		return setPermissions(tx, &role, permissions)
+	})
+	return &role, err
+}
+
+// DeleteRole removes a custom role and its assignments
+func DeleteRole(db *gorm.DB, id uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var role rbac.Role
+		if err := getRole(tx, id, &role); err != nil {
+			return err
+		}
+		if role.IsSystem {
+			return ErrSystemRole
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&rbac.AccountRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&rbac.RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+}
+
+// AssignRole grants a role to an account of the tenant, assigning twice is a no-op
+func AssignRole(db *gorm.DB, accountID uuid.UUID, roleID uuid.UUID) error {
+	var role rbac.Role
+	if err := getRole(db, roleID, &role); err != nil {
+		return err
+	}
+	// on a shared database the account is looked up among the rows of the tenant
+	var count int64
+	if err := db.Model(&account.Account{}).Where("id = ?", accountID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNoAccount
+	}
+	assignment := rbac.AccountRole{AccountID: accountID, RoleID: roleID}
+	return db.Where(&assignment).FirstOrCreate(&assignment).Error
+}
+
+// RevokeRole removes a role from an account
+func RevokeRole(db *gorm.DB, accountID uuid.UUID, roleID uuid.UUID) error {
+	return db.Where("account_id = ? AND role_id = ?", accountID, roleID).Delete(&rbac.AccountRole{}).Error
+}
+
+// GetAccountRoles returns the roles assigned to an account
+func GetAccountRoles(db *gorm.DB, accountID uuid.UUID) ([]rbac.Role, error) {
+	var roles []rbac.Role
+	roleIDs := db.Model(&rbac.AccountRole{}).Select("role_id").Where("account_id = ?", accountID)
+	err := db.Where("id IN (?)", roleIDs).Order("code").Find(&roles).Error
+	return roles, err
+}
+
+func validateDataScope(role *rbac.Role) error {
+	switch role.DataScope {
+	case "":
+		role.DataScope = rbac.DataScopeAll
+	case rbac.DataScopeAll, rbac.DataScopeDepartment, rbac.DataScopeDepartmentTree:
+	default:
+		return ErrUnknownScope
+	}
+	return nil
+}
+
+func getRole(db *gorm.DB, id uuid.UUID, role *rbac.Role) error {
+	err := db.First(role, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRoleNotFound
+	}
+	return err
+}
+
+func loadPermissions(db *gorm.DB, role *rbac.Role) error {
+	permissionIDs := db.Model(&rbac.RolePermission{}).Select("permission_id").Where("role_id = ?", role.ID)
+	role.Permissions = []string{}
+	return db.Model(&rbac.Permission{}).Where("id IN (?)", permissionIDs).Order("code").Pluck("code", &role.Permissions).Error
+}
+
+func setPermissions(tx *gorm.DB, role *rbac.Role, permissions []rbac.Permission) error {
+	role.Permissions = make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		if err := tx.Create(&rbac.RolePermission{RoleID: role.ID, PermissionID: p.ID}).Error; err != nil {
+			return err
+		}
+		role.Permissions = append(role.Permissions, p.Code)
+	}
+	return nil
+}
+
+func findPermissions(db *gorm.DB, codes []string) ([]rbac.Permission, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	unique := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		unique[code] = true
+	}
+	var permissions []rbac.Permission
+	if err := db.Where("code IN ?", codes).Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	if len(permissions) != len(unique) {
+		return nil, ErrUnknownPerm
+	}
+	return permissions, nil
+}
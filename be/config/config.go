@@ -1,7 +1,9 @@
 package config
 
 import (
+	"log/slog"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,27 +18,268 @@ const (
 
 // DBConfig represents the database configuration.
 type DBConfig struct {
-	Type     DBType `yaml:"type"`
-	Name     string `yaml:"name"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	Otions   string `yaml:"options"`
+	Type     DBType       `yaml:"type"`
+	Name     string       `yaml:"name"`
+	User     string       `yaml:"user"`
+	Password Secret       `yaml:"password"`
+	Host     string       `yaml:"host"`
+	Port     int          `yaml:"port"`
+	Otions   string       `yaml:"options"`
+	Pool     DBPoolConfig `yaml:"pool"`
+	// Migration controls how schema migrations are rolled out to tenant databases
+	Migration MigrationConfig `yaml:"migration"`
+}
+
+// DBPoolConfig limits the connections kept to the catalog and tenant databases.
+// Zero values fall back to the defaults of the repo package.
+type DBPoolConfig struct {
+	// MaxOpenConns and MaxIdleConns apply to each database
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+	// MaxOpenDbs is the number of tenant databases kept open, the least recently used is closed beyond it
+	MaxOpenDbs int `yaml:"maxOpenDbs"`
+	// IdleTimeout closes a tenant database unused for that long
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// HealthCheckInterval is how often open databases are pinged, a failing one is reopened on next use
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+}
+
+// MigrationConfig controls the migration runner of the migrations package.
+type MigrationConfig struct {
+	// Concurrency is the number of tenant databases migrated at the same time
+	Concurrency int `yaml:"concurrency"`
 }
 type ServerConfig struct {
 	Host string `yaml:"host"`
-	Port string `yaml:"port"`
+	Port int    `yaml:"port"`
+	// Prefork starts a process per CPU sharing the port
+	Prefork      bool          `yaml:"prefork"`
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	IdleTimeout  time.Duration `yaml:"idleTimeout"`
+	// Concurrency is the maximum number of concurrent connections
+	Concurrency int `yaml:"concurrency"`
+	// BodyLimit is the largest request body in bytes
+	BodyLimit int `yaml:"bodyLimit"`
+	// TrustedProxies are the addresses or CIDR ranges whose ProxyHeader gives the client address
+	TrustedProxies []string  `yaml:"trustedProxies"`
+	ProxyHeader    string    `yaml:"proxyHeader"`
+	TLS            TLSConfig `yaml:"tls"`
+	// ShutdownDelay keeps serving after readiness turned false so load balancers stop routing
+	ShutdownDelay time.Duration `yaml:"shutdownDelay"`
+	// ShutdownTimeout bounds the drain of the requests and the stop of the application
+	ShutdownTimeout time.Duration     `yaml:"shutdownTimeout"`
+	Compression     CompressionConfig `yaml:"compression"`
+	// CORSOrigins are the origins allowed to call the API from a browser, "*" allows any
+	CORSOrigins []string        `yaml:"corsOrigins"`
+	RateLimit   RateLimitConfig `yaml:"rateLimit"`
+}
+
+// TLSConfig serves HTTPS when CertFile and KeyFile are set. ClientCAFile
+// enables mutual TLS, clients must present a certificate signed by it.
+type TLSConfig struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// CompressionConfig compresses the responses, Level is speed, default or best
+type CompressionConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Level   string `yaml:"level"`
+}
+
+// RateLimitConfig limits the requests of a client address to Max per Window, zero disables it.
+type RateLimitConfig struct {
+	Max    int           `yaml:"max"`
+	Window time.Duration `yaml:"window"`
+}
+
+// HealthConfig tunes the readiness probe
+type HealthConfig struct {
+	// Timeout bounds each check
+	Timeout time.Duration `yaml:"timeout"`
+	// TenantSample is the number of open tenant databases checked on each probe
+	TenantSample int `yaml:"tenantSample"`
+}
+
+// MetricsConfig exposes the Prometheus metrics on Path
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	// TenantLabel labels the HTTP metrics with the tenant, at most MaxTenants
+	// distinct ones, the others are labelled "other"
+	TenantLabel bool `yaml:"tenantLabel"`
+	MaxTenants  int  `yaml:"maxTenants"`
+}
+
+// TracingConfig exports OpenTelemetry traces with the otlp, stdout or file exporter
+type TracingConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Exporter    string `yaml:"exporter"`
+	ServiceName string `yaml:"serviceName"`
+	// Endpoint is the host:port of the OTLP/HTTP collector
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	// File receives the spans of the file exporter
+	File string `yaml:"file"`
+	// SampleRatio is the fraction of the traces started here that are recorded,
+	// the decision of an incoming traceparent is kept
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// LogConfig controls the application logs.
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is json or console
+	Format string `yaml:"format"`
+	// Sinks receive every record, the standard error when empty
+	Sinks  []LogSinkConfig `yaml:"sinks"`
+	Access AccessLogConfig `yaml:"access"`
+}
+
+// AccessLogConfig controls the log of each HTTP request
+type AccessLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SampleRate is the fraction of the successful requests logged, failed
+	// requests and requests slower than SlowThreshold are always logged
+	SampleRate    float64       `yaml:"sampleRate"`
+	SlowThreshold time.Duration `yaml:"slowThreshold"`
+	// Headers and Bodies add the request headers and JSON body, with their secrets masked
+	Headers bool `yaml:"headers"`
+	Bodies  bool `yaml:"bodies"`
+}
+
+// LogSinkConfig is a destination of the logs
+type LogSinkConfig struct {
+	// Type is stdout, stderr or file
+	Type string `yaml:"type"`
+	// Format overrides the format of the log section
+	Format string `yaml:"format"`
+	Path   string `yaml:"path"`
+	// MaxSize rotates the file once it reaches this many megabytes
+	MaxSize int `yaml:"maxSize"`
+	// RotateEvery rotates the file at each multiple of this interval, such as 24h
+	RotateEvery time.Duration `yaml:"rotateEvery"`
+	// MaxBackups and MaxAge bound the rotated files kept, zero keeps them all
+	MaxBackups int           `yaml:"maxBackups"`
+	MaxAge     time.Duration `yaml:"maxAge"`
+	// Compress gzips the rotated files
+	Compress bool `yaml:"compress"`
+}
+
+// AuthConfig represents the settings used to issue and verify access tokens.
+type AuthConfig struct {
+	JwtSecret Secret        `yaml:"jwtSecret"`
+	TokenTTL  time.Duration `yaml:"tokenTTL"`
+	// PermissionCacheTTL is how long the permissions of an account are cached
+	PermissionCacheTTL time.Duration `yaml:"permissionCacheTTL"`
+	// Lifetime of the single use tokens sent by email
+	ResetTokenTTL  time.Duration `yaml:"resetTokenTTL"`
+	VerifyTokenTTL time.Duration `yaml:"verifyTokenTTL"`
+	InviteTokenTTL time.Duration `yaml:"inviteTokenTTL"`
+	// ChallengeTTL is the lifetime of the token between the password and the TOTP step of a login
+	ChallengeTTL time.Duration `yaml:"challengeTTL"`
+	// TwoFactorIssuer is the name shown by authenticator apps
+	TwoFactorIssuer string `yaml:"twoFactorIssuer"`
+}
+
+// PasswordConfig is the password policy applied to accounts of every tenant.
+type PasswordConfig struct {
+	MinLength     int  `yaml:"minLength"`
+	RequireUpper  bool `yaml:"requireUpper"`
+	RequireLower  bool `yaml:"requireLower"`
+	RequireDigit  bool `yaml:"requireDigit"`
+	RequireSymbol bool `yaml:"requireSymbol"`
+	// History is the number of previous passwords that cannot be reused
+	History int `yaml:"history"`
+	// MaxAge forces a password change once exceeded, zero disables expiry
+	MaxAge time.Duration `yaml:"maxAge"`
+	// MaxFailedAttempts locks the account for LockoutDuration, zero disables lockout
+	MaxFailedAttempts int           `yaml:"maxFailedAttempts"`
+	LockoutDuration   time.Duration `yaml:"lockoutDuration"`
+}
+
+// MailConfig selects how emails are delivered.
+// Driver is "smtp", "file" (writes .eml files to Dir) or "memory" (kept in process, for tests).
+type MailConfig struct {
+	Driver   string `yaml:"driver"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
+	From     string `yaml:"from"`
+	Dir      string `yaml:"dir"`
+	// LinkBaseURL is the address of the web application used to build links in emails
+	LinkBaseURL string `yaml:"linkBaseURL"`
+}
+
+// TenancyConfig tells how the tenant of a request is found.
+// Strategies are tried in order, the first one finding a tenant wins:
+// "path" (the :tenant segment of /api/:tenant), "subdomain" (the label before
+// BaseDomain), "header" (Header, X-Tenant-ID by default) and "jwt" (the tenant
+// claim of the access token). Without "path" routes are mounted under /api.
+type TenancyConfig struct {
+	Strategies []string `yaml:"strategies"`
+	BaseDomain string   `yaml:"baseDomain"`
+	Header     string   `yaml:"header"`
+	// CacheTTL is how long the catalog entry of a tenant is cached
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+	// SharedDatabase hosts the tenants provisioned with the shared isolation,
+	// their rows are told apart by TenantID. Shared tenants are refused while it is empty.
+	SharedDatabase string `yaml:"sharedDatabase"`
+}
+
+// PlatformConfig protects the platform administration API managing the tenants.
+// The API is disabled while AdminKey is empty.
+type PlatformConfig struct {
+	// AdminKey is expected in the X-Platform-Key header
+	AdminKey Secret `yaml:"adminKey"`
+	// PurgeRetention is how long a deleted tenant is kept before its database can be dropped
+	PurgeRetention time.Duration `yaml:"purgeRetention"`
 }
 type Config struct {
-	DB     DBConfig     `yaml:"db"`
-	Server ServerConfig `yaml:"server"`
+	DB       DBConfig       `yaml:"db"`
+	Server   ServerConfig   `yaml:"server"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Password PasswordConfig `yaml:"password"`
+	Mail     MailConfig     `yaml:"mail"`
+	Tenancy  TenancyConfig  `yaml:"tenancy"`
+	Platform PlatformConfig `yaml:"platform"`
+	Log      LogConfig      `yaml:"log"`
+	Health   HealthConfig   `yaml:"health"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	// DBProfile names the entry of DBProfiles that overrides DB
+	DBProfile  string              `yaml:"dbProfile"`
+	DBProfiles map[string]DBConfig `yaml:"dbProfiles"`
+	// TenantDefaults are overlaid per tenant by the settings of the catalog
+	TenantDefaults TenantDefaults `yaml:"tenantDefaults"`
 	// Add other configurations here if needed.
 }
 type IConfig interface {
 	GetDBConfig() DBConfig
 	GetServerConfig() ServerConfig
+	GetAuthConfig() AuthConfig
+	GetPasswordConfig() PasswordConfig
+	GetMailConfig() MailConfig
+	GetTenancyConfig() TenancyConfig
+	GetPlatformConfig() PlatformConfig
+	GetLogConfig() LogConfig
+	GetHealthConfig() HealthConfig
+	GetMetricsConfig() MetricsConfig
+	GetTracingConfig() TracingConfig
+	// GetTenantDefaults returns the configuration of a tenant without overrides
+	GetTenantDefaults() TenantConfig
 	LoadConfig(filePath string) error
+	Validate() error
 }
 
 func (c *Config) GetDBConfig() DBConfig {
@@ -47,6 +290,54 @@ func (c *Config) GetServerConfig() ServerConfig {
 	return c.Server
 }
 
+func (c *Config) GetAuthConfig() AuthConfig {
+	return c.Auth
+}
+
+func (c *Config) GetPasswordConfig() PasswordConfig {
+	return c.Password
+}
+
+func (c *Config) GetMailConfig() MailConfig {
+	return c.Mail
+}
+
+func (c *Config) GetTenancyConfig() TenancyConfig {
+	return c.Tenancy
+}
+
+func (c *Config) GetPlatformConfig() PlatformConfig {
+	return c.Platform
+}
+
+func (c *Config) GetLogConfig() LogConfig {
+	return c.Log
+}
+
+func (c *Config) GetHealthConfig() HealthConfig {
+	return c.Health
+}
+
+func (c *Config) GetMetricsConfig() MetricsConfig {
+	return c.Metrics
+}
+
+func (c *Config) GetTracingConfig() TracingConfig {
+	return c.Tracing
+}
+
+func (c *Config) GetTenantDefaults() TenantConfig {
+	return TenantConfig{
+		Locale:        c.TenantDefaults.Locale,
+		Timezone:      c.TenantDefaults.Timezone,
+		Features:      c.TenantDefaults.Features,
+		MaxUploadSize: c.TenantDefaults.MaxUploadSize,
+		Password:      c.Password,
+		DBHost:        c.DB.Host,
+		DBPort:        c.DB.Port,
+	}
+}
+
 func (c *Config) LoadConfig(filePath string) error {
 	// read the file content
 
@@ -59,9 +350,19 @@ func (c *Config) LoadConfig(filePath string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return c.resolveReferences(os.Environ())
 }
 
 func NewConfig() IConfig {
 	return &Config{}
 }
+
+// ParseLevel returns the slog level of log.level, info when empty
+func ParseLevel(level string) (slog.Level, error) {
+	var ret slog.Level
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	err := ret.UnmarshalText([]byte(level))
+	return ret, err
+}
@@ -0,0 +1,316 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables overriding a setting:
+// QUICKY_DB_HOST sets db.host and QUICKY_DB_POOL_MAX_OPEN_CONNS db.pool.maxOpenConns.
+const EnvPrefix = "QUICKY_"
+
+const defaultFile = "config.yaml"
+
+var errUnknownSetting = errors.New("unknown setting")
+
+// controlVars are the QUICKY_ environment variables that are not settings
+var controlVars = map[string]bool{
+	"ENV":             true,
+	"MASTER_KEY":      true,
+	"MASTER_KEY_FILE": true,
+}
+
+// LoadOptions tells where the layers of the configuration come from. From the
+// lowest to the highest priority: Defaults, File, the file of Env, the DB
+// profile, QUICKY_ environment variables then Sets.
+type LoadOptions struct {
+	// File is the base file, config.yaml of the working directory by default.
+	// It is optional unless set explicitly.
+	File string
+	// Env names the environment, config.<Env>.yaml next to File is layered over
+	// File when it exists. QUICKY_ENV by default.
+	Env string
+	// Profile selects the entry of dbProfiles used as database, it overrides dbProfile
+	Profile string
+	// Sets are key=value settings of the command line such as db.host=10.0.0.5
+	Sets []string
+	// Environ is the environment in os.Environ form, os.Environ() by default
+	Environ []string
+}
+
+type setFlags struct {
+	values *[]string
+}
+
+func (s setFlags) String() string {
+	if s.values == nil {
+		return ""
+	}
+	return strings.Join(*s.values, ",")
+}
+
+func (s setFlags) Set(value string) error {
+	*s.values = append(*s.values, value)
+	return nil
+}
+
+// BindFlags declares the -config, -env, -profile and -set flags on fs, the
+// returned options are filled once fs is parsed
+func BindFlags(fs *flag.FlagSet) *LoadOptions {
+	opts := &LoadOptions{}
+	fs.StringVar(&opts.File, "config", "", "base configuration file (default config.yaml)")
+	fs.StringVar(&opts.Env, "env", "", "environment, config.<env>.yaml is layered over the base file (default $QUICKY_ENV)")
+	fs.StringVar(&opts.Profile, "profile", "", "entry of dbProfiles used as database")
+	fs.Var(setFlags{values: &opts.Sets}, "set", "override a setting, key=value such as db.host=10.0.0.5 (repeatable)")
+	return opts
+}
+
+// Defaults returns the settings used when no layer sets them
+func Defaults() *Config {
+	return &Config{
+		DB: DBConfig{Type: DBTypePostgres, Host: "localhost", Port: 5432},
+		Server: ServerConfig{
+			Host:            "0.0.0.0",
+			Port:            8080,
+			ReadTimeout:     30 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			Concurrency:     256 * 1024,
+			BodyLimit:       4 * 1024 * 1024,
+			ProxyHeader:     "X-Forwarded-For",
+			ShutdownTimeout: 30 * time.Second,
+			Compression:     CompressionConfig{Level: "default"},
+		},
+		Auth: AuthConfig{
+			TokenTTL:           8 * time.Hour,
+			PermissionCacheTTL: time.Minute,
+			ResetTokenTTL:      time.Hour,
+			VerifyTokenTTL:     48 * time.Hour,
+			InviteTokenTTL:     7 * 24 * time.Hour,
+			ChallengeTTL:       5 * time.Minute,
+		},
+		Password: PasswordConfig{MinLength: 10},
+		Mail:     MailConfig{Driver: "file", Dir: "./mails"},
+		Tenancy:  TenancyConfig{Strategies: []string{"path"}, CacheTTL: time.Minute},
+		Platform: PlatformConfig{PurgeRetention: 30 * 24 * time.Hour},
+		// the two-factor enrollment routes are served unless a tenant turns twoFactor off
+		TenantDefaults: TenantDefaults{Features: map[string]bool{"twoFactor": true}},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+			Access: AccessLogConfig{Enabled: true, SampleRate: 1, SlowThreshold: time.Second},
+		},
+		Health:  HealthConfig{Timeout: 2 * time.Second, TenantSample: 3},
+		Metrics: MetricsConfig{Enabled: true, Path: "/metrics", MaxTenants: 100},
+		Tracing: TracingConfig{
+			Exporter:    "stdout",
+			ServiceName: "vngom",
+			Endpoint:    "localhost:4318",
+			File:        "./logs/traces.json",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load builds the configuration from its layers. Errors of every layer are
+// reported at once, the result still has to be checked with Validate.
+func Load(opts LoadOptions) (*Config, error) {
+	environ := opts.Environ
+	if environ == nil {
+		environ = os.Environ()
+	}
+	env := map[string]string{}
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(key, EnvPrefix) {
+			env[strings.TrimPrefix(key, EnvPrefix)] = value
+		}
+	}
+
+	c := Defaults()
+	file := opts.File
+	if file == "" {
+		file = defaultFile
+	}
+	if err := c.loadFile(file, opts.File != ""); err != nil {
+		return nil, err
+	}
+	envName := opts.Env
+	if envName == "" {
+		envName = env["ENV"]
+	}
+	if envName != "" {
+		ext := filepath.Ext(file)
+		if err := c.loadFile(strings.TrimSuffix(file, ext)+"."+envName+ext, false); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	// the profile is applied before the variables and flags so they can still change it
+	profile := c.DBProfile
+	for _, key := range []string{"DBPROFILE", "DB_PROFILE"} {
+		if value, ok := env[key]; ok {
+			profile = value
+		}
+	}
+	for _, set := range opts.Sets {
+		if key, value, ok := strings.Cut(set, "="); ok && strings.EqualFold(key, "dbProfile") {
+			profile = value
+		}
+	}
+	if opts.Profile != "" {
+		profile = opts.Profile
+	}
+	if profile != "" {
+		db, ok := c.DBProfiles[profile]
+		if ok {
+			overlay(reflect.ValueOf(&c.DB).Elem(), reflect.ValueOf(db))
+		} else {
+			errs = append(errs, fmt.Errorf("dbProfile %q is not defined in dbProfiles", profile))
+		}
+		c.DBProfile = profile
+	}
+
+	root := reflect.ValueOf(c).Elem()
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := env[key]
+		if controlVars[key] {
+			continue
+		}
+		if err := assign(root, strings.Split(strings.ToLower(key), "_"), value, true); err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", EnvPrefix, key, err))
+		}
+	}
+	for _, set := range opts.Sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("-set %s: expected key=value", set))
+			continue
+		}
+		if err := assign(root, strings.Split(key, "."), value, false); err != nil {
+			errs = append(errs, fmt.Errorf("-set %s: %w", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	// references are resolved last, the variables and flags may hold some too
+	if err := c.resolveReferences(environ); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile layers a YAML file over c, a missing file is skipped unless required
+func (c *Config) loadFile(path string, required bool) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// assign sets the setting found at path. With joinable, as for environment
+// variables, consecutive segments may form one key: max_open_conns matches maxOpenConns.
+func assign(v reflect.Value, path []string, value string, joinable bool) error {
+	if len(path) == 0 {
+		return assignValue(v, value)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for n := 1; n <= len(path); n++ {
+			name := strings.Join(path[:n], "")
+			for i := 0; i < t.NumField(); i++ {
+				tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+				if tag == "" || tag == "-" || !strings.EqualFold(tag, name) {
+					continue
+				}
+				err := assign(v.Field(i), path[n:], value, joinable)
+				if !errors.Is(err, errUnknownSetting) {
+					return err
+				}
+			}
+			if !joinable {
+				break
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		key, rest := path[0], path[1:]
+		if v.Type().Elem().Kind() != reflect.Struct {
+			// the rest of the path is the key, environment variables lose its case
+			key, rest = strings.Join(path, "."), nil
+			if joinable {
+				key = strings.Join(path, "_")
+			}
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for _, existing := range v.MapKeys() {
+			name := existing.String()
+			if joinable && (strings.EqualFold(name, key) || strings.EqualFold(name, strings.Join(path, ""))) {
+				key = name
+			}
+		}
+		mapKey := reflect.ValueOf(key).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if current := v.MapIndex(mapKey); current.IsValid() {
+			elem.Set(current)
+		}
+		if err := assign(elem, rest, value, joinable); err != nil {
+			return err
+		}
+		v.SetMapIndex(mapKey, elem)
+		return nil
+	}
+	return errUnknownSetting
+}
+
+// assignValue parses value as YAML into v, strings are taken as is
+func assignValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+	if v.Kind() == reflect.Struct || v.Kind() == reflect.Map {
+		return errUnknownSetting
+	}
+	return yaml.Unmarshal([]byte(value), v.Addr().Interface())
+}
+
+// overlay copies the fields of src that are set into dst
+func overlay(dst reflect.Value, src reflect.Value) {
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			overlay(dst.Field(i), field)
+		case !field.IsZero():
+			dst.Field(i).Set(field)
+		}
+	}
+}
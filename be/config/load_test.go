@@ -0,0 +1,122 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"vngom/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const envSecret = "secret-from-the-environment-0123456789"
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.yaml", `
+db:
+  name: saas
+  user: postgres
+  host: localhost
+  pool:
+    maxOpenConns: 20
+dbProfiles:
+  mysql:
+    type: mysql
+    port: 3306
+auth:
+  jwtSecret: base-secret-of-at-least-32-bytes
+tenantDefaults:
+  features:
+    twoFactor: true
+`)
+	writeFile(t, dir, "config.production.yaml", `
+db:
+  host: db.production
+auth:
+  tokenTTL: 1h
+`)
+
+	c, err := config.Load(config.LoadOptions{
+		File: file,
+		Environ: []string{
+			"QUICKY_ENV=production",
+			"QUICKY_DB_PROFILE=mysql",
+			"QUICKY_DB_POOL_MAX_OPEN_CONNS=50",
+			"QUICKY_AUTH_JWTSECRET=" + envSecret,
+			"QUICKY_TENANTDEFAULTS_FEATURES_TWOFACTOR=false",
+			"PATH=/usr/bin",
+		},
+		Sets: []string{"db.host=10.0.0.5", "tenancy.strategies=[header, jwt]"},
+	})
+	assert.NoError(t, err)
+	// defaults
+	assert.Equal(t, 8080, c.Server.Port)
+	assert.Equal(t, 5*time.Minute, c.Auth.ChallengeTTL)
+	// environment file over base file
+	assert.Equal(t, time.Hour, c.Auth.TokenTTL)
+	// profile over files
+	assert.Equal(t, config.DBTypeMySQL, c.DB.Type)
+	assert.Equal(t, 3306, c.DB.Port)
+	assert.Equal(t, "saas", c.DB.Name)
+	// variables over profile
+	assert.Equal(t, 50, c.DB.Pool.MaxOpenConns)
+	assert.Equal(t, envSecret, c.Auth.JwtSecret.Value())
+	assert.False(t, c.TenantDefaults.Features["twoFactor"])
+	// flags over everything
+	assert.Equal(t, "10.0.0.5", c.DB.Host)
+	assert.Equal(t, []string{"header", "jwt"}, c.Tenancy.Strategies)
+	assert.NoError(t, c.Validate())
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	_, err := config.Load(config.LoadOptions{
+		File:    filepath.Join(t.TempDir(), "missing.yaml"),
+		Environ: []string{},
+	})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = config.Load(config.LoadOptions{
+		File:    writeFile(t, t.TempDir(), "config.yaml", "db:\n  name: saas\n"),
+		Profile: "oracle",
+		Environ: []string{"QUICKY_DB_PORT=abc", "QUICKY_DB_HOSTNAME=x"},
+		Sets:    []string{"server.nope=1", "novalue"},
+	})
+	if assert.Error(t, err) {
+		for _, want := range []string{"oracle", "QUICKY_DB_PORT", "QUICKY_DB_HOSTNAME", "server.nope", "novalue"} {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := config.Defaults()
+	c.Server.Port = 0
+	c.Mail.Driver = "smtp"
+	c.Tenancy.Strategies = []string{"subdomain", "cookie"}
+	err := c.Validate()
+	if assert.Error(t, err) {
+		for _, want := range []string{"db.name", "db.user", "server.port", "auth.jwtSecret", "mail.host", "tenancy.baseDomain", "cookie"} {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+
+	for secret, want := range map[string]string{
+		"change-me":                       "placeholder",
+		"please-change-me-before-running": "placeholder",
+		"too-short":                       "at least 32 bytes",
+	} {
+		c.Auth.JwtSecret = config.Secret(secret)
+		assert.ErrorContains(t, c.Validate(), want)
+	}
+}
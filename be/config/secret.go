@@ -0,0 +1,229 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+const redacted = "******"
+
+// MasterKeyEnv holds the base64 AES-256 key decrypting the ${enc:...} references,
+// MasterKeyFileEnv the path of a file holding it
+const (
+	MasterKeyEnv     = EnvPrefix + "MASTER_KEY"
+	MasterKeyFileEnv = EnvPrefix + "MASTER_KEY_FILE"
+)
+
+var (
+	ErrMasterKey  = errors.New("encrypted settings require a 32 bytes base64 key in " + MasterKeyEnv + " or " + MasterKeyFileEnv)
+	ErrDecrypt    = errors.New("cannot decrypt setting, wrong master key or corrupted value")
+	errUnresolved = errors.New("unknown reference, expected ${env:NAME}, ${file:PATH} or ${enc:VALUE}")
+	reference     = regexp.MustCompile(`\$\{([a-z]+):([^}]*)\}`)
+)
+
+// Secret is a setting that never appears in logs, error messages or dumps of
+// the configuration. Value returns it.
+type Secret string
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// Encrypt returns the ${enc:...} reference of a value for the master key
+func Encrypt(key []byte, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return "${enc:" + base64.StdEncoding.EncodeToString(sealed) + "}", nil
+}
+
+// MasterKey reads the master key from the environment, environ is in os.Environ form
+func MasterKey(environ []string) ([]byte, error) {
+	env := environMap(environ)
+	encoded, ok := env[MasterKeyEnv]
+	if path := env[MasterKeyFileEnv]; !ok && path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", MasterKeyFileEnv, err)
+		}
+		encoded = string(content)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		return nil, ErrMasterKey
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrMasterKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func environMap(environ []string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// references replaces ${env:NAME}, ${file:PATH} and ${enc:VALUE} in settings.
+// Its errors name the reference, never the resolved value.
+type references struct {
+	environ []string
+	env     map[string]string
+	key     []byte
+}
+
+func (r *references) resolve(value string) (string, error) {
+	var errs []error
+	ret := reference.ReplaceAllStringFunc(value, func(ref string) string {
+		match := reference.FindStringSubmatch(ref)
+		resolved, err := r.lookup(match[1], match[2])
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return resolved
+	})
+	return ret, errors.Join(errs...)
+}
+
+func (r *references) lookup(kind string, arg string) (string, error) {
+	switch kind {
+	case "env":
+		value, ok := r.env[arg]
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", arg)
+		}
+		return value, nil
+	case "file":
+		content, err := os.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case "enc":
+		if r.key == nil {
+			key, err := MasterKey(r.environ)
+			if err != nil {
+				return "", err
+			}
+			r.key = key
+		}
+		gcm, err := newGCM(r.key)
+		if err != nil {
+			return "", err
+		}
+		sealed, err := base64.StdEncoding.DecodeString(arg)
+		if err != nil || len(sealed) < gcm.NonceSize() {
+			return "", ErrDecrypt
+		}
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil {
+			return "", ErrDecrypt
+		}
+		return string(plain), nil
+	}
+	return "", errUnresolved
+}
+
+// resolveReferences replaces the references in every string setting of c
+func (c *Config) resolveReferences(environ []string) error {
+	r := &references{environ: environ, env: environMap(environ)}
+	return resolveValue(reflect.ValueOf(c).Elem(), "", r)
+}
+
+func resolveValue(v reflect.Value, path string, r *references) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !strings.Contains(v.String(), "${") {
+			return nil
+		}
+		resolved, err := r.resolve(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetString(resolved)
+	case reflect.Struct:
+		var errs []error
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			errs = append(errs, resolveValue(v.Field(i), join(path, name), r))
+		}
+		return errors.Join(errs...)
+	case reflect.Slice:
+		var errs []error
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, resolveValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), r))
+		}
+		return errors.Join(errs...)
+	case reflect.Map:
+		var errs []error
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			errs = append(errs, resolveValue(elem, join(path, fmt.Sprint(key)), r))
+			v.SetMapIndex(key, elem)
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,66 @@
+package config_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"vngom/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretReferences(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, 32)
+	encrypted, err := config.Encrypt(key, "smtp-pass")
+	assert.NoError(t, err)
+	writeFile(t, dir, "db_password", "from-file\n")
+	file := writeFile(t, dir, "config.yaml", `
+db:
+  password: ${file:`+filepath.Join(dir, "db_password")+`}
+auth:
+  jwtSecret: ${env:JWT_SECRET}
+mail:
+  password: `+encrypted+`
+`)
+
+	c, err := config.Load(config.LoadOptions{
+		File:    file,
+		Environ: []string{"JWT_SECRET=from-env", config.MasterKeyEnv + "=" + base64.StdEncoding.EncodeToString(key)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", c.DB.Password.Value())
+	assert.Equal(t, "from-env", c.Auth.JwtSecret.Value())
+	assert.Equal(t, "smtp-pass", c.Mail.Password.Value())
+
+	// a wrong key or a missing variable is reported without the value
+	_, err = config.Load(config.LoadOptions{
+		File:    file,
+		Environ: []string{config.MasterKeyEnv + "=" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))},
+	})
+	if assert.Error(t, err) {
+		assert.ErrorIs(t, err, config.ErrDecrypt)
+		assert.Contains(t, err.Error(), "JWT_SECRET")
+		assert.NotContains(t, err.Error(), "from-file")
+	}
+}
+
+func TestSecretIsRedacted(t *testing.T) {
+	c := config.Defaults()
+	c.DB.Password = "s3cr3t"
+	c.Auth.JwtSecret = "s3cr3t"
+
+	var logs bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logs, nil)).Info("config", "db", c.DB)
+	dump, err := json.Marshal(c)
+	assert.NoError(t, err)
+	for _, out := range []string{fmt.Sprint(c), fmt.Sprintf("%+v", c.DB), fmt.Sprintf("%#v", c.Auth), logs.String(), string(dump)} {
+		assert.NotContains(t, out, "s3cr3t")
+	}
+	assert.Equal(t, "s3cr3t", c.DB.Password.Value())
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const defaultWatchInterval = 2 * time.Second
+
+// RestartOnly are the settings read once at startup. A reload changing them
+// keeps their running value and reports them as requiring a restart.
+var RestartOnly = []string{
+	"db",
+	"dbProfile",
+	"dbProfiles",
+	"server.host",
+	"server.port",
+	"server.prefork",
+	"server.readTimeout",
+	"server.writeTimeout",
+	"server.idleTimeout",
+	"server.concurrency",
+	"server.bodyLimit",
+	"server.trustedProxies",
+	"server.proxyHeader",
+	"server.tls",
+	"server.compression",
+	"auth.jwtSecret",
+	"tenancy.strategies",
+	"tenancy.baseDomain",
+	"tenancy.header",
+	"tenancy.sharedDatabase",
+	"platform.adminKey",
+	"health",
+	"metrics",
+	"tracing",
+	"log.format",
+	"log.sinks",
+}
+
+// Store is an IConfig whose configuration is reloaded when its files change or
+// the process receives SIGHUP. Getters always return the current configuration,
+// components caching a setting subscribe to the changes.
+type Store struct {
+	opts    LoadOptions
+	current atomic.Pointer[Config]
+
+	lock        sync.Mutex
+	subscribers []func(old *Config, new *Config)
+}
+
+// NewStore loads and validates the configuration
+func NewStore(opts LoadOptions) (*Store, error) {
+	c, err := Load(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Store{opts: opts}
+	s.current.Store(c)
+	return s, nil
+}
+
+// Current returns the configuration in use, it must not be modified
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// Subscribe calls fn after every successful reload
+func (s *Store) Subscribe(fn func(old *Config, new *Config)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Reload loads and validates the configuration again then swaps it. An invalid
+// configuration is refused and the current one is kept. It returns the changed
+// settings that require a restart to apply.
+func (s *Store) Reload() ([]string, error) {
+	s.lock.Lock()
+	next, err := Load(s.opts)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		s.lock.Unlock()
+		return nil, err
+	}
+	old := s.current.Load()
+	var restart []string
+	for _, path := range RestartOnly {
+		running, loaded := fieldByPath(reflect.ValueOf(old).Elem(), path), fieldByPath(reflect.ValueOf(next).Elem(), path)
+		if !reflect.DeepEqual(running.Interface(), loaded.Interface()) {
+			restart = append(restart, path)
+			loaded.Set(running)
+		}
+	}
+	s.current.Store(next)
+	subscribers := append([]func(old *Config, new *Config){}, s.subscribers...)
+	s.lock.Unlock()
+	for _, fn := range subscribers {
+		fn(old, next)
+	}
+	return restart, nil
+}
+
+// Watch reloads the configuration when one of its files changes or on SIGHUP until ctx is done
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		stamp := s.stamp()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				stamp = s.stamp()
+				s.reload("SIGHUP")
+			case <-ticker.C:
+				if next := s.stamp(); next != stamp {
+					stamp = next
+					s.reload("file change")
+				}
+			}
+		}
+	}()
+}
+
+func (s *Store) reload(reason string) {
+	restart, err := s.Reload()
+	if err != nil {
+		logger().Error("reload refused, keeping the current configuration", "reason", reason, "err", err)
+		return
+	}
+	logger().Info("reloaded", "reason", reason)
+	if len(restart) > 0 {
+		logger().Warn("changes require a restart", "settings", restart)
+	}
+}
+
+func logger() *slog.Logger {
+	return slog.Default().With("logger", "config")
+}
+
+// stamp identifies the version of the files of the configuration
+func (s *Store) stamp() string {
+	var b strings.Builder
+	for _, file := range s.files() {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&b, "%s %d %d;", file, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return b.String()
+}
+
+// files lists the base file and the file of the environment
+func (s *Store) files() []string {
+	file := s.opts.File
+	if file == "" {
+		file = defaultFile
+	}
+	env := s.opts.Env
+	if env == "" {
+		env = os.Getenv(EnvPrefix + "ENV")
+	}
+	if env == "" {
+		return []string{file}
+	}
+	ext := filepath.Ext(file)
+	return []string{file, strings.TrimSuffix(file, ext) + "." + env + ext}
+}
+
+// fieldByPath returns the field of a dotted yaml path such as server.port
+func fieldByPath(v reflect.Value, path string) reflect.Value {
+	for _, name := range strings.Split(path, ".") {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0] == name {
+				v = v.Field(i)
+				break
+			}
+		}
+	}
+	return v
+}
+
+func (s *Store) GetDBConfig() DBConfig {
+	return s.Current().GetDBConfig()
+}
+
+func (s *Store) GetServerConfig() ServerConfig {
+	return s.Current().GetServerConfig()
+}
+
+func (s *Store) GetAuthConfig() AuthConfig {
+	return s.Current().GetAuthConfig()
+}
+
+func (s *Store) GetPasswordConfig() PasswordConfig {
+	return s.Current().GetPasswordConfig()
+}
+
+func (s *Store) GetMailConfig() MailConfig {
+	return s.Current().GetMailConfig()
+}
+
+func (s *Store) GetTenancyConfig() TenancyConfig {
+	return s.Current().GetTenancyConfig()
+}
+
+func (s *Store) GetPlatformConfig() PlatformConfig {
+	return s.Current().GetPlatformConfig()
+}
+
+func (s *Store) GetTenantDefaults() TenantConfig {
+	return s.Current().GetTenantDefaults()
+}
+
+func (s *Store) GetLogConfig() LogConfig {
+	return s.Current().GetLogConfig()
+}
+
+func (s *Store) GetHealthConfig() HealthConfig {
+	return s.Current().GetHealthConfig()
+}
+
+func (s *Store) GetMetricsConfig() MetricsConfig {
+	return s.Current().GetMetricsConfig()
+}
+
+func (s *Store) GetTracingConfig() TracingConfig {
+	return s.Current().GetTracingConfig()
+}
+
+// LoadConfig replaces the options with a single file and reloads
+func (s *Store) LoadConfig(filePath string) error {
+	s.lock.Lock()
+	s.opts = LoadOptions{File: filePath}
+	s.lock.Unlock()
+	_, err := s.Reload()
+	return err
+}
+
+func (s *Store) Validate() error {
+	return s.Current().Validate()
+}
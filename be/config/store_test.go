@@ -0,0 +1,55 @@
+package config_test
+
+import (
+	"testing"
+
+	"vngom/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const storeBase = `
+db:
+  name: saas
+  user: postgres
+auth:
+  jwtSecret: store-secret-of-at-least-32-bytes
+`
+
+func TestStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, "config.yaml", storeBase+"log:\n  level: info\n")
+	store, err := config.NewStore(config.LoadOptions{File: file, Environ: []string{}})
+	assert.NoError(t, err)
+	var notified *config.Config
+	store.Subscribe(func(_ *config.Config, c *config.Config) {
+		notified = c
+	})
+
+	// live settings are swapped, restart-only ones keep their running value
+	writeFile(t, dir, "config.yaml", storeBase+`
+server:
+  port: 9090
+  corsOrigins: [https://app.example.com]
+  rateLimit:
+    max: 100
+    window: 1m
+log:
+  level: debug
+`)
+	restart, err := store.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"server.port"}, restart)
+	assert.Equal(t, 8080, store.GetServerConfig().Port)
+	assert.Equal(t, []string{"https://app.example.com"}, store.GetServerConfig().CORSOrigins)
+	assert.Equal(t, 100, store.GetServerConfig().RateLimit.Max)
+	assert.Equal(t, "debug", store.GetLogConfig().Level)
+	assert.Same(t, store.Current(), notified)
+
+	// an invalid configuration is refused and the current one is kept
+	writeFile(t, dir, "config.yaml", storeBase+"log:\n  level: verbose\n")
+	_, err = store.Reload()
+	assert.ErrorContains(t, err, "log.level")
+	assert.Equal(t, "debug", store.GetLogConfig().Level)
+	assert.Same(t, store.Current(), notified)
+}
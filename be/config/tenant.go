@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TenantDefaults are the settings of the tenants without overrides.
+type TenantDefaults struct {
+	Locale   string `yaml:"locale"`
+	Timezone string `yaml:"timezone"`
+	// Features turns optional features on or off, unknown features are off
+	Features map[string]bool `yaml:"features"`
+	// MaxUploadSize is the largest uploaded file in bytes, zero means no limit
+	MaxUploadSize int64 `yaml:"maxUploadSize"`
+}
+
+// TenantConfig is the configuration seen by the requests of a tenant: the
+// global configuration with the overrides of the tenant applied.
+type TenantConfig struct {
+	Locale        string          `json:"locale"`
+	Timezone      string          `json:"timezone"`
+	Features      map[string]bool `json:"features"`
+	MaxUploadSize int64           `json:"maxUploadSize"`
+	Password      PasswordConfig  `json:"password"`
+	// DBHost and DBPort is the server holding the database of the tenant
+	DBHost string `json:"dbHost"`
+	DBPort int    `json:"dbPort"`
+}
+
+// Feature reports whether a feature is turned on
+func (t TenantConfig) Feature(name string) bool {
+	return t.Features[name]
+}
+
+// TenantOverrides are the settings a tenant changes, nil fields keep the global value.
+type TenantOverrides struct {
+	Locale   *string `json:"locale,omitempty"`
+	Timezone *string `json:"timezone,omitempty"`
+	// Features are merged into the global ones
+	Features      map[string]bool `json:"features,omitempty"`
+	MaxUploadSize *int64          `json:"maxUploadSize,omitempty"`
+	// Password replaces the whole global password policy
+	Password *PasswordConfig `json:"password,omitempty"`
+	// DBHost and DBPort place the database of a large tenant on a dedicated server
+	DBHost *string `json:"dbHost,omitempty"`
+	DBPort *int    `json:"dbPort,omitempty"`
+}
+
+// Validate reports every invalid override at once
+func (o TenantOverrides) Validate() error {
+	var errs []error
+	if o.Locale != nil && *o.Locale == "" {
+		errs = append(errs, errors.New("locale is empty"))
+	}
+	if o.Timezone != nil {
+		if _, err := time.LoadLocation(*o.Timezone); err != nil || *o.Timezone == "" {
+			errs = append(errs, fmt.Errorf("unknown timezone %q", *o.Timezone))
+		}
+	}
+	for name := range o.Features {
+		if name == "" {
+			errs = append(errs, errors.New("feature name is empty"))
+		}
+	}
+	if o.MaxUploadSize != nil && *o.MaxUploadSize < 0 {
+		errs = append(errs, errors.New("maxUploadSize is negative"))
+	}
+	if o.Password != nil && o.Password.MinLength <= 0 {
+		errs = append(errs, errors.New("password minLength must be positive"))
+	}
+	if o.DBHost != nil && *o.DBHost == "" {
+		errs = append(errs, errors.New("dbHost is empty"))
+	}
+	if o.DBPort != nil && (*o.DBPort <= 0 || *o.DBPort > 65535) {
+		errs = append(errs, fmt.Errorf("invalid dbPort %d", *o.DBPort))
+	}
+	return errors.Join(errs...)
+}
+
+// Apply returns base with the overrides applied, base is not modified
+func (o TenantOverrides) Apply(base TenantConfig) TenantConfig {
+	ret := base
+	ret.Features = make(map[string]bool, len(base.Features)+len(o.Features))
+	for name, on := range base.Features {
+		ret.Features[name] = on
+	}
+	for name, on := range o.Features {
+		ret.Features[name] = on
+	}
+	if o.Locale != nil {
+		ret.Locale = *o.Locale
+	}
+	if o.Timezone != nil {
+		ret.Timezone = *o.Timezone
+	}
+	if o.MaxUploadSize != nil {
+		ret.MaxUploadSize = *o.MaxUploadSize
+	}
+	if o.Password != nil {
+		ret.Password = *o.Password
+	}
+	if o.DBHost != nil {
+		ret.DBHost = *o.DBHost
+	}
+	if o.DBPort != nil {
+		ret.DBPort = *o.DBPort
+	}
+	return ret
+}
+
+// OverridesDB reports whether the database server of the tenant is not the global one
+func (o TenantOverrides) OverridesDB() bool {
+	return o.DBHost != nil || o.DBPort != nil
+}
+
+// WithTenant returns cfg with the settings of a tenant that it holds, such as the
+// password policy, replaced by the ones of tenant
+func WithTenant(cfg IConfig, tenant TenantConfig) IConfig {
+	return &tenantConfig{IConfig: cfg, tenant: tenant}
+}
+
+type tenantConfig struct {
+	IConfig
+	tenant TenantConfig
+}
+
+func (c *tenantConfig) GetPasswordConfig() PasswordConfig {
+	return c.tenant.Password
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// minJwtSecretLength matches the 256 bits of the HS256 key
+const minJwtSecretLength = 32
+
+// placeholders mark example secrets that must never sign tokens
+var placeholders = []string{"change-me", "changeme", "change_me", "replace-me", "your-secret"}
+
+func isPlaceholder(secret string) bool {
+	lower := strings.ToLower(secret)
+	for _, p := range placeholders {
+		if strings.Contains(lower, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate reports every missing or invalid setting at once, one per line
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch c.DB.Type {
+	case DBTypePostgres, DBTypeMySQL:
+	case "":
+		invalid("db.type is required")
+	default:
+		invalid("db.type %q is not supported, use %s or %s", c.DB.Type, DBTypePostgres, DBTypeMySQL)
+	}
+	if c.DB.Name == "" {
+		invalid("db.name is required")
+	}
+	if c.DB.Host == "" {
+		invalid("db.host is required")
+	}
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		invalid("db.port %d is not a valid port", c.DB.Port)
+	}
+	if c.DB.User == "" {
+		invalid("db.user is required")
+	}
+	if c.DB.Pool.MaxOpenConns < 0 || c.DB.Pool.MaxIdleConns < 0 || c.DB.Pool.MaxOpenDbs < 0 {
+		invalid("db.pool limits cannot be negative")
+	}
+	if c.DB.Migration.Concurrency < 0 {
+		invalid("db.migration.concurrency cannot be negative")
+	}
+
+	if c.Server.Host == "" {
+		invalid("server.host is required")
+	}
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		invalid("server.port %d is not a valid port", c.Server.Port)
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 || c.Server.ShutdownDelay < 0 || c.Server.ShutdownTimeout < 0 {
+		invalid("server timeouts cannot be negative")
+	}
+	if c.Server.Concurrency < 0 || c.Server.BodyLimit < 0 {
+		invalid("server.concurrency and server.bodyLimit cannot be negative")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			invalid("server.trustedProxies: %q is not an address or a CIDR range", proxy)
+		}
+	}
+	if len(c.Server.TrustedProxies) > 0 && c.Server.ProxyHeader == "" {
+		invalid("server.proxyHeader is required with server.trustedProxies")
+	}
+	if tls := c.Server.TLS; tls.Enabled() {
+		if tls.CertFile == "" || tls.KeyFile == "" {
+			invalid("server.tls needs both certFile and keyFile")
+		}
+		for name, file := range map[string]string{"certFile": tls.CertFile, "keyFile": tls.KeyFile, "clientCAFile": tls.ClientCAFile} {
+			if _, err := os.Stat(file); file != "" && err != nil {
+				invalid("server.tls.%s: %v", name, err)
+			}
+		}
+	} else if tls.ClientCAFile != "" {
+		invalid("server.tls.clientCAFile requires certFile and keyFile")
+	}
+	switch c.Server.Compression.Level {
+	case "", "speed", "default", "best":
+	default:
+		invalid("server.compression.level %q is not one of speed, default or best", c.Server.Compression.Level)
+	}
+
+	if c.Server.RateLimit.Max < 0 || (c.Server.RateLimit.Max > 0 && c.Server.RateLimit.Window <= 0) {
+		invalid("server.rateLimit needs a positive max and window")
+	}
+	if _, err := ParseLevel(c.Log.Level); err != nil {
+		invalid("log.level: %v", err)
+	}
+	validFormat := func(format string) bool {
+		return format == "" || format == "json" || format == "console"
+	}
+	if !validFormat(c.Log.Format) {
+		invalid("log.format %q is not one of json or console", c.Log.Format)
+	}
+	if c.Log.Access.SampleRate < 0 || c.Log.Access.SampleRate > 1 {
+		invalid("log.access.sampleRate must be between 0 and 1")
+	}
+	for i, sink := range c.Log.Sinks {
+		switch sink.Type {
+		case "stdout", "stderr":
+		case "file":
+			if sink.Path == "" {
+				invalid("log.sinks[%d].path is required by the file sink", i)
+			}
+		default:
+			invalid("log.sinks[%d].type %q is not one of stdout, stderr or file", i, sink.Type)
+		}
+		if !validFormat(sink.Format) {
+			invalid("log.sinks[%d].format %q is not one of json or console", i, sink.Format)
+		}
+		if sink.MaxSize < 0 || sink.RotateEvery < 0 || sink.MaxBackups < 0 || sink.MaxAge < 0 {
+			invalid("log.sinks[%d] rotation settings cannot be negative", i)
+		}
+	}
+
+	if c.Health.Timeout < 0 || c.Health.TenantSample < 0 {
+		invalid("health.timeout and health.tenantSample cannot be negative")
+	}
+
+	if c.Metrics.Enabled && !strings.HasPrefix(c.Metrics.Path, "/") {
+		invalid("metrics.path %q must start with /", c.Metrics.Path)
+	}
+	if c.Metrics.MaxTenants < 0 {
+		invalid("metrics.maxTenants cannot be negative")
+	}
+
+	if c.Tracing.Enabled {
+		switch c.Tracing.Exporter {
+		case "otlp":
+			if c.Tracing.Endpoint == "" {
+				invalid("tracing.endpoint is required by the otlp exporter")
+			}
+		case "file":
+			if c.Tracing.File == "" {
+				invalid("tracing.file is required by the file exporter")
+			}
+		case "stdout":
+		default:
+			invalid("tracing.exporter %q is not one of otlp, stdout or file", c.Tracing.Exporter)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sampleRatio must be between 0 and 1")
+	}
+
+	switch secret := c.Auth.JwtSecret.Value(); {
+	case secret == "":
+		invalid("auth.jwtSecret is required")
+	case isPlaceholder(secret):
+		invalid("auth.jwtSecret is a placeholder, set a random secret")
+	case len(secret) < minJwtSecretLength:
+		invalid("auth.jwtSecret must be at least %d bytes long", minJwtSecretLength)
+	}
+	if c.Auth.TokenTTL <= 0 {
+		invalid("auth.tokenTTL must be positive")
+	}
+
+	if c.Password.MinLength <= 0 {
+		invalid("password.minLength must be positive")
+	}
+	if c.Password.MaxFailedAttempts > 0 && c.Password.LockoutDuration <= 0 {
+		invalid("password.lockoutDuration is required with password.maxFailedAttempts")
+	}
+
+	switch strings.ToLower(c.Mail.Driver) {
+	case "smtp":
+		if c.Mail.Host == "" || c.Mail.Port <= 0 {
+			invalid("mail.host and mail.port are required by the smtp driver")
+		}
+	case "file", "", "memory":
+	default:
+		invalid("mail.driver %q is not one of smtp, file or memory", c.Mail.Driver)
+	}
+
+	for _, name := range c.Tenancy.Strategies {
+		switch strings.ToLower(name) {
+		case "path", "header", "jwt":
+		case "subdomain":
+			if c.Tenancy.BaseDomain == "" {
+				invalid("tenancy.baseDomain is required by the subdomain strategy")
+			}
+		default:
+			invalid("tenancy.strategies: unknown strategy %q", name)
+		}
+	}
+	if c.Tenancy.SharedDatabase != "" && c.Tenancy.SharedDatabase == c.DB.Name {
+		invalid("tenancy.sharedDatabase cannot be the catalog database")
+	}
+
+	if _, err := time.LoadLocation(c.TenantDefaults.Timezone); err != nil {
+		invalid("tenantDefaults.timezone %q is unknown", c.TenantDefaults.Timezone)
+	}
+	if c.TenantDefaults.MaxUploadSize < 0 {
+		invalid("tenantDefaults.maxUploadSize cannot be negative")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,103 @@
+// datascope restricts the employees and departments visible to an account
+// to the department subtree of the account, using Department.LevelCode.
+//
+// A Scope travels in the context of a GORM statement, the Plugin adds the
+// matching condition to every query, update and delete of Employee and Department.
+// A statement without a Scope fails with ErrNoScope, system tasks reaching
+// every row run with WithScope(ctx, Unrestricted).
+package datascope
+
+import (
+	"context"
+	"errors"
+
+	"vngom/models/department"
+	"vngom/models/employee"
+	"vngom/models/rbac"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrNoScope = errors.New("no data scope in the context of the statement")
+
+type scopeKey struct{}
+
+// Scope describes the departments an account can reach.
+// An account without a department and without an unrestricted role reaches nothing.
+type Scope struct {
+	// All is true when no restriction applies
+	All bool
+	// LevelCodes are departments visible with all their descendants
+	LevelCodes []string
+	// DepartmentIDs are departments visible without their descendants
+	DepartmentIDs []uint
+}
+
+// Unrestricted is the scope of system tasks and unrestricted roles
+var Unrestricted = &Scope{All: true}
+
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// FromContext returns the scope of the context, nil when none was set
+func FromContext(ctx context.Context) *Scope {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(scopeKey{}).(*Scope)
+	return scope
+}
+
+// Resolve computes the scope of an account from the data scopes of its roles.
+// The broadest role wins: a single unrestricted role lifts every restriction.
+func Resolve(db *gorm.DB, accountID uuid.UUID) (*Scope, error) {
+	db = db.WithContext(WithScope(db.Statement.Context, Unrestricted))
+	var scopes []string
+	roleIDs := db.Model(&rbac.AccountRole{}).Select("role_id").Where("account_id = ?", accountID)
+	if err := db.Model(&rbac.Role{}).Where("id IN (?)", roleIDs).Pluck("data_scope", &scopes).Error; err != nil {
+		return nil, err
+	}
+	tree, own := false, false
+	for _, s := range scopes {
+		switch s {
+		case rbac.DataScopeDepartmentTree:
+			tree = true
+		case rbac.DataScopeAll:
+			return Unrestricted, nil
+		default:
+			// an unknown data scope grants the narrowest one
+			own = true
+		}
+	}
+	scope := &Scope{}
+	if !tree && !own {
+		return scope, nil
+	}
+	var emp employee.Employee
+	err := db.Where(&employee.Employee{UserID: &accountID}).First(&emp).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && emp.DepartmentID == nil) {
+		return scope, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !tree {
+		scope.DepartmentIDs = []uint{*emp.DepartmentID}
+		return scope, nil
+	}
+	var dept department.Department
+	if err := db.First(&dept, *emp.DepartmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return scope, nil
+		}
+		return nil, err
+	}
+	if dept.LevelCode == "" {
+		scope.DepartmentIDs = []uint{dept.ID}
+	} else {
+		scope.LevelCodes = []string{dept.LevelCode}
+	}
+	return scope, nil
+}
@@ -0,0 +1,160 @@
+package datascope_test
+
+import (
+	"context"
+	"testing"
+
+	"vngom/authz"
+	"vngom/datascope"
+	"vngom/models/account"
+	"vngom/models/department"
+	"vngom/models/employee"
+	"vngom/models/rbac"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// newTestDb creates the tree 1 > 1.2 > 1.2.3 and 1 > 1.4 with one employee in each department
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(datascope.NewPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err := authz.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&account.Account{}, &department.Department{}, &employee.Employee{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []department.Department{
+		{ID: 1, Code: "HQ", LevelCode: "1"},
+		{ID: 2, Code: "SALES", ParentID: 1, LevelCode: "1.2"},
+		{ID: 3, Code: "SALES-NORTH", ParentID: 2, LevelCode: "1.2.3"},
+		{ID: 4, Code: "IT", ParentID: 1, LevelCode: "1.4"},
+	} {
+		assert.NoError(t, db.Create(&d).Error)
+	}
+	for i, code := range []string{"E1", "E2", "E3", "E4"} {
+		departmentID := uint(i + 1)
+		e := employee.Employee{Code: code, DepartmentID: &departmentID}
+		e.ID = uuid.New()
+		assert.NoError(t, db.Omit("User", "Personal").Create(&e).Error)
+	}
+	return db
+}
+
+func newAccount(t *testing.T, db *gorm.DB, scope string, employeeCode string) uuid.UUID {
+	acc := account.Account{Username: "user-" + employeeCode, Email: uuid.NewString() + "@example.com"}
+	acc.ID = uuid.New()
+	assert.NoError(t, db.Create(&acc).Error)
+	accountID := acc.ID
+	role := rbac.Role{Code: "role-" + accountID.String(), Name: "test", DataScope: scope}
+	assert.NoError(t, authz.CreateRole(db, &role, nil, "test"))
+	assert.NoError(t, authz.AssignRole(db, accountID, role.ID))
+	if employeeCode != "" {
+		ctx := datascope.WithScope(context.Background(), datascope.Unrestricted)
+		assert.NoError(t, db.WithContext(ctx).Model(&employee.Employee{}).Where("code = ?", employeeCode).Update("user_id", accountID).Error)
+	}
+	return accountID
+}
+
+func visibleCodes(t *testing.T, db *gorm.DB, scope *datascope.Scope) ([]string, []string) {
+	ctx := datascope.WithScope(context.Background(), scope)
+	var employees, departments []string
+	assert.NoError(t, db.WithContext(ctx).Model(&employee.Employee{}).Order("code").Pluck("code", &employees).Error)
+	assert.NoError(t, db.WithContext(ctx).Model(&department.Department{}).Order("Code").Pluck("Code", &departments).Error)
+	return employees, departments
+}
+
+func TestDepartmentTree(t *testing.T) {
+	db := newTestDb(t)
+	manager := newAccount(t, db, rbac.DataScopeDepartmentTree, "E2")
+
+	scope, err := datascope.Resolve(db, manager)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.2"}, scope.LevelCodes)
+
+	employees, departments := visibleCodes(t, db, scope)
+	assert.Equal(t, []string{"E2", "E3"}, employees)
+	assert.Equal(t, []string{"SALES", "SALES-NORTH"}, departments)
+}
+
+func TestOwnDepartment(t *testing.T) {
+	db := newTestDb(t)
+	lead := newAccount(t, db, rbac.DataScopeDepartment, "E2")
+
+	scope, err := datascope.Resolve(db, lead)
+	assert.NoError(t, err)
+	employees, departments := visibleCodes(t, db, scope)
+	assert.Equal(t, []string{"E2"}, employees)
+	assert.Equal(t, []string{"SALES"}, departments)
+}
+
+func TestBroadestRoleWins(t *testing.T) {
+	db := newTestDb(t)
+	account := newAccount(t, db, rbac.DataScopeDepartment, "E4")
+	role := rbac.Role{Code: "everything", Name: "test", DataScope: rbac.DataScopeAll}
+	assert.NoError(t, authz.CreateRole(db, &role, nil, "test"))
+	assert.NoError(t, authz.AssignRole(db, account, role.ID))
+
+	scope, err := datascope.Resolve(db, account)
+	assert.NoError(t, err)
+	assert.True(t, scope.All)
+	employees, _ := visibleCodes(t, db, scope)
+	assert.Len(t, employees, 4)
+}
+
+func TestUnknownScopeIsNarrowest(t *testing.T) {
+	db := newTestDb(t)
+	account := newAccount(t, db, rbac.DataScopeDepartmentTree, "E2")
+	for _, scope := range []string{"", "everything"} {
+		assert.NoError(t, db.Model(&rbac.Role{}).Where("code = ?", "role-"+account.String()).Update("data_scope", scope).Error)
+
+		resolved, err := datascope.Resolve(db, account)
+		assert.NoError(t, err)
+		employees, departments := visibleCodes(t, db, resolved)
+		assert.Equal(t, []string{"E2"}, employees)
+		assert.Equal(t, []string{"SALES"}, departments)
+	}
+}
+
+func TestMissingScopeFailsClosed(t *testing.T) {
+	db := newTestDb(t)
+	var employees []employee.Employee
+	assert.ErrorIs(t, db.Find(&employees).Error, datascope.ErrNoScope)
+	assert.ErrorIs(t, db.Model(&department.Department{}).Where("1 = 1").Update("code", "X").Error, datascope.ErrNoScope)
+	assert.ErrorIs(t, db.Where("1 = 1").Delete(&employee.Employee{}).Error, datascope.ErrNoScope)
+	// other models are not scoped
+	var roles []rbac.Role
+	assert.NoError(t, db.Find(&roles).Error)
+
+	// system tasks opt out explicitly
+	ctx := datascope.WithScope(context.Background(), datascope.Unrestricted)
+	assert.NoError(t, db.WithContext(ctx).Find(&employees).Error)
+	assert.Len(t, employees, 4)
+}
+
+func TestNoDepartmentSeesNothing(t *testing.T) {
+	db := newTestDb(t)
+	account := newAccount(t, db, rbac.DataScopeDepartmentTree, "")
+
+	scope, err := datascope.Resolve(db, account)
+	assert.NoError(t, err)
+	employees, departments := visibleCodes(t, db, scope)
+	assert.Empty(t, employees)
+	assert.Empty(t, departments)
+}
+
+func TestScopeAppliesToUpdates(t *testing.T) {
+	db := newTestDb(t)
+	ctx := datascope.WithScope(context.Background(), &datascope.Scope{LevelCodes: []string{"1.2"}})
+	result := db.WithContext(ctx).Model(&employee.Employee{}).Where("1 = 1").Update("gender", "F")
+	assert.NoError(t, result.Error)
+	assert.Equal(t, int64(2), result.RowsAffected)
+}
@@ -0,0 +1,107 @@
+package datascope
+
+import (
+	"vngom/models/department"
+	"vngom/models/employee"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Plugin applies the Scope found in the statement context to Employee and Department,
+// refusing the statement when the context has no Scope
+type Plugin struct{}
+
+func NewPlugin() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return "datascope"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register("datascope:query", apply); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("datascope:update", apply); err != nil {
+		return err
+	}
+	return db.Callback().Delete().Before("gorm:delete").Register("datascope:delete", apply)
+}
+
+func apply(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	table := db.Statement.Schema.Table
+	if table != (&department.Department{}).TableName() && table != (&employee.Employee{}).TableName() {
+		return
+	}
+	scope := FromContext(db.Statement.Context)
+	if scope == nil {
+		db.AddError(ErrNoScope)
+		return
+	}
+	if scope.All {
+		return
+	}
+	switch table {
+	case (&department.Department{}).TableName():
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+			departmentCondition(db.Statement.Schema, clause.CurrentTable, scope),
+		}})
+	case (&employee.Employee{}).TableName():
+		expr, err := employeeCondition(db, scope)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{expr}})
+	}
+}
+
+// departmentCondition matches the departments of the scope on a Department table
+func departmentCondition(s *schema.Schema, table string, scope *Scope) clause.Expression {
+	levelCode := clause.Column{Table: table, Name: s.LookUpField("LevelCode").DBName}
+	id := clause.Column{Table: table, Name: s.PrioritizedPrimaryField.DBName}
+	var exprs []clause.Expression
+	for _, code := range scope.LevelCodes {
+		exprs = append(exprs,
+			clause.Eq{Column: levelCode, Value: code},
+			clause.Like{Column: levelCode, Value: code + ".%"},
+		)
+	}
+	if len(scope.DepartmentIDs) > 0 {
+		exprs = append(exprs, clause.IN{Column: id, Values: toValues(scope.DepartmentIDs)})
+	}
+	if len(exprs) == 0 {
+		return clause.Expr{SQL: "1 = 0"}
+	}
+	return clause.Or(exprs...)
+}
+
+// employeeCondition matches employees working in the departments of the scope
+func employeeCondition(db *gorm.DB, scope *Scope) (clause.Expression, error) {
+	departmentID := clause.Column{Table: clause.CurrentTable, Name: db.Statement.Schema.LookUpField("DepartmentID").DBName}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&department.Department{}); err != nil {
+		return nil, err
+	}
+	// the sub query runs unrestricted so the plugin does not apply twice,
+	// the rest of the context such as the tenant of a shared database is kept
+	sub := db.Session(&gorm.Session{NewDB: true, Context: WithScope(db.Statement.Context, Unrestricted)}).
+		Model(&department.Department{}).
+		Select(stmt.Schema.PrioritizedPrimaryField.DBName).
+		Where(departmentCondition(stmt.Schema, stmt.Schema.Table, scope))
+	return clause.Expr{SQL: "? IN (?)", Vars: []interface{}{departmentID, sub}}, nil
+}
+
+func toValues(ids []uint) []interface{} {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return values
+}
@@ -1,8 +1,17 @@
 package fiber_wrapper
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"strings"
 	"vngom/config"
+	"vngom/logger"
+	"vngom/mailer"
+	"vngom/repo"
+	"vngom/security"
+	"vngom/tenantscope"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -21,13 +30,29 @@ type IAppContext interface {
 	SetTenant(tenant string)
 
 	GetConfig() config.IConfig
+	GetRepo() (repo.IRepo, error)
+	// GetTenantConfig returns the global configuration with the overrides of the tenant
+	GetTenantConfig() (config.TenantConfig, error)
+	// GetUser returns the claims of the authenticated caller, nil for anonymous requests
+	GetUser() *security.Claims
+	GetAuthorizer() Authorizer
+	// GetContext returns the context to pass to database calls, it carries the data scope of the caller
+	GetContext() context.Context
+	// GetLogger returns the logger of the request, its entries carry the request ID
+	GetLogger() *slog.Logger
+	// GetMailer returns the mailer shared by the requests, it sends in the background
+	GetMailer() mailer.Mailer
 }
 type AppContext struct {
 	App    *fiber.Ctx
 	Tenant string
 	// Repo   repo.IRepo
-	Cfg config.IConfig
-	Rf  repo.IRepoFactory
+	Cfg  config.IConfig
+	Rf   repo.IRepoFactory
+	Auth Authorizer
+	// Settings is nil when tenants cannot override the configuration
+	Settings TenantSettings
+	Mailer   mailer.Mailer
 }
 
 func (c *AppContext) GetApp() *fiber.Ctx {
@@ -39,6 +64,12 @@ func (c *AppContext) GetTenant() string {
 func (c *AppContext) GetRepo() (repo.IRepo, error) {
 	return c.Rf.Get(c.Tenant)
 }
+func (c *AppContext) GetTenantConfig() (config.TenantConfig, error) {
+	if c.Settings == nil {
+		return c.Cfg.GetTenantDefaults(), nil
+	}
+	return c.Settings.Get(c.Tenant)
+}
 func (c *AppContext) GetConfig() config.IConfig {
 	return c.Cfg
 }
@@ -48,18 +79,36 @@ func (c *AppContext) SetTenant(tenant string) {
 func (c *AppContext) GetRepoFactory() repo.IRepoFactory {
 	return c.Rf
 }
+func (c *AppContext) GetUser() *security.Claims {
+	return security.GetClaims(c.App)
+}
+func (c *AppContext) GetAuthorizer() Authorizer {
+	return c.Auth
+}
+func (c *AppContext) GetMailer() mailer.Mailer {
+	return c.Mailer
+}
+func (c *AppContext) GetContext() context.Context {
+	return c.App.UserContext()
+}
 func NewAppContext(app *fiber.Ctx,
 	tenant string,
 	// rp repo.IRepo,
 	cfg config.IConfig,
-	rf repo.IRepoFactory) IAppContext {
+	rf repo.IRepoFactory,
+	auth Authorizer,
+	settings TenantSettings,
+	mail mailer.Mailer) IAppContext {
 
 	return &AppContext{
 		App:    app,
 		Tenant: tenant,
 		// Repo:   rp,
-		Cfg: cfg,
-		Rf:  rf,
+		Cfg:      cfg,
+		Rf:       rf,
+		Auth:     auth,
+		Settings: settings,
+		Mailer:   mail,
 	}
 }
 
@@ -68,66 +117,137 @@ type Router struct {
 	Method string
 
 	Handler Handler
+	// Permission required to call the route, such as "employee.read".
+	// Routes without permission are public.
+	Permission string
+	// Feature the tenant must have turned on for the route to exist, empty for every tenant
+	Feature string
 }
 
-func InstallRouters(
-	routers map[string]Router,
-	app *fiber.App,
-	startEnpont string,
-	cfg config.IConfig,
-	rf repo.IRepoFactory) {
-	for route, val := range routers {
-		method := strings.ToLower(val.Method)
-
-		if method == "get" {
-			app.Get(startEnpont+route, func(c *fiber.Ctx) error {
-
-				tenant := c.Params("tenant")
-				//r, err := rf.Get(tenant)
-				// if err != nil {
-				// 	return err
-				// }
-				appCxt := NewAppContext(c, tenant, cfg, rf)
+// Authorizer checks whether the caller of a route holds a permission
+type Authorizer interface {
+	Authorize(c IAppContext, permission string) error
+	// Invalidate forgets what is known about the roles of a tenant
+	Invalidate(tenant string)
+}
 
-				return val.Handler(appCxt)
-			})
+// invoke enforces the feature, the permission and the upload limit of the route before calling its handler
+func invoke(val Router, appCxt IAppContext, authorizer Authorizer) error {
+	if val.Feature != "" {
+		tenantCfg, err := appCxt.GetTenantConfig()
+		if err != nil {
+			return err
 		}
-		if method == "post" {
-			app.Post(startEnpont+route, func(c *fiber.Ctx) error {
-				tenant := c.Params("tenant")
-
-				appCxt := NewAppContext(c, tenant, cfg, rf)
+		if !tenantCfg.Feature(val.Feature) {
+			return fiber.ErrNotFound
+		}
+	}
+	if val.Permission != "" {
+		if appCxt.GetUser() == nil {
+			return fiber.ErrUnauthorized
+		}
+		if err := authorizer.Authorize(appCxt, val.Permission); err != nil {
+			return err
+		}
+	}
+	if err := checkUploads(appCxt); err != nil {
+		return err
+	}
+	return toFiberError(val.Handler(appCxt))
+}
 
-				return val.Handler(appCxt)
-			})
+// checkUploads refuses the files of a multipart request larger than the MaxUploadSize of the tenant
+func checkUploads(c IAppContext) error {
+	if !strings.HasPrefix(string(c.GetApp().Request().Header.ContentType()), fiber.MIMEMultipartForm) {
+		return nil
+	}
+	tenantCfg, err := c.GetTenantConfig()
+	if err != nil || tenantCfg.MaxUploadSize <= 0 {
+		return err
+	}
+	// the form is parsed once, the handler gets it again from the request
+	form, err := c.GetApp().MultipartForm()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	for _, files := range form.File {
+		for _, file := range files {
+			if file.Size > tenantCfg.MaxUploadSize {
+				return fiber.NewError(fiber.StatusRequestEntityTooLarge,
+					fmt.Sprintf("%s is larger than %d bytes", file.Filename, tenantCfg.MaxUploadSize))
+			}
 		}
-		if method == "put" {
-			app.Put(startEnpont+route, func(c *fiber.Ctx) error {
-				tenant := c.Params("tenant")
+	}
+	return nil
+}
 
-				appCxt := NewAppContext(c, tenant, cfg, rf)
+// toFiberError maps the errors of the repositories to HTTP errors
+func toFiberError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repo.ErrNotFound), errors.Is(err, repo.ErrInvalidTenant):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, repo.ErrConflict):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, repo.ErrInvalidFilter), errors.Is(err, repo.ErrInvalidField):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
 
-				return val.Handler(appCxt)
-			})
-		}
-		if method == "delete" {
-			app.Delete(startEnpont+route, func(c *fiber.Ctx) error {
-				tenant := c.Params("tenant")
+// TenantResolver finds the tenant of a request, it fails with a fiber error
+// when the tenant is unknown or cannot use the application
+type TenantResolver interface {
+	Resolve(c *fiber.Ctx) (string, error)
+}
 
-				appCxt := NewAppContext(c, tenant, cfg, rf)
+// TenantSettings returns the configuration of a tenant, the global one with its overrides
+type TenantSettings interface {
+	Get(tenant string) (config.TenantConfig, error)
+}
 
-				return val.Handler(appCxt)
-			})
+// newHandler resolves the tenant of the request then invokes the route
+func newHandler(val Router, cfg config.IConfig, rf repo.IRepoFactory, authorizer Authorizer, resolver TenantResolver, settings TenantSettings, mail mailer.Mailer) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tenant, err := resolver.Resolve(c)
+		if err != nil {
+			return err
 		}
-		if method == "patch" {
-			app.Patch(startEnpont+route, func(c *fiber.Ctx) error {
-				tenant := c.Params("tenant")
+		// scopes the statements of the request when the tenant lives in a shared database
+		c.SetUserContext(tenantscope.WithTenant(c.UserContext(), tenant))
+		appCxt := NewAppContext(c, tenant, cfg, rf, authorizer, settings, mail)
 
-				appCxt := NewAppContext(c, tenant, cfg, rf)
+		return invoke(val, appCxt, authorizer)
+	}
+}
 
-				return val.Handler(appCxt)
-			})
+func InstallRouters(
+	routers map[string]Router,
+	app *fiber.App,
+	startEnpont string,
+	cfg config.IConfig,
+	rf repo.IRepoFactory,
+	authorizer Authorizer,
+	resolver TenantResolver,
+	settings TenantSettings,
+	mail mailer.Mailer) {
+	for route, val := range routers {
+		handler := newHandler(val, cfg, rf, authorizer, resolver, settings, mail)
+		switch strings.ToLower(val.Method) {
+		case "get":
+			app.Get(startEnpont+route, handler)
+		case "post":
+			app.Post(startEnpont+route, handler)
+		case "put":
+			app.Put(startEnpont+route, handler)
+		case "delete":
+			app.Delete(startEnpont+route, handler)
+		case "patch":
+			app.Patch(startEnpont+route, handler)
 		}
-
 	}
 }
+func (c *AppContext) GetLogger() *slog.Logger {
+	return logger.FromContext(c.App.UserContext())
+}
@@ -0,0 +1,68 @@
+package fiber_wrapper_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"vngom/config"
+	"vngom/fiber_wrapper"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type testResolver struct{}
+
+func (testResolver) Resolve(c *fiber.Ctx) (string, error) { return c.Params("tenant"), nil }
+
+// testSettings turns the reports feature on for acme and limits its uploads to 16 bytes
+type testSettings struct{}
+
+func (testSettings) Get(tenant string) (config.TenantConfig, error) {
+	if tenant == "acme" {
+		return config.TenantConfig{Features: map[string]bool{"reports": true}, MaxUploadSize: 16}, nil
+	}
+	return config.TenantConfig{}, nil
+}
+
+// upload returns a multipart body holding a file and its content type
+func upload(t *testing.T, content string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "payslip.pdf")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return &body, w.FormDataContentType()
+}
+
+func TestTenantSettingsApplyToRoutes(t *testing.T) {
+	app := fiber.New()
+	ok := func(c fiber_wrapper.IAppContext) error { return c.GetApp().SendStatus(fiber.StatusNoContent) }
+	fiber_wrapper.InstallRouters(map[string]fiber_wrapper.Router{
+		"/reports": {Method: "GET", Handler: ok, Feature: "reports"},
+		"/upload":  {Method: "POST", Handler: ok},
+	}, app, "/api/:tenant", &config.Config{}, nil, nil, testResolver{}, testSettings{}, nil)
+	status := func(method string, path string, content string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if content != "" {
+			body, contentType := upload(t, content)
+			req = httptest.NewRequest(method, path, body)
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := app.Test(req)
+		assert.NoError(t, err)
+		return res.StatusCode
+	}
+
+	assert.Equal(t, fiber.StatusNoContent, status("GET", "/api/acme/reports", ""))
+	assert.Equal(t, fiber.StatusNotFound, status("GET", "/api/globex/reports", ""))
+
+	assert.Equal(t, fiber.StatusNoContent, status("POST", "/api/acme/upload", "small"))
+	assert.Equal(t, fiber.StatusRequestEntityTooLarge, status("POST", "/api/acme/upload", "larger than sixteen bytes"))
+	// no limit for globex
+	assert.Equal(t, fiber.StatusNoContent, status("POST", "/api/globex/upload", "larger than sixteen bytes"))
+}
@@ -0,0 +1,88 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"vngom/migrations"
+	"vngom/repo"
+)
+
+// Catalog checks the catalog database answers
+func Catalog(rf repo.IRepoFactory) Check {
+	return func(ctx context.Context) error {
+		catalog, err := rf.GetCatalog()
+		if err != nil {
+			return err
+		}
+		return catalog.Ping(ctx)
+	}
+}
+
+// TenantPools pings up to sample of the open tenant databases, picked at random
+func TenantPools(rf repo.IRepoFactory, sample int) Check {
+	return eachTenant(rf, sample, func(ctx context.Context, db repo.IRepo) error {
+		return db.Ping(ctx)
+	})
+}
+
+// Migrations checks the catalog and up to sample of the open tenant databases have no pending migration
+func Migrations(rf repo.IRepoFactory, sample int) Check {
+	tenants := eachTenant(rf, sample, func(ctx context.Context, db repo.IRepo) error {
+		return upToDate(ctx, db, migrations.Tenant)
+	})
+	return func(ctx context.Context) error {
+		catalog, err := rf.GetCatalog()
+		if err != nil {
+			return err
+		}
+		if err := upToDate(ctx, catalog, migrations.Catalog); err != nil {
+			return fmt.Errorf("%s: %w", catalog.GetDbName(), err)
+		}
+		return tenants(ctx)
+	}
+}
+
+func upToDate(ctx context.Context, db repo.IRepo, scope migrations.Scope) error {
+	pending, err := migrations.Pending(ctx, db.GetDb(), scope)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations from %s", len(pending), pending[0])
+	}
+	return nil
+}
+
+func eachTenant(rf repo.IRepoFactory, sample int, check func(ctx context.Context, db repo.IRepo) error) Check {
+	return func(ctx context.Context) error {
+		catalog, err := rf.GetCatalog()
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, s := range rf.Stats() {
+			if s.DbName != catalog.GetDbName() {
+				names = append(names, s.DbName)
+			}
+		}
+		rand.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+		if len(names) > sample {
+			names = names[:sample]
+		}
+		var errs []error
+		for _, name := range names {
+			db, ok := rf.Opened(name)
+			if !ok {
+				// evicted in between
+				continue
+			}
+			if err := check(ctx, db); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
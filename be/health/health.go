@@ -0,0 +1,155 @@
+// Package health serves the liveness and readiness probes. Modules contribute
+// readiness checks with Register, background workers report with a Heartbeat.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultTimeout = 2 * time.Second
+
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// Check returns an error when its dependency is not usable
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of every check, Status is down when one of them is
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Registry struct {
+	timeout time.Duration
+	lock    sync.Mutex
+	checks  []namedCheck
+}
+
+// NewRegistry bounds every check by timeout
+func NewRegistry(timeout time.Duration) *Registry {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Registry{timeout: timeout}
+}
+
+// Register adds a readiness check
+func (r *Registry) Register(name string, check Check) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+// Heartbeat is beaten by a background worker on each iteration
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Worker registers a check failing when the worker has not beaten for maxAge
+func (r *Registry) Worker(name string, maxAge time.Duration) *Heartbeat {
+	h := &Heartbeat{}
+	h.Beat()
+	r.Register("worker:"+name, func(context.Context) error {
+		if age := time.Since(time.Unix(0, h.last.Load())); age > maxAge {
+			return fmt.Errorf("no heartbeat for %s", age.Round(time.Second))
+		}
+		return nil
+	})
+	return h
+}
+
+// Check runs every check concurrently
+func (r *Registry) Check(ctx context.Context) Report {
+	r.lock.Lock()
+	checks := append([]namedCheck{}, r.checks...)
+	r.lock.Unlock()
+
+	report := Report{Status: StatusUp, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = r.run(ctx, c)
+		}()
+	}
+	wg.Wait()
+	sort.SliceStable(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	for _, res := range report.Checks {
+		if res.Status == StatusDown {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, c namedCheck) Result {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	res := Result{Name: c.name, Status: StatusUp, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Status, res.Error = StatusDown, err.Error()
+	}
+	return res
+}
+
+// Install serves /health/live, answering as long as the process runs, and
+// /health/ready, running the checks once ready reports the application accepts
+// traffic. /health is kept as an alias of the readiness probe.
+func Install(app *fiber.App, r *Registry, ready func() bool) {
+	app.Get("/health/live", func(c *fiber.Ctx) error {
+		return c.JSON(Report{Status: StatusUp, Checks: []Result{}})
+	})
+	readiness := func(c *fiber.Ctx) error {
+		report := Report{Status: StatusDown, Checks: []Result{{Name: "lifecycle", Status: StatusDown, Error: "not accepting traffic"}}}
+		if ready() {
+			report = r.Check(c.UserContext())
+		}
+		if report.Status == StatusDown {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+		return c.JSON(report)
+	}
+	app.Get("/health/ready", readiness)
+	app.Get("/health", readiness)
+}
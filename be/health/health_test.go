@@ -0,0 +1,110 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vngom/health"
+	"vngom/migrations"
+	"vngom/repo"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func init() {
+	repo.RegisterDialect("sqlite", repo.Dialect{
+		Open: func(conn repo.Connection, dbName string) (gorm.Dialector, error) {
+			return sqlite.Open("file:" + dbName + "?mode=memory&cache=shared"), nil
+		},
+	})
+}
+
+func probe(t *testing.T, app *fiber.App, path string) (int, health.Report) {
+	resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report health.Report
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	return resp.StatusCode, report
+}
+
+func TestProbes(t *testing.T) {
+	registry := health.NewRegistry(50 * time.Millisecond)
+	var failure error
+	registry.Register("mailer", func(ctx context.Context) error { return failure })
+	registry.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	ready := false
+	app := fiber.New()
+	health.Install(app, registry, func() bool { return ready })
+
+	status, _ := probe(t, app, "/health/live")
+	assert.Equal(t, fiber.StatusOK, status)
+	// readiness is down until the application accepts traffic
+	status, report := probe(t, app, "/health/ready")
+	assert.Equal(t, fiber.StatusServiceUnavailable, status)
+	assert.Equal(t, health.StatusDown, report.Status)
+
+	ready = true
+	failure = errors.New("smtp unreachable")
+	status, report = probe(t, app, "/health/ready")
+	assert.Equal(t, fiber.StatusServiceUnavailable, status)
+	if assert.Len(t, report.Checks, 2) {
+		assert.Equal(t, health.Result{Name: "mailer", Status: health.StatusDown, Error: "smtp unreachable"}, zeroLatency(report.Checks[0]))
+		// a check exceeding the timeout is down
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[1].Error)
+		assert.GreaterOrEqual(t, report.Checks[1].LatencyMs, float64(50))
+	}
+}
+
+func zeroLatency(r health.Result) health.Result {
+	r.LatencyMs = 0
+	return r
+}
+
+func TestWorkerHeartbeat(t *testing.T) {
+	registry := health.NewRegistry(0)
+	beat := registry.Worker("purge", 20*time.Millisecond)
+	assert.Equal(t, health.StatusUp, registry.Check(context.Background()).Status)
+	time.Sleep(30 * time.Millisecond)
+	report := registry.Check(context.Background())
+	assert.Equal(t, health.StatusDown, report.Status)
+	assert.Equal(t, "worker:purge", report.Checks[0].Name)
+	beat.Beat()
+	assert.Equal(t, health.StatusUp, registry.Check(context.Background()).Status)
+}
+
+func TestDatabaseChecks(t *testing.T) {
+	f := repo.NewRepoFactory("sqlite")
+	f.ConfigCatalog(t.Name()+"_catalog", "")
+	t.Cleanup(func() { f.Close() })
+	registry := health.NewRegistry(0)
+	registry.Register("catalog", health.Catalog(f))
+	registry.Register("tenant-pools", health.TenantPools(f, 3))
+	registry.Register("migrations", health.Migrations(f, 3))
+
+	report := registry.Check(context.Background())
+	assert.Equal(t, health.StatusDown, report.Status)
+	assert.Equal(t, "migrations", report.Checks[1].Name)
+	assert.Contains(t, report.Checks[1].Error, "pending migrations")
+
+	catalog, err := f.GetCatalog()
+	assert.NoError(t, err)
+	_, err = migrations.Apply(context.Background(), catalog.GetDb(), migrations.Catalog)
+	assert.NoError(t, err)
+	tenant, err := f.GetDatabase("acme", t.Name()+"_acme")
+	assert.NoError(t, err)
+	_, err = migrations.Apply(context.Background(), tenant.GetDb(), migrations.Tenant)
+	assert.NoError(t, err)
+	assert.Equal(t, health.StatusUp, registry.Check(context.Background()).Status)
+}
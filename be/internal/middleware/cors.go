@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"slices"
+
+	"vngom/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// CORS allows the origins of server.corsOrigins, read on every request so a
+// reload of the configuration applies immediately
+func CORS(cfg config.IConfig) fiber.Handler {
+	return cors.New(cors.Config{
+		AllowOriginsFunc: func(origin string) bool {
+			origins := cfg.GetServerConfig().CORSOrigins
+			return slices.Contains(origins, "*") || slices.Contains(origins, origin)
+		},
+	})
+}
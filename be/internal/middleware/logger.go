@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"time"
+
+	"vngom/config"
+	"vngom/logger"
+	"vngom/security"
+	"vngom/tenantscope"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the correlation ID of a request, set on every response
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	maxRequestIDLength = 128
+	maxLoggedBody      = 4096
+	masked             = "******"
+)
+
+// sensitiveHeaders are never logged in clear
+var sensitiveHeaders = map[string]bool{
+	"authorization":  true,
+	"cookie":         true,
+	"set-cookie":     true,
+	"x-platform-key": true,
+}
+
+// sensitiveFields are the body fields whose name contains one of these words
+var sensitiveFields = []string{"password", "passwd", "secret", "token", "otp", "apikey", "api_key", "code"}
+
+// AccessLog assigns each request a correlation ID, propagated from the
+// X-Request-ID header when the caller sent a valid one, and logs the request
+// once handled. The request logger carrying the ID is in the user context for
+// the handlers. log.access is read on every request so a reload applies immediately.
+func AccessLog(lg *logger.Logger, cfg config.IConfig) fiber.Handler {
+	http := lg.Named("http")
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		id := c.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		} else {
+			id = strings.Clone(id)
+		}
+		c.Set(RequestIDHeader, id)
+		reqLog := http.With("request_id", id)
+		c.SetUserContext(logger.WithContext(logger.WithRequestID(c.UserContext(), id), reqLog))
+		own := c.Route()
+
+		err := c.Next()
+
+		access := cfg.GetLogConfig().Access
+		if !access.Enabled {
+			return err
+		}
+		latency := time.Since(start)
+		status := c.Response().StatusCode()
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		if status < fiber.StatusBadRequest && latency < access.SlowThreshold && rand.Float64() >= access.SampleRate {
+			return err
+		}
+
+		route := c.Route().Path
+		if c.Route() == own {
+			route = "unmatched"
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Method()),
+			slog.String("route", route),
+			slog.String("path", c.Path()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(latency.Microseconds())/1000),
+			slog.Int("bytes", len(c.Response().Body())),
+			slog.String("ip", c.IP()),
+		}
+		if tenant := tenantscope.FromContext(c.UserContext()); tenant != "" {
+			attrs = append(attrs, slog.String("tenant", tenant))
+		}
+		if claims := security.GetClaims(c); claims != nil {
+			attrs = append(attrs, slog.String("user", claims.Username))
+		}
+		if access.Headers {
+			attrs = append(attrs, slog.Any("headers", redactHeaders(c)))
+		}
+		if access.Bodies && len(c.Body()) > 0 {
+			attrs = append(attrs, slog.Any("body", redactBody(c.Body())))
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("err", err.Error()))
+		}
+		level := slog.LevelInfo
+		switch {
+		case status >= fiber.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= fiber.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		reqLog.LogAttrs(c.UserContext(), level, "request", attrs...)
+		return err
+	}
+}
+
+// validRequestID refuses the IDs that could forge log lines or grow them without bound
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func redactHeaders(c *fiber.Ctx) map[string]string {
+	ret := map[string]string{}
+	c.Request().Header.VisitAll(func(key []byte, value []byte) {
+		name := string(key)
+		if sensitiveHeaders[strings.ToLower(name)] {
+			ret[name] = masked
+			return
+		}
+		ret[name] = string(value)
+	})
+	return ret
+}
+
+// redactBody masks the sensitive fields of a JSON body, other bodies are not logged
+func redactBody(body []byte) interface{} {
+	if len(body) > maxLoggedBody {
+		return "(too large)"
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return "(not JSON)"
+	}
+	return redactValue(decoded)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if sensitive(key) {
+				v[key] = masked
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+func sensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, word := range sensitiveFields {
+		if strings.Contains(field, word) {
+			return true
+		}
+	}
+	return false
+}
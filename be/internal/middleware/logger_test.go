@@ -0,0 +1,91 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vngom/config"
+	"vngom/internal/middleware"
+	"vngom/logger"
+	"vngom/security"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	lg, err := logger.New(config.LogConfig{Level: "info", Format: "json", Sinks: []config.LogSinkConfig{{Type: "file", Path: path}}})
+	assert.NoError(t, err)
+	cfg := config.Defaults()
+	cfg.Log.Access.Headers = true
+	cfg.Log.Access.Bodies = true
+
+	app := fiber.New()
+	app.Use(middleware.AccessLog(lg, cfg))
+	var seen string
+	app.Post("/users/:id", func(c *fiber.Ctx) error {
+		c.Locals(security.ClaimsKey, &security.Claims{Username: "alice"})
+		seen = logger.RequestID(c.UserContext())
+		logger.FromContext(c.UserContext()).Info("handled")
+		return c.SendString("ok")
+	})
+
+	req := httptest.NewRequest("POST", "/users/1", strings.NewReader(`{"name":"Alice","password":"p4ss","nested":[{"apiKey":"k3y"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer t0ken")
+	req.Header.Set(middleware.RequestIDHeader, "abc-123")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc-123", resp.Header.Get(middleware.RequestIDHeader))
+	assert.Equal(t, "abc-123", seen)
+
+	// invalid IDs are replaced
+	req = httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set(middleware.RequestIDHeader, "bad id")
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "bad id", resp.Header.Get(middleware.RequestIDHeader))
+	assert.Len(t, resp.Header.Get(middleware.RequestIDHeader), 36)
+
+	// successful requests are sampled out, errors are always logged
+	cfg.Log.Access.SampleRate = 0
+	_, err = app.Test(httptest.NewRequest("POST", "/users/2", nil))
+	assert.NoError(t, err)
+	assert.NoError(t, lg.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "p4ss")
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	// the handler entries of both requests and the access entries of the first two
+	if assert.Len(t, records, 4) {
+		assert.Equal(t, "handled", records[0]["msg"])
+		assert.Equal(t, "abc-123", records[0]["request_id"])
+
+		access := records[1]
+		assert.Equal(t, "INFO", access["level"])
+		assert.Equal(t, "/users/:id", access["route"])
+		assert.Equal(t, float64(200), access["status"])
+		assert.Equal(t, "alice", access["user"])
+		assert.Equal(t, "******", access["headers"].(map[string]interface{})["Authorization"])
+		body := access["body"].(map[string]interface{})
+		assert.Equal(t, "Alice", body["name"])
+		assert.Equal(t, "******", body["password"])
+		assert.Equal(t, "******", body["nested"].([]interface{})[0].(map[string]interface{})["apiKey"])
+
+		assert.Equal(t, "WARN", records[2]["level"])
+		assert.Equal(t, "unmatched", records[2]["route"])
+		assert.Equal(t, float64(404), records[2]["status"])
+		assert.Equal(t, "handled", records[3]["msg"])
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"vngom/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimit allows server.rateLimit.max requests per client address in each
+// window of server.rateLimit.window. The limits are read on every request so a
+// reload of the configuration applies immediately, a max of zero disables it.
+func RateLimit(cfg config.IConfig) fiber.Handler {
+	var (
+		lock   sync.Mutex
+		start  time.Time
+		counts = map[string]int{}
+	)
+	return func(c *fiber.Ctx) error {
+		limit := cfg.GetServerConfig().RateLimit
+		if limit.Max <= 0 || limit.Window <= 0 {
+			return c.Next()
+		}
+		now := time.Now()
+		lock.Lock()
+		if now.Sub(start) >= limit.Window {
+			start, counts = now, map[string]int{}
+		}
+		counts[c.IP()]++
+		count := counts[c.IP()]
+		reset := start.Add(limit.Window).Sub(now)
+		lock.Unlock()
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(limit.Max))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(max(limit.Max-count, 0)))
+		if count > limit.Max {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(reset.Seconds())+1))
+			return c.SendStatus(fiber.StatusTooManyRequests)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,164 @@
+// Package lifecycle stops the application in a defined order once its context
+// is cancelled: readiness turns false, the server drains its requests, then the
+// background workers, databases and logs are stopped within a deadline.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vngom/config"
+)
+
+// Phase orders the stop hooks, hooks of a phase run in their registration order
+type Phase int
+
+const (
+	// PhaseServer stops accepting connections and drains the requests in flight
+	PhaseServer Phase = iota
+	// PhaseWorkers cancels Context and waits for the workers started with Go
+	PhaseWorkers
+	// PhaseDatabases closes the database pools
+	PhaseDatabases
+	// PhaseLogs flushes and closes the log sinks
+	PhaseLogs
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+type hook struct {
+	phase Phase
+	name  string
+	stop  func(ctx context.Context) error
+}
+
+type Manager struct {
+	cfg   config.IConfig
+	ready atomic.Bool
+
+	lock  sync.Mutex
+	hooks []hook
+
+	workers       sync.WaitGroup
+	workerCtx     context.Context
+	cancelWorkers context.CancelFunc
+	once          sync.Once
+	done          chan struct{}
+	err           error
+}
+
+// New reads server.shutdownDelay and server.shutdownTimeout when the application stops
+func New(cfg config.IConfig) *Manager {
+	m := &Manager{cfg: cfg, done: make(chan struct{})}
+	m.workerCtx, m.cancelWorkers = context.WithCancel(context.Background())
+	m.OnStop(PhaseWorkers, "workers", m.stopWorkers)
+	return m
+}
+
+// Ready reports whether the application accepts traffic
+func (m *Manager) Ready() bool {
+	return m.ready.Load()
+}
+
+// MarkReady is called once the server listens
+func (m *Manager) MarkReady() {
+	m.ready.Store(true)
+}
+
+// OnStop registers a hook run in its phase, ctx carries the shutdown deadline
+func (m *Manager) OnStop(phase Phase, name string, stop func(ctx context.Context) error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.hooks = append(m.hooks, hook{phase: phase, name: name, stop: stop})
+}
+
+// Context is cancelled when the workers are stopped, after the requests are drained
+func (m *Manager) Context() context.Context {
+	return m.workerCtx
+}
+
+// Go runs a background worker, it must return once Context is done
+func (m *Manager) Go(name string, worker func(ctx context.Context)) {
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+		worker(m.workerCtx)
+	}()
+}
+
+func (m *Manager) stopWorkers(ctx context.Context) error {
+	m.cancelWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run stops the application when ctx is done, then returns the errors of the hooks
+func (m *Manager) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return m.Stop()
+}
+
+// Done is closed once the application is stopped
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+func logger() *slog.Logger {
+	return slog.Default().With("logger", "lifecycle")
+}
+
+// Stop runs the hooks phase by phase. Readiness turns false first and the
+// server keeps serving for server.shutdownDelay so load balancers stop routing
+// to it. Every hook shares the server.shutdownTimeout deadline, a failing or
+// late hook does not prevent the next ones from running.
+func (m *Manager) Stop() error {
+	m.once.Do(func() {
+		defer close(m.done)
+		m.ready.Store(false)
+		cfg := m.cfg.GetServerConfig()
+		timeout := cfg.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		logger().Info("stopping", "timeout", timeout.String(), "delay", cfg.ShutdownDelay.String())
+		time.Sleep(cfg.ShutdownDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		m.lock.Lock()
+		hooks := append([]hook{}, m.hooks...)
+		m.lock.Unlock()
+		sort.SliceStable(hooks, func(i, j int) bool {
+			return hooks[i].phase < hooks[j].phase
+		})
+		var errs []error
+		for _, h := range hooks {
+			if err := h.stop(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			}
+		}
+		m.err = errors.Join(errs...)
+		if m.err != nil {
+			logger().Error("stopped with errors", "err", m.err)
+		} else {
+			logger().Info("stopped")
+		}
+	})
+	<-m.done
+	return m.err
+}
@@ -0,0 +1,53 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/lifecycle"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopOrder(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Server.ShutdownTimeout = 100 * time.Millisecond
+	m := lifecycle.New(cfg)
+	m.MarkReady()
+
+	var order []string
+	record := func(name string, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return err
+		}
+	}
+	m.OnStop(lifecycle.PhaseLogs, "logs", record("logs", nil))
+	m.OnStop(lifecycle.PhaseDatabases, "databases", record("databases", errors.New("boom")))
+	m.OnStop(lifecycle.PhaseServer, "server", func(ctx context.Context) error {
+		// readiness is false before the server drains
+		assert.False(t, m.Ready())
+		return record("server", nil)(ctx)
+	})
+	m.Go("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		order = append(order, "worker")
+	})
+	m.Go("late", func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(time.Second)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := m.Run(ctx)
+	// the late worker is abandoned at the deadline, the next phases still run
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "databases: boom")
+	assert.Equal(t, []string{"server", "worker", "databases", "logs"}, order)
+	<-m.Done()
+	assert.Equal(t, err, m.Stop())
+}
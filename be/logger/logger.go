@@ -1,44 +1,154 @@
+// Package logger provides the structured, leveled logger of the application.
+// Records go to every configured sink in JSON or console format, file sinks
+// are rotated on size or time.
 package logger
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
+
+	"vngom/config"
 )
 
-// Logger is an interface for logging
-type Logger interface {
-	Log(message string)
+// Logger is the root logger, components log through a Named child
+type Logger struct {
+	*slog.Logger
+	level   *slog.LevelVar
+	closers []io.Closer
 }
 
-// FileLogger logs messages to a file
-type FileLogger struct {
-	logger *log.Logger
+// New opens the sinks of cfg, the standard error when there is none
+func New(cfg config.LogConfig) (*Logger, error) {
+	l := &Logger{level: &slog.LevelVar{}}
+	if err := l.SetLevel(cfg.Level); err != nil {
+		return nil, err
+	}
+	sinks := cfg.Sinks
+	if len(sinks) == 0 {
+		sinks = []config.LogSinkConfig{{Type: "stderr"}}
+	}
+	var handlers fanout
+	for _, sink := range sinks {
+		var w io.Writer
+		switch sink.Type {
+		case "stdout":
+			w = os.Stdout
+		case "file":
+			file, err := openRotating(sink)
+			if err != nil {
+				l.Close()
+				return nil, err
+			}
+			l.closers = append(l.closers, file)
+			w = file
+		default:
+			w = os.Stderr
+		}
+		format := sink.Format
+		if format == "" {
+			format = cfg.Format
+		}
+		opts := &slog.HandlerOptions{Level: l.level}
+		if format == "console" {
+			handlers = append(handlers, slog.NewTextHandler(w, opts))
+		} else {
+			handlers = append(handlers, slog.NewJSONHandler(w, opts))
+		}
+	}
+	l.Logger = slog.New(handlers)
+	return l, nil
 }
 
-// NewFileLogger creates a new FileLogger that logs to logs/app.log
-func NewFileLogger() (*FileLogger, error) {
-	logDir := filepath.Join(".", "logs")
-	logFile := filepath.Join(logDir, "app.log")
+// Named returns the child logger of a component, its records carry logger=name
+func (l *Logger) Named(name string) *slog.Logger {
+	return l.With("logger", name)
+}
 
-	// Create logs directory if it doesn't exist
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create logs directory: %w", err)
+// SetLevel changes the level of every sink, it applies to the records logged afterwards
+func (l *Logger) SetLevel(level string) error {
+	parsed, err := config.ParseLevel(level)
+	if err != nil {
+		return err
 	}
+	l.level.Set(parsed)
+	return nil
+}
 
-	// Open or create log file
-	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+// Close flushes and closes the file sinks
+func (l *Logger) Close() error {
+	var errs []error
+	for _, c := range l.closers {
+		errs = append(errs, c.Close())
 	}
+	return errors.Join(errs...)
+}
+
+type (
+	loggerKey    struct{}
+	requestIDKey struct{}
+)
 
-	// Create logger
-	logger := log.New(file, "ERP: ", log.LstdFlags)
-	return &FileLogger{logger: logger}, nil
+// WithContext returns a context carrying the logger of a request
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
 }
 
-// Log writes a message to the log file
-func (f *FileLogger) Log(message string) {
-	f.logger.Println(message)
+// FromContext returns the logger of the request, the default logger outside of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// WithRequestID returns a context carrying the correlation ID of a request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the correlation ID of the request, empty outside of a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// fanout sends each record to every handler
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	ret := make(fanout, len(f))
+	for i, h := range f {
+		ret[i] = h.WithAttrs(attrs)
+	}
+	return ret
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	ret := make(fanout, len(f))
+	for i, h := range f {
+		ret[i] = h.WithGroup(name)
+	}
+	return ret
 }
@@ -0,0 +1,81 @@
+package logger_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vngom/config"
+	"vngom/logger"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readLines(t *testing.T, path string) []map[string]interface{} {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var ret []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		ret = append(ret, record)
+	}
+	return ret
+}
+
+func TestLogger(t *testing.T) {
+	dir := t.TempDir()
+	l, err := logger.New(config.LogConfig{
+		Level:  "info",
+		Format: "json",
+		Sinks: []config.LogSinkConfig{
+			{Type: "file", Path: filepath.Join(dir, "app.log")},
+			{Type: "file", Path: filepath.Join(dir, "app.txt"), Format: "console"},
+		},
+	})
+	assert.NoError(t, err)
+	repo := l.Named("repo")
+	repo.Debug("hidden")
+	repo.Info("opened", "db", "acme", "password", config.Secret("s3cr3t"))
+	assert.NoError(t, l.SetLevel("debug"))
+	repo.Debug("shown")
+	assert.NoError(t, l.Close())
+
+	records := readLines(t, filepath.Join(dir, "app.log"))
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "opened", records[0]["msg"])
+		assert.Equal(t, "repo", records[0]["logger"])
+		assert.Equal(t, "acme", records[0]["db"])
+		assert.Equal(t, "******", records[0]["password"])
+		assert.Equal(t, "DEBUG", records[1]["level"])
+	}
+	text, err := os.ReadFile(filepath.Join(dir, "app.txt"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(text), "level=INFO msg=opened logger=repo db=acme")
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	l, err := logger.New(config.LogConfig{Sinks: []config.LogSinkConfig{
+		{Type: "file", Path: filepath.Join(dir, "app.log"), MaxSize: 1, MaxBackups: 2, Compress: true},
+	}})
+	assert.NoError(t, err)
+	line := strings.Repeat("x", 1024)
+	for i := 0; i < 4*1024; i++ {
+		l.Info(line)
+	}
+	assert.NoError(t, l.Close())
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "app-*.log.gz"))
+	assert.Len(t, backups, 2)
+	info, err := os.Stat(filepath.Join(dir, "app.log"))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(1024*1024))
+}
@@ -0,0 +1,154 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vngom/config"
+)
+
+const (
+	megabyte     = 1024 * 1024
+	backupLayout = "20060102T150405.000"
+)
+
+// rotatingFile is a log file rotated on size or time. The rotated files are
+// named after the time of their rotation, compressed and pruned in the background.
+type rotatingFile struct {
+	cfg    config.LogSinkConfig
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	cleanLock sync.Mutex
+	cleaning  sync.WaitGroup
+}
+
+func openRotating(cfg config.LogSinkConfig) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{cfg: cfg}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size, r.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.due(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// due reports whether writing n bytes exceeds the size or crosses an interval boundary
+func (r *rotatingFile) due(n int) bool {
+	if r.cfg.MaxSize > 0 && r.size > 0 && r.size+int64(n) > int64(r.cfg.MaxSize)*megabyte {
+		return true
+	}
+	every := r.cfg.RotateEvery
+	return every > 0 && time.Now().Truncate(every).After(r.opened.Truncate(every))
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.cfg.Path)
+	backup := strings.TrimSuffix(r.cfg.Path, ext) + "-" + time.Now().Format(backupLayout) + ext
+	if err := os.Rename(r.cfg.Path, backup); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.cleaning.Add(1)
+	go func() {
+		defer r.cleaning.Done()
+		r.clean(backup)
+	}()
+	return nil
+}
+
+// clean compresses the new backup then removes the backups beyond MaxBackups or older than MaxAge
+func (r *rotatingFile) clean(backup string) {
+	r.cleanLock.Lock()
+	defer r.cleanLock.Unlock()
+	if r.cfg.Compress {
+		if err := compress(backup); err != nil {
+			os.Stderr.WriteString("logger: failed to compress " + backup + ": " + err.Error() + "\n")
+		}
+	}
+	ext := filepath.Ext(r.cfg.Path)
+	backups, _ := filepath.Glob(strings.TrimSuffix(r.cfg.Path, ext) + "-*" + ext + "*")
+	// the names sort by rotation time, newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i, name := range backups {
+		expired := false
+		if info, err := os.Stat(name); err == nil && r.cfg.MaxAge > 0 {
+			expired = time.Since(info.ModTime()) > r.cfg.MaxAge
+		}
+		if expired || (r.cfg.MaxBackups > 0 && i >= r.cfg.MaxBackups) {
+			os.Remove(name)
+		}
+	}
+}
+
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Close flushes the file and waits for the background compression
+func (r *rotatingFile) Close() error {
+	r.lock.Lock()
+	err := r.file.Close()
+	r.lock.Unlock()
+	r.cleaning.Wait()
+	return err
+}
@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"vngom/config"
+	"vngom/tracing"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// SMTPMailer sends messages through an SMTP server, using STARTTLS when offered
+type SMTPMailer struct {
+	cfg config.MailConfig
+}
+
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) (err error) {
+	_, span := tracing.StartClient(ctx, "smtp.send", semconv.ServerAddress(m.cfg.Host), semconv.ServerPort(m.cfg.Port))
+	defer func() { tracing.EndSpan(span, err) }()
+	if msg.From == "" {
+		msg.From = m.cfg.From
+	}
+	if len(msg.To) == 0 {
+		return errors.New("message has no recipient")
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	var auth smtp.Auth
+	if m.cfg.User != "" {
+		auth = smtp.PlainAuth("", m.cfg.User, m.cfg.Password.Value(), m.cfg.Host)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, m.cfg.From, msg.To, toMIME(msg))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileMailer writes each message as an .eml file, for local runs
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if msg.From == "" {
+		msg.From = m.from
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(m.dir, name), toMIME(msg), 0644)
+}
+
+// MemoryMailer keeps messages in memory, for tests
+type MemoryMailer struct {
+	lock     sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset forgets every message
+func (m *MemoryMailer) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,65 @@
+// mailer sends the emails of the application through a pluggable Mailer.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"vngom/config"
+)
+
+// Message is a plain text email
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages, implementations must be safe for concurrent use
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Memory is the shared mailer of the "memory" driver
+var Memory = NewMemoryMailer()
+
+// New creates the mailer selected by the configuration
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch strings.ToLower(cfg.Driver) {
+	case "smtp":
+		return NewSMTPMailer(cfg), nil
+	case "file", "":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "./mails"
+		}
+		return NewFileMailer(dir, cfg.From), nil
+	case "memory":
+		return Memory, nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+}
+
+// toMIME renders a message in RFC 5322 format
+func toMIME(msg Message) []byte {
+	var buf bytes.Buffer
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@vngom>\r\n", hex.EncodeToString(id))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
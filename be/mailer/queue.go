@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"vngom/logger"
+)
+
+const (
+	defaultQueueSize = 100
+	sendTimeout      = 30 * time.Second
+)
+
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue sends messages in the background: callers neither wait for the mail
+// server nor tell by the duration of a request whether a message was sent.
+type Queue struct {
+	mailer  Mailer
+	pending chan queued
+}
+
+type queued struct {
+	ctx context.Context
+	msg Message
+}
+
+// NewQueue buffers up to size messages for m, 100 when size is not positive
+func NewQueue(m Mailer, size int) *Queue {
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	return &Queue{mailer: m, pending: make(chan queued, size)}
+}
+
+// Send queues the message, failures to deliver it are logged by Run
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	// the message outlives the request, its values such as the request ID are kept
+	select {
+	case q.pending <- queued{ctx: context.WithoutCancel(ctx), msg: msg}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run sends the queued messages until ctx is done, then the ones still queued
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case item := <-q.pending:
+			q.send(item)
+		case <-ctx.Done():
+			for {
+				select {
+				case item := <-q.pending:
+					q.send(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *Queue) send(item queued) {
+	ctx, cancel := context.WithTimeout(item.ctx, sendTimeout)
+	defer cancel()
+	if err := q.mailer.Send(ctx, item.msg); err != nil {
+		logger.FromContext(ctx).Error("failed to send mail", "logger", "mailer", "subject", item.msg.Subject, "err", err)
+	}
+}
@@ -0,0 +1,50 @@
+package mailer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"vngom/mailer"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingMailer waits for release before delivering to the memory mailer
+type blockingMailer struct {
+	*mailer.MemoryMailer
+	release chan struct{}
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	<-m.release
+	return m.MemoryMailer.Send(ctx, msg)
+}
+
+func TestQueue(t *testing.T) {
+	m := &blockingMailer{MemoryMailer: mailer.NewMemoryMailer(), release: make(chan struct{})}
+	q := mailer.NewQueue(m, 2)
+
+	// the sender does not wait for delivery, even after its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, q.Send(ctx, mailer.Message{To: []string{"a@example.com"}}))
+	cancel()
+	assert.NoError(t, q.Send(context.Background(), mailer.Message{To: []string{"b@example.com"}}))
+	assert.ErrorIs(t, q.Send(context.Background(), mailer.Message{To: []string{"c@example.com"}}), mailer.ErrQueueFull)
+
+	// the queued messages are delivered on stop
+	runCtx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(runCtx)
+		close(done)
+	}()
+	stop()
+	close(m.release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queue did not stop")
+	}
+	assert.Len(t, m.Messages(), 2)
+}
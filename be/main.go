@@ -1,18 +1,40 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
-	"time"
 
+	"vngom/audittrail"
+	"vngom/authz"
 	"vngom/config"
+	"vngom/datascope"
+	"vngom/health"
+	"vngom/internal/middleware"
+	"vngom/lifecycle"
+	"vngom/logger"
+	"vngom/mailer"
+	"vngom/metrics"
+	"vngom/migrations"
+	"vngom/models/bases"
+	"vngom/repo"
+	"vngom/security"
+	"vngom/server"
+	"vngom/tenancy"
+	"vngom/tenantscope"
+	"vngom/tracing"
 
 	"vngom/fiber_wrapper"
 	"vngom/routers"
+	"vngom/routers/platform"
 
 	"github.com/defval/di"
 	"github.com/gofiber/fiber/v2"
@@ -30,6 +52,20 @@ func main() {
 
 	// load config
 
+	// -migrate applies the pending migrations to the catalog and every tenant then exits,
+	// -plan prints them without applying
+	migrate := flag.Bool("migrate", false, "apply pending migrations to all databases and exit")
+	plan := flag.Bool("plan", false, "print pending migrations of all databases and exit")
+	// -encrypt reads a secret on stdin and prints its ${enc:...} reference for the configuration
+	encrypt := flag.Bool("encrypt", false, "encrypt a secret read from stdin with "+config.MasterKeyEnv+" and exit")
+	// -config, -env, -profile and -set layer the configuration
+	loadOptions := config.BindFlags(flag.CommandLine)
+	flag.Parse()
+	if *encrypt {
+		encryptSecret()
+		return
+	}
+
 	di.SetTracer(&di.StdTracer{})
 
 	// create container
@@ -37,7 +73,7 @@ func main() {
 		di.Provide(func() context.Context {
 			ctx, cancel := context.WithCancel(context.Background())
 			go func() {
-				stop := make(chan os.Signal)
+				stop := make(chan os.Signal, 1)
 				signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 				<-stop
 				cancel()
@@ -49,33 +85,56 @@ func main() {
 			if err != nil {
 				log.Fatal(err)
 			}
-			CurrentYamlFile := CurrentDir + "/config.yaml"
+			CurrentYamlFile := loadOptions.File
+			if CurrentYamlFile == "" {
+				CurrentYamlFile = CurrentDir + "/config.yaml"
+			}
 			return AppInfo{
 				CurrentDir:      CurrentDir,
 				CurrentYamlFile: CurrentYamlFile,
 			}
 		}), // provide application info
-		di.Provide(func() *fiber.App {
-			return fiber.New(fiber.Config{
-				// Prefork:      true, // Sử dụng multi-core
-				// ServerHeader: "Fiber",
-				// ReadTimeout:  5 * time.Second,
-				// WriteTimeout: 10 * time.Second,
-				// IdleTimeout:  30 * time.Second,
-				// Concurrency:  100000, // Tăng số connection đồng thời
-
-			})
+		di.Provide(func(cfg config.IConfig) *fiber.App {
+			// prefork, timeouts, body limit and proxies are tuned in the server section
+			return fiber.New(server.Config(cfg.GetServerConfig()))
 		}),
-		di.Provide(func(appInfo AppInfo) config.IConfig {
-			c := config.NewConfig()
-			c.LoadConfig(appInfo.CurrentYamlFile)
-			return c
+		di.Provide(func(ctx context.Context) *config.Store {
+			store, err := config.NewStore(*loadOptions)
+			if err != nil {
+				log.Fatalf("invalid configuration:\n%v", err)
+			}
+			// the log level, CORS origins and rate limits apply on reload
+			store.Watch(ctx, 0)
+			return store
+		}), // provide config store
+		di.Provide(func(store *config.Store) config.IConfig {
+			return store
 		}), // provide config
+		di.Provide(func(store *config.Store) *logger.Logger {
+			lg, err := logger.New(store.GetLogConfig())
+			if err != nil {
+				log.Fatal(err)
+			}
+			// the standard log and slog go through the sinks, components log through lg.Named
+			slog.SetDefault(lg.Logger)
+			store.Subscribe(func(_ *config.Config, c *config.Config) {
+				if err := lg.SetLevel(c.Log.Level); err != nil {
+					lg.Named("config").Error("invalid log level", "err", err)
+				}
+			})
+			return lg
+		}), // provide logger
 		di.Provide(func() map[string]fiber_wrapper.Router {
 			return routers.Routes
 
 		}),
-		di.Provide(func(cfg config.IConfig) repo.IRepoFactory {
+		di.Provide(func(cfg config.IConfig) *lifecycle.Manager {
+			return lifecycle.New(cfg)
+		}), // provide lifecycle, stopping the application when its context is cancelled
+		di.Provide(func(cfg config.IConfig) *metrics.Metrics {
+			return metrics.New(cfg.GetMetricsConfig())
+		}), // provide metrics
+		di.Provide(func(cfg config.IConfig, lc *lifecycle.Manager, m *metrics.Metrics) repo.IRepoFactory {
 
 			dbCfg := cfg.GetDBConfig()
 			repoFactory := repo.NewRepoFactory(string(dbCfg.Type))
@@ -84,11 +143,73 @@ func main() {
 				dbCfg.Host,
 				dbCfg.Port,
 				dbCfg.User,
-				dbCfg.Password,
+				dbCfg.Password.Value(),
 			)
+			repoFactory.ConfigCatalog(dbCfg.Name, dbCfg.Otions)
+			repoFactory.ConfigPool(dbCfg.Pool)
+			repoFactory.Use(bases.NewPlugin(), datascope.NewPlugin(), audittrail.NewPlugin(), m.Plugin(), tracing.NewPlugin())
+			m.CollectPools(repoFactory)
+			if shared := cfg.GetTenancyConfig().SharedDatabase; shared != "" {
+				repoFactory.UseFor(shared, tenantscope.NewPlugin())
+			}
+			// tenant databases are closed once the requests and workers stopped
+			dbCtx, stopDbs := context.WithCancel(context.Background())
+			repoFactory.Start(dbCtx)
+			lc.OnStop(lifecycle.PhaseDatabases, "databases", func(context.Context) error {
+				stopDbs()
+				return repoFactory.Close()
+			})
 
 			return repoFactory
 		}),
+		di.Provide(func(cfg config.IConfig, repoFactory repo.IRepoFactory) *tenancy.Catalog {
+			catalog := tenancy.NewCatalog(repoFactory, cfg.GetTenancyConfig().CacheTTL)
+			// tenants are mapped to their database through the catalog
+			repoFactory.SetDbNameResolver(catalog.DbName)
+			return catalog
+		}),
+		di.Provide(func(cfg config.IConfig, repoFactory repo.IRepoFactory, catalog *tenancy.Catalog) *tenancy.Settings {
+			settings := tenancy.NewSettings(repoFactory, catalog, cfg)
+			// large tenants can have their database on a dedicated server
+			repoFactory.SetConnectionResolver(settings.ResolveConnection)
+			settings.Subscribe(func(change tenancy.ConfigChange) {
+				if change.Old.DBHost == change.New.DBHost && change.Old.DBPort == change.New.DBPort {
+					return
+				}
+				if dbName, err := catalog.DbName(change.Tenant); err == nil {
+					repoFactory.Evict(dbName)
+				}
+			})
+			return settings
+		}),
+		di.Provide(func(cfg config.IConfig, catalog *tenancy.Catalog) fiber_wrapper.TenantResolver {
+			resolver, err := tenancy.NewResolverFromConfig(cfg.GetTenancyConfig(), catalog)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return resolver
+		}),
+		di.Provide(func(cfg config.IConfig, repoFactory repo.IRepoFactory) *health.Registry {
+			healthCfg := cfg.GetHealthConfig()
+			registry := health.NewRegistry(healthCfg.Timeout)
+			registry.Register("catalog", health.Catalog(repoFactory))
+			registry.Register("tenant-pools", health.TenantPools(repoFactory, healthCfg.TenantSample))
+			registry.Register("migrations", health.Migrations(repoFactory, healthCfg.TenantSample))
+			return registry
+		}), // provide health checks, modules register their own
+		di.Provide(func(cfg config.IConfig, lc *lifecycle.Manager) mailer.Mailer {
+			m, err := mailer.New(cfg.GetMailConfig())
+			if err != nil {
+				log.Fatal(err)
+			}
+			// requests do not wait for the mail server, the queue is flushed on shutdown
+			queue := mailer.NewQueue(m, 0)
+			lc.Go("mailer", queue.Run)
+			return queue
+		}), // provide mailer
+		di.Provide(func(cfg config.IConfig) fiber_wrapper.Authorizer {
+			return authz.NewAuthorizer(cfg.GetAuthConfig().PermissionCacheTTL)
+		}),
 	)
 
 	if err != nil {
@@ -96,41 +217,102 @@ func main() {
 	}
 	// invoke function
 	if err := Container.Invoke(func(
+		// resolved first so every component logs through the configured sinks
+		lg *logger.Logger,
 		app *fiber.App,
 		tx context.Context,
 		cfg config.IConfig,
 		routers map[string]fiber_wrapper.Router,
 		repoFactory repo.IRepoFactory,
+		authorizer fiber_wrapper.Authorizer,
+		resolver fiber_wrapper.TenantResolver,
+		catalog *tenancy.Catalog,
+		settings *tenancy.Settings,
+		lc *lifecycle.Manager,
+		checks *health.Registry,
+		measures *metrics.Metrics,
+		mail mailer.Mailer,
 	) {
 
 		//decalre routes hash dict string and function
 
 		//scan routes and add to hash map
 		//add routes to app
-		startEnpont := "/api/:tenant"
-
-		app.Use(func(c *fiber.Ctx) error {
-			start := time.Now()
+		startEnpont := tenancy.BasePath(cfg.GetTenancyConfig())
 
-			// Xử lý các middleware và handler tiếp theo
-			err := c.Next()
-
-			duration := time.Since(start)
-
-			// Thêm Server-Timing header
-			c.Append("Server-Timing", fmt.Sprintf("total;dur=%d", duration.Milliseconds()))
+		if *migrate || *plan {
+			report, err := migrations.NewRunner(repoFactory, cfg.GetDBConfig().Migration).Run(tx, migrations.Options{DryRun: *plan})
+			if report != nil {
+				report.Print(os.Stdout)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(0)
+		}
+		if err := tenancy.MigrateCatalog(tx, repoFactory); err != nil {
+			lg.Named("migrations").Error("failed to migrate catalog database", "err", err)
+		}
+		// the settings updated by other instances reach the subscribers too
+		lc.Go("tenant-settings", settings.Watch)
 
-			return err
-		})
-		fiber_wrapper.InstallRouters(routers, app, startEnpont, cfg, repoFactory)
-		app.Get("/health", func(c *fiber.Ctx) error {
-			return c.SendString("OK")
+		app.Use(middleware.AccessLog(lg, cfg))
+		if metricsCfg := cfg.GetMetricsConfig(); metricsCfg.Enabled {
+			app.Get(metricsCfg.Path, measures.Handler())
+			app.Use(measures.Middleware())
+		}
+		// spans are flushed once the requests and databases stopped
+		stopTracing, err := tracing.Setup(cfg.GetTracingConfig())
+		if err != nil {
+			log.Fatal(err)
+		}
+		lc.OnStop(lifecycle.PhaseLogs, "tracing", stopTracing)
+		lc.OnStop(lifecycle.PhaseLogs, "logs", func(context.Context) error {
+			return lg.Close()
 		})
+		app.Use(tracing.Middleware())
+		app.Use(tracing.ServerTiming())
+		if compress := server.Compress(cfg.GetServerConfig().Compression); compress != nil {
+			app.Use(compress)
+		}
+		app.Use(middleware.CORS(cfg))
+		app.Use(middleware.RateLimit(cfg))
+		app.Use(security.Authenticate(security.NewTokenService(cfg.GetAuthConfig())))
+		fiber_wrapper.InstallRouters(routers, app, startEnpont, cfg, repoFactory, authorizer, resolver, settings, mail)
+		platform.Install(app, cfg.GetPlatformConfig(), tenancy.NewService(repoFactory, catalog, cfg, mail), settings)
+		health.Install(app, checks, lc.Ready)
 
-		app.Listen(cfg.GetServerConfig().Host + ":" + cfg.GetServerConfig().Port)
+		// on SIGTERM the server stops accepting connections and drains its requests first
+		lc.OnStop(lifecycle.PhaseServer, "http", app.ShutdownWithContext)
+		app.Hooks().OnListen(func(fiber.ListenData) error {
+			lc.MarkReady()
+			return nil
+		})
+		go lc.Run(tx)
+		if err := server.Listen(app, cfg.GetServerConfig()); err != nil {
+			log.Fatal(err)
+		}
+		<-lc.Done()
 
 	}); err != nil {
 		log.Fatal(err)
 	}
 
 }
+
+// encryptSecret prints the encrypted reference of the first line of stdin
+func encryptSecret() {
+	key, err := config.MasterKey(os.Environ())
+	if err != nil {
+		log.Fatal(err)
+	}
+	secret, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+	ref, err := config.Encrypt(key, strings.TrimRight(secret, "\r\n"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(ref)
+}
@@ -0,0 +1,133 @@
+// Package metrics exposes the Prometheus metrics of the HTTP server, the
+// database pools and queries, and the Go runtime.
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vngom/config"
+	"vngom/repo"
+	"vngom/tenantscope"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// otherTenants labels the tenants beyond metrics.maxTenants
+	otherTenants = "other"
+	// unmatched labels the requests matching no route, their raw path is unbounded
+	unmatched = "unmatched"
+)
+
+type Metrics struct {
+	cfg      config.MetricsConfig
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	queries  *prometheus.HistogramVec
+
+	lock    sync.Mutex
+	tenants map[string]struct{}
+}
+
+// New registers the metrics of the HTTP server, the queries and the runtime
+func New(cfg config.MetricsConfig) *Metrics {
+	m := &Metrics{
+		cfg:      cfg,
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route template, method, status and tenant.",
+		}, []string{"route", "method", "status", "tenant"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of the HTTP requests by route template, method, status and tenant.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status", "tenant"}),
+		queries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Latency of the database statements by operation and table.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "table"}),
+		tenants: map[string]struct{}{},
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.queries,
+	)
+	return m
+}
+
+// CollectPools reports the connection pools of the databases opened by rf, read on each scrape
+func (m *Metrics) CollectPools(rf repo.IRepoFactory) {
+	m.registry.MustRegister(newPoolCollector(rf))
+}
+
+// Registry lets modules register their own metrics
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware measures the requests. It must run before the routes so their
+// template and the tenant they resolved are known once they returned.
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		own := c.Route()
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		// the route is still the middleware itself when none matched
+		route := c.Route().Path
+		if c.Route() == own {
+			route = unmatched
+		}
+		// fiber reuses the buffers of the request strings, the labels outlive it
+		labels := prometheus.Labels{
+			"route":  route,
+			"method": strings.Clone(c.Method()),
+			"status": strconv.Itoa(status),
+			"tenant": m.tenant(tenantscope.FromContext(c.UserContext())),
+		}
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// tenant bounds the cardinality of the tenant label: it is empty unless
+// metrics.tenantLabel is set, and the tenants seen after the first
+// metrics.maxTenants ones share the "other" label
+func (m *Metrics) tenant(tenant string) string {
+	if !m.cfg.TenantLabel || tenant == "" {
+		return ""
+	}
+	tenant = strings.Clone(tenant)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.tenants[tenant]; ok {
+		return tenant
+	}
+	if len(m.tenants) >= m.cfg.MaxTenants {
+		return otherTenants
+	}
+	m.tenants[tenant] = struct{}{}
+	return tenant
+}
@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"vngom/config"
+	"vngom/metrics"
+	"vngom/tenantscope"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func scrape(t *testing.T, app *fiber.App) string {
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	m := metrics.New(config.MetricsConfig{TenantLabel: true, MaxTenants: 1})
+	app := fiber.New()
+	app.Get("/metrics", m.Handler())
+	app.Use(m.Middleware())
+	app.Get("/api/:tenant/employees/:id", func(c *fiber.Ctx) error {
+		c.SetUserContext(tenantscope.WithTenant(c.UserContext(), c.Params("tenant")))
+		if c.Params("id") == "0" {
+			return fiber.ErrNotFound
+		}
+		return c.SendString("ok")
+	})
+	for _, path := range []string{"/api/acme/employees/1", "/api/acme/employees/2", "/api/globex/employees/0", "/nowhere/42"} {
+		_, err := app.Test(httptest.NewRequest("GET", path, nil))
+		assert.NoError(t, err)
+	}
+
+	body := scrape(t, app)
+	// labelled by route template, tenants beyond maxTenants share a label
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/api/:tenant/employees/:id",status="200",tenant="acme"} 2`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/api/:tenant/employees/:id",status="404",tenant="other"} 1`)
+	assert.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404",tenant=""} 1`)
+	assert.NotContains(t, body, "/nowhere")
+	assert.Contains(t, body, "http_request_duration_seconds_bucket")
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestQueryMetrics(t *testing.T) {
+	m := metrics.New(config.MetricsConfig{})
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Use(m.Plugin()))
+	type Item struct {
+		ID   uint
+		Name string
+	}
+	assert.NoError(t, db.AutoMigrate(&Item{}))
+	assert.NoError(t, db.Create(&Item{Name: "a"}).Error)
+	var items []Item
+	assert.NoError(t, db.Find(&items).Error)
+
+	app := fiber.New()
+	app.Get("/metrics", m.Handler())
+	body := scrape(t, app)
+	assert.Contains(t, body, `db_query_duration_seconds_count{operation="create",table="items"} 1`)
+	assert.Contains(t, body, `db_query_duration_seconds_count{operation="query",table="items"} 1`)
+}
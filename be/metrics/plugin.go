@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const startKey = "metrics:start"
+
+// Plugin returns the GORM plugin measuring the latency of the statements
+func (m *Metrics) Plugin() gorm.Plugin {
+	return &plugin{m: m}
+}
+
+type plugin struct {
+	m *Metrics
+}
+
+func (p *plugin) Name() string {
+	return "metrics"
+}
+
+func (p *plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("metrics:before_create", start),
+		cb.Create().After("*").Register("metrics:after_create", p.observe("create")),
+		cb.Query().Before("*").Register("metrics:before_query", start),
+		cb.Query().After("*").Register("metrics:after_query", p.observe("query")),
+		cb.Update().Before("*").Register("metrics:before_update", start),
+		cb.Update().After("*").Register("metrics:after_update", p.observe("update")),
+		cb.Delete().Before("*").Register("metrics:before_delete", start),
+		cb.Delete().After("*").Register("metrics:after_delete", p.observe("delete")),
+		cb.Row().Before("*").Register("metrics:before_row", start),
+		cb.Row().After("*").Register("metrics:after_row", p.observe("row")),
+		cb.Raw().Before("*").Register("metrics:before_raw", start),
+		cb.Raw().After("*").Register("metrics:after_raw", p.observe("raw")),
+	)
+}
+
+func start(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p *plugin) observe(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "raw"
+		}
+		p.m.queries.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+	}
+}
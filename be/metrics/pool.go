@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"vngom/repo"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reports the connection pools of the open databases on each scrape
+type poolCollector struct {
+	rf           repo.IRepoFactory
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func newPoolCollector(rf repo.IRepoFactory) *poolCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, []string{"db"}, nil)
+	}
+	return &poolCollector{
+		rf:           rf,
+		open:         desc("db_pool_open_connections", "Open connections of the pool of a database."),
+		inUse:        desc("db_pool_in_use_connections", "Connections of the pool of a database in use."),
+		idle:         desc("db_pool_idle_connections", "Idle connections of the pool of a database."),
+		waitCount:    desc("db_pool_wait_count_total", "Connections waited for on the pool of a database."),
+		waitDuration: desc("db_pool_wait_duration_seconds_total", "Time spent waiting for a connection of the pool of a database."),
+	}
+}
+
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.open
+	ch <- p.inUse
+	ch <- p.idle
+	ch <- p.waitCount
+	ch <- p.waitDuration
+}
+
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, db := range p.rf.Stats() {
+		ch <- prometheus.MustNewConstMetric(p.open, prometheus.GaugeValue, float64(db.Stats.OpenConnections), db.DbName)
+		ch <- prometheus.MustNewConstMetric(p.inUse, prometheus.GaugeValue, float64(db.Stats.InUse), db.DbName)
+		ch <- prometheus.MustNewConstMetric(p.idle, prometheus.GaugeValue, float64(db.Stats.Idle), db.DbName)
+		ch <- prometheus.MustNewConstMetric(p.waitCount, prometheus.CounterValue, float64(db.Stats.WaitCount), db.DbName)
+		ch <- prometheus.MustNewConstMetric(p.waitDuration, prometheus.CounterValue, db.Stats.WaitDuration.Seconds(), db.DbName)
+	}
+}
@@ -0,0 +1,238 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The baselines create the tables as the models were declared when migrations
+// were versioned. They are harmless on databases created before, AutoMigrate
+// only adds what is missing. The models are copied rather than referenced so
+// later changes of the models are left to the migrations making them.
+func init() {
+	Register(Catalog, Migration{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v1TenantInfo{}, &v1MigrationFailure{})
+		},
+	})
+	Register(Tenant, Migration{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(
+				&v1Account{},
+				&v1PasswordHistory{},
+				&v1AccountToken{},
+				&v1RecoveryCode{},
+				&v1PersonalInfo{},
+				&v1Employee{},
+				&v1Department{},
+				&v1Permission{},
+				&v1Role{},
+				&v1RolePermission{},
+				&v1AccountRole{},
+			)
+		},
+	})
+}
+
+type v1TenantInfo struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey"`
+	Name        string     `gorm:"type:char(191);uniqueIndex:idx_name"`
+	Description string     `gorm:"type:text"`
+	Status      int        `gorm:"default:1;column:Status"`
+	DeletedAt   *time.Time `gorm:"index;column:DeletedAt"`
+	DeletedBy   *string    `gorm:"index;column:DeletedBy"`
+	DbTenant    string     `gorm:"uniqueIndex:idx_db_tenants_name,length:191"`
+	LastError   string     `gorm:"type:text"`
+
+	CreatedOn  time.Time  `gorm:"index"`
+	ModifiedOn *time.Time `gorm:"index"`
+	ModifiedBy string     `gorm:"index"`
+	CreatedBy  string     `gorm:"index"`
+}
+
+func (t *v1TenantInfo) TableName() string {
+	return "tenant_infos"
+}
+
+type v1MigrationFailure struct {
+	ID       uuid.UUID `gorm:"type:char(36);primaryKey"`
+	TenantID uuid.UUID `gorm:"type:char(36);index"`
+	Tenant   string    `gorm:"type:varchar(191);index"`
+	Version  int64
+	Name     string    `gorm:"type:varchar(191)"`
+	Error    string    `gorm:"type:text"`
+	FailedOn time.Time `gorm:"index"`
+}
+
+func (m *v1MigrationFailure) TableName() string {
+	return "MigrationFailure"
+}
+
+// v1BaseModel is embedded through a named field, gorm skips unexported anonymous fields
+type v1BaseModel struct {
+	ID uuid.UUID `gorm:"type:char(36);primaryKey"`
+
+	CreatedOn  time.Time `gorm:"index"`
+	ModifiedOn time.Time `gorm:"index"`
+	ModifiedBy string    `gorm:"index;type:varchar(50)"`
+	CreatedBy  string    `gorm:"index;varchar(50)"`
+}
+
+type v1Account struct {
+	Base     v1BaseModel `gorm:"embedded"`
+	Username string      `gorm:"type:varchar(191);uniqueIndex:idx_username;"`
+	Email    string      `gorm:"type:varchar(191);uniqueIndex:idx_email;"`
+	Password string      `gorm:"type:varchar(191);"`
+	Salt     string      `gorm:"not null;"`
+
+	PasswordChangedOn *time.Time
+	FailedAttempts    int `gorm:"not null;default:0"`
+	LockedUntil       *time.Time
+
+	EmailVerified   bool `gorm:"not null;default:false"`
+	EmailVerifiedOn *time.Time
+
+	TwoFactorEnabled  bool   `gorm:"not null;default:false"`
+	TwoFactorSecret   string `gorm:"type:varchar(64)"`
+	TwoFactorLastStep int64  `gorm:"not null;default:0"`
+}
+
+func (a *v1Account) TableName() string {
+	return "accounts"
+}
+
+type v1RecoveryCode struct {
+	Base      v1BaseModel `gorm:"embedded"`
+	AccountID uuid.UUID   `gorm:"type:char(36);index"`
+	CodeHash  string      `gorm:"type:varchar(191)"`
+	UsedOn    *time.Time
+}
+
+func (r *v1RecoveryCode) TableName() string {
+	return "RecoveryCode"
+}
+
+type v1AccountToken struct {
+	Base      v1BaseModel `gorm:"embedded"`
+	AccountID uuid.UUID   `gorm:"type:char(36);index"`
+	Purpose   string      `gorm:"type:varchar(20);index"`
+	TokenHash string      `gorm:"type:char(64);uniqueIndex:idx_account_token_hash"`
+	ExpiresOn time.Time   `gorm:"index"`
+	UsedOn    *time.Time
+}
+
+func (t *v1AccountToken) TableName() string {
+	return "AccountToken"
+}
+
+type v1PasswordHistory struct {
+	Base      v1BaseModel `gorm:"embedded"`
+	AccountID uuid.UUID   `gorm:"type:char(36);index"`
+	Hash      string      `gorm:"type:varchar(191)"`
+}
+
+func (h *v1PasswordHistory) TableName() string {
+	return "PasswordHistory"
+}
+
+type v1PersonalInfo struct {
+	Base      v1BaseModel `gorm:"embedded"`
+	FirstName string      `gorm:"type:varchar(100);index"`
+	LastName  string      `gorm:"type:varchar(100);index"`
+
+	DateOfBirth *time.Time
+	Gender      string `gorm:"type:varchar(10);index"`
+	Address     string `gorm:"type:varchar(255)"`
+	PhoneNumber string `gorm:"type:varchar(20);index"`
+	Nationality string `gorm:"type:varchar(50)"`
+	BirthPlace  string `gorm:"type:varchar(100)"`
+}
+
+func (p *v1PersonalInfo) TableName() string {
+	return "PersonalInfo"
+}
+
+type v1Employee struct {
+	Base      v1BaseModel `gorm:"embedded"`
+	User      *v1Account  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	Code      string      `gorm:"uniqueIndex:idx_employee_code,length:191"`
+	FirstName string      `gorm:"index:idx_employee_firstname,length:191"`
+	LastName  string      `gorm:"index:idx_employee_lastname,length:191"`
+	Gender    string      `gorm:"index:idx_employee_gender,length:191"`
+
+	JoinDate     time.Time
+	UserID       *uuid.UUID      `gorm:"unique"`
+	Personal     *v1PersonalInfo `gorm:"foreignKey:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	DepartmentID *uint           `gorm:"index"`
+}
+
+func (e *v1Employee) TableName() string {
+	return "Employee"
+}
+
+type v1Department struct {
+	Employees  []v1Employee `gorm:"foreignKey:DepartmentID;references:ID"`
+	ID         uint         `gorm:"primaryKey;autoIncrement"`
+	Code       string       `gorm:"type:varchar(50);index;column:Code"`
+	Name       string       `gorm:"type:varchar(191);index"`
+	CreatedOn  time.Time    `gorm:"index;column:CreatedOn"`
+	ModifiedOn time.Time    `gorm:"index;column:ModifiedOn"`
+	ModifiedBy string       `gorm:"index,length:191;column:ModifiedBy"`
+	CreatedBy  string       `gorm:"index,length:191;column:CreatedBy"`
+	Level      uint         `gorm:"type:int;index;column:Level"`
+	ParentID   uint         `gorm:"type:int;index;column:ParentID"`
+	LevelCode  string       `gorm:"type:varchar(191);index;column:LevelCode"`
+}
+
+func (d *v1Department) TableName() string {
+	return "Department"
+}
+
+type v1Permission struct {
+	Base        v1BaseModel `gorm:"embedded"`
+	Code        string      `gorm:"type:varchar(100);uniqueIndex:idx_permission_code"`
+	Description string      `gorm:"type:varchar(255)"`
+}
+
+func (p *v1Permission) TableName() string {
+	return "Permission"
+}
+
+type v1Role struct {
+	Base             v1BaseModel `gorm:"embedded"`
+	Code             string      `gorm:"type:varchar(100);uniqueIndex:idx_role_code"`
+	Name             string      `gorm:"type:varchar(191)"`
+	Description      string      `gorm:"type:varchar(255)"`
+	IsSystem         bool
+	DataScope        string `gorm:"type:varchar(20);default:all"`
+	RequireTwoFactor bool   `gorm:"not null;default:false"`
+}
+
+func (r *v1Role) TableName() string {
+	return "Role"
+}
+
+type v1RolePermission struct {
+	RoleID       uuid.UUID `gorm:"type:char(36);primaryKey"`
+	PermissionID uuid.UUID `gorm:"type:char(36);primaryKey"`
+}
+
+func (rp *v1RolePermission) TableName() string {
+	return "RolePermission"
+}
+
+type v1AccountRole struct {
+	AccountID uuid.UUID `gorm:"type:char(36);primaryKey"`
+	RoleID    uuid.UUID `gorm:"type:char(36);primaryKey;index"`
+	Role      *v1Role   `gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+}
+
+func (ar *v1AccountRole) TableName() string {
+	return "AccountRole"
+}
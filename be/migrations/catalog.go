@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func init() {
+	Register(Catalog, Migration{
+		Version: 2,
+		Name:    "tenant_events",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v2TenantEvent{})
+		},
+	})
+	// shared tenants have the same database, DbTenant is not unique anymore
+	Register(Catalog, Migration{
+		Version: 3,
+		Name:    "tenant_isolation",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&v3TenantInfo{}); err != nil {
+				return err
+			}
+			m := tx.Migrator()
+			if err := m.DropIndex(&v3TenantInfo{}, "idx_db_tenants_name"); err != nil {
+				return err
+			}
+			return m.CreateIndex(&v3TenantInfo{}, "idx_db_tenants_name")
+		},
+	})
+	Register(Catalog, Migration{
+		Version: 4,
+		Name:    "tenant_settings",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v4TenantSetting{})
+		},
+	})
+}
+
+type v2TenantEvent struct {
+	ID         uuid.UUID `gorm:"type:char(36);primaryKey"`
+	TenantID   uuid.UUID `gorm:"type:char(36);index"`
+	Tenant     string    `gorm:"type:varchar(191);index"`
+	Action     string    `gorm:"type:varchar(50)"`
+	FromStatus int
+	ToStatus   int
+	Reason     string    `gorm:"type:text"`
+	Actor      string    `gorm:"type:varchar(191)"`
+	CreatedOn  time.Time `gorm:"index"`
+}
+
+func (e *v2TenantEvent) TableName() string {
+	return "TenantEvent"
+}
+
+type v3TenantInfo struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey"`
+	Name        string     `gorm:"type:char(191);uniqueIndex:idx_name"`
+	Description string     `gorm:"type:text"`
+	Status      int        `gorm:"default:1;column:Status"`
+	DeletedAt   *time.Time `gorm:"index;column:DeletedAt"`
+	DeletedBy   *string    `gorm:"index;column:DeletedBy"`
+	DbTenant    string     `gorm:"index:idx_db_tenants_name,length:191"`
+	Isolation   string     `gorm:"type:varchar(20);not null;default:database"`
+	LastError   string     `gorm:"type:text"`
+
+	CreatedOn  time.Time  `gorm:"index"`
+	ModifiedOn *time.Time `gorm:"index"`
+	ModifiedBy string     `gorm:"index"`
+	CreatedBy  string     `gorm:"index"`
+}
+
+func (t *v3TenantInfo) TableName() string {
+	return "tenant_infos"
+}
+
+type v4TenantSetting struct {
+	Tenant     string    `gorm:"type:varchar(191);primaryKey"`
+	Version    int       `gorm:"primaryKey;autoIncrement:false"`
+	Overrides  string    `gorm:"type:text"`
+	ModifiedOn time.Time `gorm:"index"`
+	ModifiedBy string    `gorm:"type:varchar(191)"`
+}
+
+func (s *v4TenantSetting) TableName() string {
+	return "TenantSetting"
+}
@@ -0,0 +1,191 @@
+// migrations applies ordered, versioned schema changes to the catalog database
+// and to every tenant database. The versions applied to a database are recorded
+// in its SchemaMigration table.
+//
+// A migration is either a Go function registered with Register or a SQL file
+// embedded from sql/<scope>/<version>_<name>.<dialect>.sql, where dialect is the
+// gorm dialector name (postgres, mysql, sqlite). A SQL migration must provide a
+// file for every dialect it runs on.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scope tells which databases a migration applies to
+type Scope string
+
+const (
+	Catalog Scope = "catalog"
+	Tenant  Scope = "tenant"
+)
+
+var ErrMissingScript = errors.New("migration has no script for this database type")
+
+// Migration is a single schema change. It runs in a transaction, on databases
+// where DDL is not transactional a failed migration must be safe to run again.
+type Migration struct {
+	Version int64
+	Name    string
+	// Up applies a Go migration
+	Up func(tx *gorm.DB) error
+	// SQL holds the script of a SQL migration by dialect name
+	SQL map[string]string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+func (m Migration) run(tx *gorm.DB) error {
+	if m.Up != nil {
+		return m.Up(tx)
+	}
+	script, ok := m.SQL[tx.Dialector.Name()]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMissingScript, tx.Dialector.Name())
+	}
+	for _, statement := range splitStatements(script) {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Error is returned by Apply when a migration fails
+type Error struct {
+	Migration Migration
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("migration %s failed: %v", e.Migration, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// SchemaMigration is a migration applied to the database holding the table
+type SchemaMigration struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"type:varchar(191)"`
+	AppliedOn time.Time
+}
+
+func (m *SchemaMigration) TableName() string {
+	return "SchemaMigration"
+}
+
+var (
+	registry     = map[Scope][]Migration{}
+	registryLock sync.RWMutex
+)
+
+// Register adds a migration to a scope. Versions are unique within a scope,
+// registering one twice is a programming error and panics.
+func Register(scope Scope, m Migration) {
+	if m.Version <= 0 || (m.Up == nil && len(m.SQL) == 0) {
+		panic(fmt.Sprintf("migrations: invalid %s migration %s", scope, m))
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for _, existing := range registry[scope] {
+		if existing.Version == m.Version {
+			panic(fmt.Sprintf("migrations: %s migration %s conflicts with %s", scope, m, existing))
+		}
+	}
+	registry[scope] = append(registry[scope], m)
+}
+
+// List returns the migrations of a scope ordered by version
+func List(scope Scope) []Migration {
+	registryLock.RLock()
+	ret := append([]Migration(nil), registry[scope]...)
+	registryLock.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	return ret
+}
+
+// Pending returns the migrations of a scope not applied to db yet, it changes nothing
+func Pending(ctx context.Context, db *gorm.DB, scope Scope) ([]Migration, error) {
+	db = db.WithContext(ctx)
+	all := List(scope)
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return all, nil
+	}
+	var versions []int64
+	if err := db.Model(&SchemaMigration{}).Pluck("version", &versions).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+	ret := make([]Migration, 0, len(all))
+	for _, m := range all {
+		if !applied[m.Version] {
+			ret = append(ret, m)
+		}
+	}
+	return ret, nil
+}
+
+// Apply runs the pending migrations of a scope in order, each one in its own
+// transaction with its SchemaMigration record. It stops at the first failure,
+// returned as an *Error, and returns the migrations applied before it.
+func Apply(ctx context.Context, db *gorm.DB, scope Scope) ([]Migration, error) {
+	db = db.WithContext(ctx)
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	pending, err := Pending(ctx, db, scope)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]Migration, 0, len(pending))
+	for _, m := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.run(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedOn: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return applied, &Error{Migration: m, Err: err}
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// splitStatements cuts a script into statements ending with a semicolon at the end of a line
+func splitStatements(script string) []string {
+	var ret []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			ret = append(ret, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		ret = append(ret, rest)
+	}
+	return ret
+}
@@ -0,0 +1,185 @@
+package migrations_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/migrations"
+	"vngom/models"
+	"vngom/models/tenants"
+	"vngom/repo"
+	"vngom/tenancy"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func init() {
+	repo.RegisterDialect("sqlite", repo.Dialect{
+		Open: func(conn repo.Connection, dbName string) (gorm.Dialector, error) {
+			return sqlite.Open("file:" + dbName + "?mode=memory&cache=shared"), nil
+		},
+	})
+	migrations.Register(migrations.Tenant, migrations.Migration{
+		Version: 1000,
+		Name:    "probe",
+		SQL:     map[string]string{"sqlite": "CREATE TABLE Probe (id integer);\nINSERT INTO Probe (id) VALUES (1);"},
+	})
+}
+
+func newFactory(t *testing.T) repo.IRepoFactory {
+	f := repo.NewRepoFactory("sqlite")
+	f.ConfigCatalog(t.Name()+"_catalog", "")
+	f.SetDbNameResolver(tenancy.NewCatalog(f, 0).DbName)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func addTenant(t *testing.T, f repo.IRepoFactory, name string, status int) {
+	catalog, err := f.GetCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = catalog.GetDb().Create(&tenants.TenantInfo{
+		ID:        uuid.New(),
+		Name:      name,
+		Status:    status,
+		DbTenant:  t.Name() + "_" + name,
+		CreatedOn: time.Now().UTC(),
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyRecordsVersions(t *testing.T) {
+	f := newFactory(t)
+	catalog, err := f.GetCatalog()
+	assert.NoError(t, err)
+	db := catalog.GetDb()
+
+	pending, err := migrations.Pending(context.Background(), db, migrations.Catalog)
+	assert.NoError(t, err)
+	assert.Len(t, pending, len(migrations.List(migrations.Catalog)))
+	assert.False(t, db.Migrator().HasTable(&migrations.SchemaMigration{}), "pending must not write")
+
+	applied, err := migrations.Apply(context.Background(), db, migrations.Catalog)
+	assert.NoError(t, err)
+	assert.Len(t, applied, len(pending))
+	assert.True(t, db.Migrator().HasTable(&tenants.TenantInfo{}))
+
+	applied, err = migrations.Apply(context.Background(), db, migrations.Catalog)
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestTenantMigrationsAreOrdered(t *testing.T) {
+	var names []string
+	for _, m := range migrations.List(migrations.Tenant) {
+		names = append(names, m.String())
+	}
+	assert.Equal(t, []string{"0001_baseline", "0002_employee_join_date_index", "0003_tenant_id", "0004_audit_log", "1000_probe"}, names)
+}
+
+// assertSchemaOf asserts the database has the columns and indexes the models declare
+func assertSchemaOf(t *testing.T, db *gorm.DB, models []interface{}) {
+	m := db.Migrator()
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		assert.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				assert.True(t, m.HasColumn(model, field.DBName), "%s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+		for _, idx := range stmt.Schema.ParseIndexes() {
+			assert.True(t, m.HasIndex(model, idx.Name), "%s.%s", stmt.Schema.Table, idx.Name)
+		}
+	}
+}
+
+func TestMigrationsBuildTheModels(t *testing.T) {
+	open := func() *gorm.DB {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	// the baseline is the schema of version 1, the later migrations add to it
+	db := open()
+	assert.NoError(t, migrations.List(migrations.Tenant)[0].Up(db))
+	assert.True(t, db.Migrator().HasTable("accounts"))
+	assert.False(t, db.Migrator().HasColumn("accounts", "tenant_id"))
+	assert.False(t, db.Migrator().HasTable("AuditLog"))
+
+	_, err := migrations.Apply(context.Background(), db, migrations.Tenant)
+	assert.NoError(t, err)
+	assertSchemaOf(t, db, models.TenantModels())
+
+	db = open()
+	_, err = migrations.Apply(context.Background(), db, migrations.Catalog)
+	assert.NoError(t, err)
+	assertSchemaOf(t, db, models.CatalogModels())
+}
+
+func TestRunnerFansOutToTenants(t *testing.T) {
+	f := newFactory(t)
+	runner := migrations.NewRunner(f, config.MigrationConfig{Concurrency: 2})
+	// the catalog has to exist before tenants can be registered
+	_, err := runner.Run(context.Background(), migrations.Options{})
+	assert.NoError(t, err)
+	addTenant(t, f, "acme", tenants.StatusActive)
+	addTenant(t, f, "globex", tenants.StatusInactive)
+	addTenant(t, f, "initech", tenants.StatusActive)
+	addTenant(t, f, "pending", tenants.StatusProvisioning)
+
+	// initech already has a Probe table, its last migration fails
+	initech, err := f.Get("initech")
+	assert.NoError(t, err)
+	assert.NoError(t, initech.GetDb().Exec("CREATE TABLE Probe (id integer)").Error)
+
+	plan, err := runner.Run(context.Background(), migrations.Options{DryRun: true})
+	assert.NoError(t, err)
+	assert.Len(t, plan.Tenants, 3)
+	for _, res := range plan.Tenants {
+		assert.Len(t, res.Migrations, 5, res.Tenant)
+	}
+	var out bytes.Buffer
+	plan.Print(&out)
+	assert.Contains(t, out.String(), "tenant acme ("+t.Name()+"_acme): pending 0001_baseline 0002_employee_join_date_index 0003_tenant_id 0004_audit_log 1000_probe")
+
+	report, err := runner.Run(context.Background(), migrations.Options{})
+	assert.ErrorIs(t, err, migrations.ErrTenantsFailed)
+	failed := report.Failed()
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "initech", failed[0].Tenant)
+		assert.Len(t, failed[0].Migrations, 4)
+	}
+	catalog, err := f.GetCatalog()
+	assert.NoError(t, err)
+	var failures []tenants.MigrationFailure
+	assert.NoError(t, catalog.GetDb().Find(&failures).Error)
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "initech", failures[0].Tenant)
+		assert.Equal(t, int64(1000), failures[0].Version)
+	}
+
+	// the successful tenants are up to date, the failed one resumes at its failed migration
+	assert.NoError(t, initech.GetDb().Exec("DROP TABLE Probe").Error)
+	plan, err = runner.Run(context.Background(), migrations.Options{DryRun: true})
+	assert.NoError(t, err)
+	for _, res := range plan.Tenants {
+		if res.Tenant == "initech" {
+			assert.Len(t, res.Migrations, 1)
+		} else {
+			assert.Empty(t, res.Migrations, res.Tenant)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"vngom/config"
+	"vngom/models/tenants"
+	"vngom/repo"
+
+	"github.com/google/uuid"
+)
+
+const defaultConcurrency = 4
+
+var ErrTenantsFailed = errors.New("migrations failed on some tenants")
+
+// Options of a run. DryRun only computes the plan, nothing is written.
+type Options struct {
+	DryRun bool
+}
+
+// Result is the outcome of a run on one database
+type Result struct {
+	// Tenant is empty for the catalog
+	Tenant string
+	DbName string
+	// Migrations are the pending migrations of a dry run, the applied ones otherwise
+	Migrations []Migration
+	Err        error
+}
+
+// Report is the outcome of a run on the catalog and every tenant
+type Report struct {
+	DryRun  bool
+	Catalog Result
+	Tenants []Result
+}
+
+// Failed returns the results holding an error
+func (r *Report) Failed() []Result {
+	var ret []Result
+	for _, res := range append([]Result{r.Catalog}, r.Tenants...) {
+		if res.Err != nil {
+			ret = append(ret, res)
+		}
+	}
+	return ret
+}
+
+// Print writes one line per database
+func (r *Report) Print(w io.Writer) {
+	verb := "applied"
+	if r.DryRun {
+		verb = "pending"
+	}
+	for _, res := range append([]Result{r.Catalog}, r.Tenants...) {
+		label := "catalog " + res.DbName
+		if res.Tenant != "" {
+			label = fmt.Sprintf("tenant %s (%s)", res.Tenant, res.DbName)
+		}
+		names := make([]string, len(res.Migrations))
+		for i, m := range res.Migrations {
+			names[i] = m.String()
+		}
+		switch {
+		case res.Err != nil:
+			fmt.Fprintf(w, "%s: FAILED after [%s]: %v\n", label, strings.Join(names, " "), res.Err)
+		case len(names) == 0:
+			fmt.Fprintf(w, "%s: up to date\n", label)
+		default:
+			fmt.Fprintf(w, "%s: %s %s\n", label, verb, strings.Join(names, " "))
+		}
+	}
+}
+
+// Runner applies the migrations to the catalog then to every tenant database
+type Runner struct {
+	rf          repo.IRepoFactory
+	concurrency int
+}
+
+func NewRunner(rf repo.IRepoFactory, cfg config.MigrationConfig) *Runner {
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	return &Runner{rf: rf, concurrency: concurrency}
+}
+
+// Run migrates the catalog then the tenants that are active or suspended, the
+// others are migrated when they are provisioned. Tenants are independent, a
+// failure is recorded in the catalog MigrationFailure table and the others go on.
+func (r *Runner) Run(ctx context.Context, opts Options) (*Report, error) {
+	catalog, err := r.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{DryRun: opts.DryRun, Catalog: Result{DbName: catalog.GetDbName()}}
+	if opts.DryRun {
+		report.Catalog.Migrations, report.Catalog.Err = Pending(ctx, catalog.GetDb(), Catalog)
+	} else {
+		report.Catalog.Migrations, report.Catalog.Err = Apply(ctx, catalog.GetDb(), Catalog)
+	}
+	if report.Catalog.Err != nil {
+		return report, report.Catalog.Err
+	}
+
+	var list []tenants.TenantInfo
+	err = catalog.GetDb().WithContext(ctx).
+		Where(map[string]interface{}{"DeletedAt": nil, "Status": []int{tenants.StatusActive, tenants.StatusInactive}}).
+		Order("name").Find(&list).Error
+	if err != nil {
+		return report, err
+	}
+	report.Tenants = make([]Result, len(list))
+	// tenants of a shared database are migrated one after the other, the first one applies the migrations
+	locks := map[string]*sync.Mutex{}
+	for _, tenant := range list {
+		if locks[tenant.DbTenant] == nil {
+			locks[tenant.DbTenant] = &sync.Mutex{}
+		}
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < r.concurrency && w < len(list); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				lock := locks[list[i].DbTenant]
+				lock.Lock()
+				report.Tenants[i] = r.migrateTenant(ctx, list[i], opts)
+				lock.Unlock()
+			}
+		}()
+	}
+	for i := range list {
+		if ctx.Err() != nil {
+			report.Tenants[i] = Result{Tenant: list[i].Name, DbName: list[i].DbTenant, Err: ctx.Err()}
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed := len(report.Failed()); failed > 0 {
+		return report, fmt.Errorf("%w: %d of %d", ErrTenantsFailed, failed, len(list))
+	}
+	return report, nil
+}
+
+func (r *Runner) migrateTenant(ctx context.Context, tenant tenants.TenantInfo, opts Options) Result {
+	res := Result{Tenant: tenant.Name, DbName: tenant.DbTenant}
+	db, err := r.rf.Get(tenant.Name)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.DbName = db.GetDbName()
+	if opts.DryRun {
+		res.Migrations, res.Err = Pending(ctx, db.GetDb(), Tenant)
+		return res
+	}
+	res.Migrations, res.Err = Apply(ctx, db.GetDb(), Tenant)
+	if res.Err != nil {
+		r.recordFailure(tenant, res.Err)
+	}
+	return res
+}
+
+func (r *Runner) recordFailure(tenant tenants.TenantInfo, err error) {
+	failure := tenants.MigrationFailure{
+		ID:       uuid.New(),
+		TenantID: tenant.ID,
+		Tenant:   tenant.Name,
+		Error:    err.Error(),
+		FailedOn: time.Now().UTC(),
+	}
+	var migrationErr *Error
+	if errors.As(err, &migrationErr) {
+		failure.Version = migrationErr.Migration.Version
+		failure.Name = migrationErr.Migration.Name
+	}
+	catalog, catalogErr := r.rf.GetCatalog()
+	if catalogErr == nil {
+		catalogErr = catalog.GetDb().Create(&failure).Error
+	}
+	if catalogErr != nil {
+		// the report still carries the failure
+		slog.Default().With("logger", "migrations").Error("failed to record migration failure", "tenant", tenant.Name, "err", catalogErr)
+	}
+}
@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql
+var scripts embed.FS
+
+func init() {
+	for _, scope := range []Scope{Catalog, Tenant} {
+		ms, err := loadScripts(scripts, "sql/"+string(scope))
+		if err != nil {
+			panic(err)
+		}
+		for _, m := range ms {
+			Register(scope, m)
+		}
+	}
+}
+
+// loadScripts groups the files <version>_<name>.<dialect>.sql of a directory by version
+func loadScripts(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	var ret []*Migration
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		base := strings.TrimSuffix(e.Name(), ".sql")
+		dot := strings.LastIndex(base, ".")
+		underscore := strings.Index(base, "_")
+		if dot < 0 || underscore < 0 || underscore > dot {
+			return nil, fmt.Errorf("migrations: %s/%s is not named <version>_<name>.<dialect>.sql", dir, e.Name())
+		}
+		version, err := strconv.ParseInt(base[:underscore], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrations: invalid version in %s/%s", dir, e.Name())
+		}
+		name, dialect := base[underscore+1:dot], base[dot+1:]
+		content, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name, SQL: map[string]string{}}
+			byVersion[version] = m
+			ret = append(ret, m)
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migrations: version %d of %s is named both %s and %s", version, dir, m.Name, name)
+		}
+		m.SQL[dialect] = string(content)
+	}
+	ms := make([]Migration, len(ret))
+	for i, m := range ret {
+		ms[i] = *m
+	}
+	return ms, nil
+}
@@ -0,0 +1,2 @@
+-- employees are listed and filtered by hiring date
+CREATE INDEX IF NOT EXISTS idx_employee_join_date ON `Employee` (join_date);
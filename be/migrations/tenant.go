@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func init() {
+	// TenantID lets tenants share a database, unique values only have to be unique within a tenant
+	Register(Tenant, Migration{
+		Version: 3,
+		Name:    "tenant_id",
+		Up: func(tx *gorm.DB) error {
+			for _, table := range []string{
+				"accounts", "PasswordHistory", "AccountToken", "RecoveryCode", "PersonalInfo",
+				"Employee", "Permission", "Role", "RolePermission", "AccountRole",
+			} {
+				if err := addTenantColumn(tx, table, "tenant_id"); err != nil {
+					return err
+				}
+			}
+			if err := addTenantColumn(tx, "Department", "TenantID"); err != nil {
+				return err
+			}
+			for _, idx := range []struct {
+				table  string
+				name   string
+				column string
+				text   bool
+			}{
+				{"accounts", "idx_username", "username", false},
+				{"accounts", "idx_email", "email", false},
+				{"Permission", "idx_permission_code", "code", false},
+				{"Role", "idx_role_code", "code", false},
+				{"Employee", "idx_employee_code", "code", true},
+			} {
+				if err := tenantUniqueIndex(tx, idx.table, idx.name, idx.column, idx.text); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	Register(Tenant, Migration{
+		Version: 4,
+		Name:    "audit_log",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&v4AuditLog{})
+		},
+	})
+}
+
+// addTenantColumn adds the indexed tenant column unless a previous run added it
+func addTenantColumn(tx *gorm.DB, table string, column string) error {
+	m := tx.Migrator()
+	if !m.HasColumn(table, column) {
+		err := tx.Exec("ALTER TABLE ? ADD ? varchar(64)", clause.Table{Name: table}, clause.Column{Name: column}).Error
+		if err != nil {
+			return err
+		}
+	}
+	// named as gorm names the index of the TenantID field of the models
+	name := tx.NamingStrategy.IndexName(table, column)
+	if m.HasIndex(table, name) {
+		return nil
+	}
+	return tx.Exec("CREATE INDEX ? ON ? (?)", clause.Column{Name: name}, clause.Table{Name: table}, clause.Column{Name: column}).Error
+}
+
+// tenantUniqueIndex recreates a unique index on column as a unique index on tenant_id and column.
+// The name is kept so AutoMigrate does not create the single column index again.
+func tenantUniqueIndex(tx *gorm.DB, table string, name string, column string, text bool) error {
+	m := tx.Migrator()
+	if m.HasIndex(table, name) {
+		if err := m.DropIndex(table, name); err != nil {
+			return err
+		}
+	}
+	var indexed interface{} = clause.Column{Name: column}
+	if tx.Dialector.Name() == "mysql" && text {
+		// text columns are indexed on a prefix in mysql
+		indexed = clause.Expr{SQL: "?(191)", Vars: []interface{}{clause.Column{Name: column}}}
+	}
+	return tx.Exec("CREATE UNIQUE INDEX ? ON ? (?, ?)",
+		clause.Column{Name: name},
+		clause.Table{Name: table},
+		clause.Column{Name: "tenant_id"},
+		indexed,
+	).Error
+}
+
+type v4AuditLog struct {
+	ID        uuid.UUID `gorm:"type:char(36);primaryKey"`
+	Entity    string    `gorm:"type:varchar(64);index:idx_audit_entity"`
+	EntityID  string    `gorm:"type:varchar(64);index:idx_audit_entity"`
+	Action    string    `gorm:"type:varchar(10);index"`
+	Actor     string    `gorm:"type:varchar(191);index"`
+	Tenant    string    `gorm:"type:varchar(64)"`
+	RequestID string    `gorm:"type:varchar(128);index"`
+	Changes   string    `gorm:"type:text"`
+	CreatedOn time.Time `gorm:"index"`
+	TenantID  string    `gorm:"type:varchar(64);index"`
+}
+
+func (a *v4AuditLog) TableName() string {
+	return "AuditLog"
+}
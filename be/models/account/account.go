@@ -1,8 +1,10 @@
 package account
 
 import (
+	"time"
 	"vngom/models/bases"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -11,12 +13,81 @@ type Account struct {
 	bases.BaseModel
 	Username string `gorm:"type:varchar(191);uniqueIndex:idx_username;"`
 	Email    string `gorm:"type:varchar(191);uniqueIndex:idx_email;"`
-	Password string `gorm:"type:varchar(191);"`
-	Salt     string `json:"-" gorm:"not null;"` // Lưu salt, không hiển thị trong JSON
+	Password string `json:"-" gorm:"type:varchar(191);"`
+	// Salt is only used by legacy bcrypt hashes, Argon2id hashes embed their salt
+	Salt string `json:"-" gorm:"not null;"` // Lưu salt, không hiển thị trong JSON
+
+	PasswordChangedOn *time.Time `json:"passwordChangedOn"`
+	FailedAttempts    int        `json:"failedAttempts" gorm:"not null;default:0"`
+	LockedUntil       *time.Time `json:"lockedUntil"`
+
+	EmailVerified   bool       `json:"emailVerified" gorm:"not null;default:false"`
+	EmailVerifiedOn *time.Time `json:"emailVerifiedOn"`
+
+	// TwoFactorSecret is the base32 TOTP secret, set at enrollment and active once TwoFactorEnabled
+	TwoFactorEnabled bool   `json:"twoFactorEnabled" gorm:"not null;default:false"`
+	TwoFactorSecret  string `json:"-" gorm:"type:varchar(64)"`
+	// TwoFactorLastStep is the last accepted TOTP time step, a code cannot be replayed
+	TwoFactorLastStep int64 `json:"-" gorm:"not null;default:0"`
+}
+
+// TokenRevision is carried by the tokens issued to the account, changing the
+// password moves it and revokes the tokens issued before.
+func (a *Account) TokenRevision() int64 {
+	if a.PasswordChangedOn == nil {
+		return 0
+	}
+	return a.PasswordChangedOn.UnixMilli()
+}
+
+// RecoveryCode is a single use code replacing a TOTP code when the device is lost
+type RecoveryCode struct {
+	bases.BaseModel
+	AccountID uuid.UUID `gorm:"type:char(36);index"`
+	CodeHash  string    `gorm:"type:varchar(191)"`
+	UsedOn    *time.Time
+}
+
+func (r *RecoveryCode) TableName() string {
+	return "RecoveryCode"
+}
+
+// Purposes of an AccountToken
+const (
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeVerifyEmail   = "verify_email"
+)
+
+// AccountToken is a single use token sent by email. Only the SHA-256 hash of
+// the token is stored so a database leak does not leak usable tokens.
+type AccountToken struct {
+	bases.BaseModel
+	AccountID uuid.UUID `gorm:"type:char(36);index"`
+	Purpose   string    `gorm:"type:varchar(20);index"`
+	TokenHash string    `gorm:"type:char(64);uniqueIndex:idx_account_token_hash"`
+	ExpiresOn time.Time `gorm:"index"`
+	UsedOn    *time.Time
+}
+
+func (t *AccountToken) TableName() string {
+	return "AccountToken"
+}
+
+// PasswordHistory keeps previous password hashes of an account so they are not reused
+type PasswordHistory struct {
+	bases.BaseModel
+	AccountID uuid.UUID `gorm:"type:char(36);index"`
+	Hash      string    `gorm:"type:varchar(191)"`
+}
+
+func (h *PasswordHistory) TableName() string {
+	return "PasswordHistory"
 }
 
 // TableName sets the desired table name
 
+// Deprecated: new passwords are hashed with Argon2id by the password package,
+// bcrypt with a separate salt is kept to verify hashes created before.
 func HashPasswordWithSalt(password, salt string) (string, error) {
 	// **LƯU Ý QUAN TRỌNG:** Trong ứng dụng thực tế, bạn NÊN sử dụng bcrypt thay vì cách này.
 	// bcrypt tự động tạo salt an toàn và tích hợp nó vào hash.
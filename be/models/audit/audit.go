@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Actions recorded by AuditLog
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// AuditLog is the immutable record of a change of an entity of a tenant.
+// Changes holds the changed fields as JSON, {"Field": {"old": ..., "new": ...}},
+// with the values of sensitive fields masked.
+type AuditLog struct {
+	ID        uuid.UUID `json:"id" gorm:"type:char(36);primaryKey"`
+	Entity    string    `json:"entity" gorm:"type:varchar(64);index:idx_audit_entity"`
+	EntityID  string    `json:"entityId" gorm:"type:varchar(64);index:idx_audit_entity"`
+	Action    string    `json:"action" gorm:"type:varchar(10);index"`
+	Actor     string    `json:"actor" gorm:"type:varchar(191);index"`
+	Tenant    string    `json:"tenant" gorm:"type:varchar(64)"`
+	RequestID string    `json:"requestId" gorm:"type:varchar(128);index"`
+	Changes   string    `json:"-" gorm:"type:text"`
+	CreatedOn time.Time `json:"createdOn" gorm:"index"`
+	// TenantID is set in shared databases only, see the tenantscope package
+	TenantID string `json:"-" gorm:"type:varchar(64);index"`
+}
+
+func (a *AuditLog) TableName() string {
+	return "AuditLog"
+}
@@ -13,4 +13,24 @@ type BaseModel struct {
 	ModifiedOn time.Time `gorm:"index"`
 	ModifiedBy string    `gorm:"index;type:varchar(50)"`
 	CreatedBy  string    `gorm:"index;varchar(50)"`
+	// TenantID is set in shared databases only, see the tenantscope package
+	TenantID string `json:"-" gorm:"type:varchar(64);index"`
+}
+
+// Auditable marks the models stamped by the Plugin on create and update. They
+// have the CreatedOn, ModifiedOn, CreatedBy and ModifiedBy fields of BaseModel,
+// which implements it for the models embedding it.
+type Auditable interface {
+	Auditable()
+}
+
+func (m *BaseModel) Auditable() {}
+
+// NewID returns a time ordered UUID (version 7), new keys land at the end of the indexes
+func NewID() uuid.UUID {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.New()
+	}
+	return id
 }
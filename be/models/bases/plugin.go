@@ -0,0 +1,153 @@
+package bases
+
+import (
+	"reflect"
+	"time"
+
+	"vngom/security"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	createdOn  = "CreatedOn"
+	createdBy  = "CreatedBy"
+	modifiedOn = "ModifiedOn"
+	modifiedBy = "ModifiedBy"
+)
+
+var (
+	auditableType = reflect.TypeOf((*Auditable)(nil)).Elem()
+	uuidType      = reflect.TypeOf(uuid.UUID{})
+)
+
+// Plugin fills the Auditable models: a new row gets a UUID key when it has
+// none, and its creation and modification are stamped in UTC with the caller
+// found in the statement context (security.WithClaims). An update stamps the
+// modification and never changes CreatedOn and CreatedBy. Values set by the
+// caller on creation are kept. UpdateColumn(s) is left alone: bookkeeping such
+// as lockouts must not move ModifiedOn, the version of optimistic locking.
+type Plugin struct{}
+
+func NewPlugin() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return "bases"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Create().Before("gorm:create").Register("bases:create", stampCreate); err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register("bases:update", stampUpdate)
+}
+
+func auditable(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil && reflect.PointerTo(db.Statement.Schema.ModelType).Implements(auditableType)
+}
+
+// now is millisecond precise, the precision of ModifiedOn in every supported database
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func actor(db *gorm.DB) string {
+	if claims := security.ClaimsFromContext(db.Statement.Context); claims != nil {
+		return claims.Username
+	}
+	return ""
+}
+
+func stampCreate(db *gorm.DB) {
+	if !auditable(db) {
+		return
+	}
+	s := db.Statement.Schema
+	ctx := db.Statement.Context
+	on, by := now(), actor(db)
+	set := func(row reflect.Value, name string, value interface{}) {
+		if err := s.LookUpField(name).Set(ctx, row, value); err != nil {
+			db.AddError(err)
+		}
+	}
+	stamp := func(row reflect.Value) {
+		if key := s.PrioritizedPrimaryField; key != nil && key.FieldType == uuidType {
+			if _, zero := key.ValueOf(ctx, row); zero {
+				if err := key.Set(ctx, row, NewID()); err != nil {
+					db.AddError(err)
+				}
+			}
+		}
+		created := timeOf(db, s, row, createdOn)
+		if created.IsZero() {
+			created = on
+		}
+		set(row, createdOn, created.UTC())
+		modified := timeOf(db, s, row, modifiedOn)
+		if modified.IsZero() {
+			modified = created
+		}
+		set(row, modifiedOn, modified.UTC())
+		creator, _ := s.LookUpField(createdBy).ValueOf(ctx, row)
+		if creator == "" {
+			creator = by
+			set(row, createdBy, by)
+		}
+		if modifier, _ := s.LookUpField(modifiedBy).ValueOf(ctx, row); modifier == "" {
+			set(row, modifiedBy, creator)
+		}
+	}
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			stamp(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		stamp(rv)
+	}
+}
+
+func timeOf(db *gorm.DB, s *schema.Schema, row reflect.Value, name string) time.Time {
+	value, _ := s.LookUpField(name).ValueOf(db.Statement.Context, row)
+	t, _ := value.(time.Time)
+	return t
+}
+
+func stampUpdate(db *gorm.DB) {
+	if !auditable(db) || db.Statement.SkipHooks {
+		return
+	}
+	stmt := db.Statement
+	stmt.Omits = append(stmt.Omits, createdOn, createdBy)
+	// Repository.Update sets the version it checked, it is kept
+	if !assigned(stmt, modifiedOn) {
+		stmt.SetColumn(modifiedOn, now(), true)
+	}
+	if by := actor(db); by != "" {
+		stmt.SetColumn(modifiedBy, by, true)
+	}
+	if len(stmt.Selects) > 0 {
+		// an update restricted to some columns still stamps the modification
+		stmt.Selects = append(stmt.Selects, modifiedOn, modifiedBy)
+	}
+}
+
+// assigned reports whether the changes of an update by map hold a value for the field
+func assigned(stmt *gorm.Statement, name string) bool {
+	changes, ok := stmt.Dest.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	field := stmt.Schema.LookUpField(name)
+	for _, key := range []string{field.Name, field.DBName} {
+		if _, found := changes[key]; found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package bases_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"vngom/models/account"
+	"vngom/models/bases"
+	"vngom/models/department"
+	"vngom/security"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(bases.NewPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&account.Account{}, &department.Department{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func as(username string) context.Context {
+	return security.WithClaims(context.Background(), &security.Claims{Username: username})
+}
+
+func TestPluginStampsCreation(t *testing.T) {
+	db := newTestDb(t)
+	acc := account.Account{Username: "bob"}
+	assert.NoError(t, db.WithContext(as("alice")).Create(&acc).Error)
+	assert.Equal(t, 7, int(acc.ID.Version()))
+	assert.Equal(t, time.UTC, acc.CreatedOn.Location())
+	assert.WithinDuration(t, time.Now(), acc.CreatedOn, time.Second)
+	assert.Equal(t, acc.CreatedOn, acc.ModifiedOn)
+	assert.Equal(t, "alice", acc.CreatedBy)
+	assert.Equal(t, "alice", acc.ModifiedBy)
+
+	// the values set by the caller are kept
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("ICT", 7*3600))
+	imported := []account.Account{{Username: "carol", Email: "carol@example.com"}, {Username: "dave", Email: "dave@example.com"}}
+	imported[0].CreatedOn = created
+	imported[0].CreatedBy = "import"
+	assert.NoError(t, db.Create(&imported).Error)
+	assert.True(t, created.Equal(imported[0].CreatedOn))
+	assert.Equal(t, time.UTC, imported[0].CreatedOn.Location())
+	assert.Equal(t, "import", imported[0].ModifiedBy)
+	assert.NotEqual(t, imported[0].ID, imported[1].ID)
+	assert.Empty(t, imported[1].CreatedBy)
+
+	// models with another key implement Auditable
+	d := department.Department{Code: "HQ"}
+	assert.NoError(t, db.WithContext(as("alice")).Create(&d).Error)
+	assert.NotZero(t, d.ID)
+	assert.False(t, d.CreatedOn.IsZero())
+	assert.Equal(t, "alice", d.CreatedBy)
+}
+
+func TestPluginStampsModification(t *testing.T) {
+	db := newTestDb(t)
+	acc := account.Account{Username: "bob"}
+	assert.NoError(t, db.WithContext(as("alice")).Create(&acc).Error)
+	time.Sleep(2 * time.Millisecond)
+
+	err := db.WithContext(as("bob")).Model(&account.Account{}).Where("username = ?", "bob").Updates(map[string]interface{}{
+		"Email":     "bob@example.com",
+		"CreatedBy": "mallory",
+		"CreatedOn": time.Now(),
+	}).Error
+	assert.NoError(t, err)
+	var stored account.Account
+	assert.NoError(t, db.Take(&stored, "id = ?", acc.ID).Error)
+	assert.Equal(t, "bob@example.com", stored.Email)
+	assert.Equal(t, "alice", stored.CreatedBy)
+	assert.True(t, acc.CreatedOn.Equal(stored.CreatedOn))
+	assert.True(t, stored.ModifiedOn.After(acc.ModifiedOn))
+	assert.Equal(t, "bob", stored.ModifiedBy)
+
+	// saving a whole record does not rewrite its creation either
+	stored.CreatedBy = "mallory"
+	stored.Email = "robert@example.com"
+	assert.NoError(t, db.WithContext(as("carol")).Save(&stored).Error)
+	assert.NoError(t, db.Take(&stored, "id = ?", acc.ID).Error)
+	assert.Equal(t, "alice", stored.CreatedBy)
+	assert.Equal(t, "carol", stored.ModifiedBy)
+
+	// a version set by the update is kept
+	version := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, db.WithContext(as("dave")).Model(&stored).Updates(map[string]interface{}{"modified_on": version, "email": "bob@example.com"}).Error)
+	assert.NoError(t, db.Take(&stored, "id = ?", acc.ID).Error)
+	assert.True(t, version.Equal(stored.ModifiedOn))
+	assert.Equal(t, "dave", stored.ModifiedBy)
+}
+
+func TestPluginSkipsUpdateColumns(t *testing.T) {
+	db := newTestDb(t)
+	acc := account.Account{Username: "bob"}
+	assert.NoError(t, db.WithContext(as("alice")).Create(&acc).Error)
+	time.Sleep(2 * time.Millisecond)
+
+	// bookkeeping does not move the version
+	assert.NoError(t, db.WithContext(as("bob")).Model(&acc).UpdateColumn("failed_attempts", 2).Error)
+	assert.NoError(t, db.WithContext(as("bob")).Model(&acc).UpdateColumns(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error)
+	var stored account.Account
+	assert.NoError(t, db.Take(&stored, "id = ?", acc.ID).Error)
+	assert.True(t, acc.ModifiedOn.Equal(stored.ModifiedOn))
+	assert.Equal(t, "alice", stored.ModifiedBy)
+}
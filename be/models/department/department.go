@@ -8,9 +8,9 @@ import (
 type Department struct {
 	Employees []employee.Employee `json:"employees" gorm:"foreignKey:DepartmentID;references:ID"`
 	// ID  is auto incremented int
-	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement";column:ID`
+	ID         uint      `json:"id" gorm:"primaryKey;autoIncrement"`
 	Code       string    `json:"code" gorm:"type:varchar(50);index;column:Code"`
-	Name       string    `json:"firstName" gorm:"type:varchar(191);index";column:Name`
+	Name       string    `json:"firstName" gorm:"type:varchar(191);index"`
 	CreatedOn  time.Time `gorm:"index;column:CreatedOn"`
 	ModifiedOn time.Time `gorm:"index;column:ModifiedOn"`
 	ModifiedBy string    `gorm:"index,length:191;column:ModifiedBy"`
@@ -22,10 +22,15 @@ type Department struct {
 	// why? when get all children of department A, we just query levelCode like 1.2.*,
 
 	LevelCode string `json:"levelCode" gorm:"type:varchar(191);index;column:LevelCode"`
+	// TenantID is set in shared databases only, see the tenantscope package
+	TenantID string `json:"-" gorm:"type:varchar(64);index;column:TenantID"`
 	//list of employees in this department
 
 }
 
+// Auditable lets the bases plugin stamp the Department, keyed by an auto incremented ID unlike BaseModel
+func (d *Department) Auditable() {}
+
 func (d *Department) TableName() string {
 	return "Department"
 }
@@ -12,15 +12,15 @@ import (
 type Employee struct {
 	bases.BaseModel
 	User      *account.Account `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
-	Code      string           `gorm:"uniqueIndex:idx_employee_code,length:191";column:Code`
-	FirstName string           `gorm:"index:idx_employee_firstname,length:191";column:FirstName`
-	LastName  string           `gorm:"index:idx_employee_lastname,length:191";column:LastName`
-	Gender    string           `gorm:"index:idx_employee_gender,length:191";column:Gender`
+	Code      string           `gorm:"uniqueIndex:idx_employee_code,length:191"`
+	FirstName string           `gorm:"index:idx_employee_firstname,length:191"`
+	LastName  string           `gorm:"index:idx_employee_lastname,length:191"`
+	Gender    string           `gorm:"index:idx_employee_gender,length:191"`
 
 	JoinDate     time.Time
-	UserID       *uuid.UUID             `gorm:"unique";column:UserID` // Khóa ngoại duy nhất, có thể nil
+	UserID       *uuid.UUID             `gorm:"unique"` // Khóa ngoại duy nhất, có thể nil
 	Personal     *personal.PersonalInfo `gorm:"foreignKey:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
-	DepartmentID *uint                  `gorm:"index";column:DepartmentID` // Khóa ngoại duy nhất, có thể nil
+	DepartmentID *uint                  `gorm:"index"` // Khóa ngoại duy nhất, có thể nil
 }
 
 func (e *Employee) TableName() string {
@@ -2,12 +2,50 @@ package models
 
 import (
 	"vngom/models/account"
+	"vngom/models/audit"
 	"vngom/models/department"
+	"vngom/models/employee"
 	"vngom/models/personal"
+	"vngom/models/rbac"
 	"vngom/models/tenants"
 )
 
 type Account account.Account
+type PasswordHistory account.PasswordHistory
+type AccountToken account.AccountToken
+type RecoveryCode account.RecoveryCode
 type PersonalInfo personal.PersonalInfo
 type Tenants tenants.TenantInfo
 type Department department.Department
+type Role rbac.Role
+type Permission rbac.Permission
+type AccountRole rbac.AccountRole
+type AuditLog audit.AuditLog
+
+// TenantModels lists the models stored in the database of each tenant
+func TenantModels() []interface{} {
+	return []interface{}{
+		&account.Account{},
+		&account.PasswordHistory{},
+		&account.AccountToken{},
+		&account.RecoveryCode{},
+		&personal.PersonalInfo{},
+		&employee.Employee{},
+		&department.Department{},
+		&rbac.Permission{},
+		&rbac.Role{},
+		&rbac.RolePermission{},
+		&rbac.AccountRole{},
+		&audit.AuditLog{},
+	}
+}
+
+// CatalogModels lists the models stored in the catalog database
+func CatalogModels() []interface{} {
+	return []interface{}{
+		&tenants.TenantInfo{},
+		&tenants.MigrationFailure{},
+		&tenants.TenantEvent{},
+		&tenants.TenantSetting{},
+	}
+}
@@ -11,15 +11,15 @@ import (
 // PersonalInfo represents the personal information of a user.
 type PersonalInfo struct {
 	bases.BaseModel
-	FirstName string `json:"first_name" gorm:"type:varchar(100);index";column:FirstName`
-	LastName  string `json:"last_name" gorm:"type:varchar(100);index";column:LastName`
+	FirstName string `json:"first_name" gorm:"type:varchar(100);index"`
+	LastName  string `json:"last_name" gorm:"type:varchar(100);index"`
 
-	DateOfBirth *time.Time `json:"date_of_birth;";column:DateOfBirth;index`
-	Gender      string     `json:"gender" gorm:"type:varchar(10);index";column:Gender`
-	Address     string     `json:"address" gorm:"type:varchar(255)";column:Address"`
-	PhoneNumber string     `json:"phone_number" gorm:"type:varchar(20);index";column:PhoneNumber`
-	Nationality string     `json:"nationality" gorm:"type:varchar(50)";column:Nationality`
-	BirthPlace  string     `json:"birth_place" gorm:"type:varchar(100)";column:BirthPlace`
+	DateOfBirth *time.Time `json:"date_of_birth;"`
+	Gender      string     `json:"gender" gorm:"type:varchar(10);index"`
+	Address     string     `json:"address" gorm:"type:varchar(255)"`
+	PhoneNumber string     `json:"phone_number" gorm:"type:varchar(20);index"`
+	Nationality string     `json:"nationality" gorm:"type:varchar(50)"`
+	BirthPlace  string     `json:"birth_place" gorm:"type:varchar(100)"`
 	// Add other relevant personal information fields here
 }
 
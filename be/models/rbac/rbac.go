@@ -0,0 +1,70 @@
+// rbac declares the authorization model of a tenant.
+// Roles, permissions and the role assignments of accounts are stored in the tenant database.
+package rbac
+
+import (
+	"vngom/models/bases"
+
+	"github.com/google/uuid"
+)
+
+// Permission is a named right such as "employee.read" or "department.write"
+type Permission struct {
+	bases.BaseModel
+	Code        string `json:"code" gorm:"type:varchar(100);uniqueIndex:idx_permission_code"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+}
+
+func (p *Permission) TableName() string {
+	return "Permission"
+}
+
+// Data scopes limit the rows of employees and departments a role can reach
+const (
+	DataScopeAll = "all"
+	// DataScopeDepartment is the department of the account's employee only
+	DataScopeDepartment = "department"
+	// DataScopeDepartmentTree is the department of the account's employee and all its descendants
+	DataScopeDepartmentTree = "department_tree"
+)
+
+// Role groups permissions, IsSystem roles are seeded and cannot be deleted
+type Role struct {
+	bases.BaseModel
+	Code        string `json:"code" gorm:"type:varchar(100);uniqueIndex:idx_role_code"`
+	Name        string `json:"name" gorm:"type:varchar(191)"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	IsSystem    bool   `json:"isSystem"`
+	DataScope   string `json:"dataScope" gorm:"type:varchar(20);default:all"`
+	// RequireTwoFactor makes two-factor authentication mandatory for accounts holding the role
+	RequireTwoFactor bool `json:"requireTwoFactor" gorm:"not null;default:false"`
+	// Permissions holds the permission codes of the role, it is loaded from RolePermission
+	Permissions []string `json:"permissions" gorm:"-"`
+}
+
+func (r *Role) TableName() string {
+	return "Role"
+}
+
+// RolePermission is the join table of Role and Permission
+type RolePermission struct {
+	RoleID       uuid.UUID `gorm:"type:char(36);primaryKey"`
+	PermissionID uuid.UUID `gorm:"type:char(36);primaryKey"`
+	TenantID     string    `json:"-" gorm:"type:varchar(64);index"`
+}
+
+func (rp *RolePermission) TableName() string {
+	return "RolePermission"
+}
+
+// AccountRole assigns a role to an account of the tenant
+type AccountRole struct {
+	AccountID uuid.UUID `json:"accountID" gorm:"type:char(36);primaryKey"`
+	RoleID    uuid.UUID `json:"roleID" gorm:"type:char(36);primaryKey;index"`
+	Role      *Role     `json:"role,omitempty" gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+	TenantID  string    `json:"-" gorm:"type:varchar(64);index"`
+}
+
+func (ar *AccountRole) TableName() string {
+	return "AccountRole"
+}
@@ -6,17 +6,93 @@ import (
 	"github.com/google/uuid"
 )
 
+// Status of a tenant, a suspended tenant is Inactive
+const (
+	StatusInactive     = 0
+	StatusActive       = 1
+	StatusProvisioning = 2
+	StatusFailed       = 3
+)
+
+// Isolation of the data of a tenant
+const (
+	// IsolationDatabase gives the tenant a database of its own
+	IsolationDatabase = "database"
+	// IsolationShared keeps the tenant in the shared database, its rows are told apart by TenantID
+	IsolationShared = "shared"
+)
+
 type TenantInfo struct {
 	ID          uuid.UUID  `gorm:"type:char(36);primaryKey"`
-	Name        string     `gorm:"type:char(191);uniqueIndex:idx_name"`        // Tên của tenant
-	Description string     `gorm:"type:text"`                                  // Mô tả của tenant
-	Status      int        `gorm:"default:1;column:Status"`                    // Trạng thái của tenant: 1: Active, 0: Inactive
-	DeletedAt   *time.Time `gorm:"index;column:DeletedAt"`                     // Thời gian xóa mềm (soft delete)
-	DeletedBy   *string    `gorm:"index;column:DeletedBy"`                     // Người xóa mềm (soft delete)
-	DbTenant    string     `gorm:"uniqueIndex:idx_db_tenants_name,length:191"` // Tên của database của tenant
+	Name        string     `gorm:"type:char(191);uniqueIndex:idx_name"`  // Tên của tenant
+	Description string     `gorm:"type:text"`                            // Mô tả của tenant
+	Status      int        `gorm:"default:1;column:Status"`              // Trạng thái của tenant: 1: Active, 0: Inactive
+	DeletedAt   *time.Time `gorm:"index;column:DeletedAt"`               // Thời gian xóa mềm (soft delete)
+	DeletedBy   *string    `gorm:"index;column:DeletedBy"`               // Người xóa mềm (soft delete)
+	DbTenant    string     `gorm:"index:idx_db_tenants_name,length:191"` // Tên của database của tenant
+	// Isolation is IsolationDatabase or IsolationShared, shared tenants have the same DbTenant
+	Isolation string `gorm:"type:varchar(20);not null;default:database"`
+	// LastError is the reason of the last failed provisioning
+	LastError string `gorm:"type:text"`
 
 	CreatedOn  time.Time  `gorm:"index"`
 	ModifiedOn *time.Time `gorm:"index"`
 	ModifiedBy string     `gorm:"index"`
 	CreatedBy  string     `gorm:"index"`
 }
+
+// MigrationFailure records a migration that failed on the database of a tenant
+type MigrationFailure struct {
+	ID       uuid.UUID `gorm:"type:char(36);primaryKey"`
+	TenantID uuid.UUID `gorm:"type:char(36);index"`
+	Tenant   string    `gorm:"type:varchar(191);index"`
+	Version  int64
+	Name     string    `gorm:"type:varchar(191)"`
+	Error    string    `gorm:"type:text"`
+	FailedOn time.Time `gorm:"index"`
+}
+
+func (m *MigrationFailure) TableName() string {
+	return "MigrationFailure"
+}
+
+// Actions recorded by TenantEvent
+const (
+	ActionProvision  = "provision"
+	ActionSuspend    = "suspend"
+	ActionReactivate = "reactivate"
+	ActionDelete     = "delete"
+	ActionPurge      = "purge"
+)
+
+// TenantEvent is the audit record of a change of status of a tenant.
+// It outlives the tenant, a purged tenant is known by TenantID and Tenant.
+type TenantEvent struct {
+	ID         uuid.UUID `gorm:"type:char(36);primaryKey"`
+	TenantID   uuid.UUID `gorm:"type:char(36);index"`
+	Tenant     string    `gorm:"type:varchar(191);index"`
+	Action     string    `gorm:"type:varchar(50)"`
+	FromStatus int
+	ToStatus   int
+	Reason     string    `gorm:"type:text"`
+	Actor      string    `gorm:"type:varchar(191)"`
+	CreatedOn  time.Time `gorm:"index"`
+}
+
+func (e *TenantEvent) TableName() string {
+	return "TenantEvent"
+}
+
+// TenantSetting is a version of the configuration overrides of a tenant, the
+// highest Version is the current one. Overrides holds config.TenantOverrides as JSON.
+type TenantSetting struct {
+	Tenant     string    `gorm:"type:varchar(191);primaryKey"`
+	Version    int       `gorm:"primaryKey;autoIncrement:false"`
+	Overrides  string    `gorm:"type:text"`
+	ModifiedOn time.Time `gorm:"index"`
+	ModifiedBy string    `gorm:"type:varchar(191)"`
+}
+
+func (s *TenantSetting) TableName() string {
+	return "TenantSetting"
+}
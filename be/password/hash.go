@@ -0,0 +1,79 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"vngom/models/account"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters of new hashes, hashes made with other parameters are
+// upgraded on the next successful login.
+const (
+	argonTime    uint32 = 3
+	argonMemory  uint32 = 64 * 1024
+	argonThreads uint8  = 2
+	argonKeyLen  uint32 = 32
+	argonSaltLen        = 16
+)
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// Hash returns the Argon2id hash of a password in PHC string format
+func Hash(password string) (string, error) {
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks a password against a stored hash. Legacy bcrypt hashes are
+// checked with their separate salt, needsRehash tells the caller to store a
+// fresh Argon2id hash while the plain password is at hand.
+func Verify(password string, hash string, legacySalt string) (ok bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		if account.ComparePasswordWithSalt(password, hash, legacySalt) != nil {
+			return false, false, nil
+		}
+		return true, true, nil
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, errMalformedHash
+	}
+	actual := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, false, nil
+	}
+	needsRehash = version != argon2.Version || memory != argonMemory || time != argonTime ||
+		threads != argonThreads || uint32(len(key)) != argonKeyLen
+	return true, needsRehash, nil
+}
@@ -0,0 +1,191 @@
+package password_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/models/account"
+	"vngom/password"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var policy = config.PasswordConfig{
+	MinLength:         10,
+	RequireUpper:      true,
+	RequireLower:      true,
+	RequireDigit:      true,
+	History:           3,
+	MaxFailedAttempts: 3,
+	LockoutDuration:   time.Minute,
+}
+
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would open its own in-memory database
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&account.Account{}, &account.PasswordHistory{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := password.Hash("Correct-Horse-9")
+	assert.NoError(t, err)
+	ok, needsRehash, err := password.Verify("Correct-Horse-9", hash, "")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+
+	ok, _, err = password.Verify("correct-horse-9", hash, "")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = password.Verify("x", "$argon2id$broken", "")
+	assert.Error(t, err)
+}
+
+func TestCheckPolicy(t *testing.T) {
+	assert.NoError(t, password.CheckPolicy(policy, "Sunny-Day-2024", "alice"))
+
+	var policyErr *password.PolicyError
+	err := password.CheckPolicy(policy, "short", "alice")
+	assert.True(t, errors.As(err, &policyErr))
+	assert.Len(t, policyErr.Violations, 3)
+
+	err = password.CheckPolicy(policy, "Alice-Wonder-1", "alice")
+	assert.True(t, errors.As(err, &policyErr))
+	assert.Len(t, policyErr.Violations, 1)
+}
+
+func TestLegacyHashIsUpgraded(t *testing.T) {
+	db := newTestDb(t)
+	legacy, err := account.HashPasswordWithSalt("Legacy-Pass-1", "pepper")
+	assert.NoError(t, err)
+	acc := account.Account{Username: "bob", Email: "bob@example.com", Password: legacy, Salt: "pepper"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC()
+	assert.NoError(t, db.Create(&acc).Error)
+
+	svc := password.NewService(policy)
+	_, err = svc.Authenticate(db, "bob", "Legacy-Pass-1")
+	assert.NoError(t, err)
+
+	var stored account.Account
+	assert.NoError(t, db.First(&stored, "id = ?", acc.ID).Error)
+	assert.Contains(t, stored.Password, "$argon2id$")
+	assert.Empty(t, stored.Salt)
+
+	_, err = svc.Authenticate(db, "bob", "Legacy-Pass-1")
+	assert.NoError(t, err)
+}
+
+func TestLockout(t *testing.T) {
+	db := newTestDb(t)
+	svc := password.NewService(policy)
+	acc := account.Account{Username: "carol", Email: "carol@example.com"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC()
+	assert.NoError(t, db.Create(&acc).Error)
+	assert.NoError(t, svc.SetPassword(db, &acc, "First-Pass-01", "admin"))
+
+	_, err := svc.Authenticate(db, "carol", "wrong")
+	assert.ErrorIs(t, err, password.ErrInvalidCredentials)
+	_, err = svc.Authenticate(db, "carol", "wrong")
+	assert.ErrorIs(t, err, password.ErrInvalidCredentials)
+	var locked *password.LockedError
+	_, err = svc.Authenticate(db, "carol", "wrong")
+	assert.True(t, errors.As(err, &locked))
+	// the right password is refused while locked
+	_, err = svc.Authenticate(db, "carol", "First-Pass-01")
+	assert.True(t, errors.As(err, &locked))
+
+	assert.NoError(t, svc.Unlock(db, acc.ID))
+	_, err = svc.Authenticate(db, "carol", "First-Pass-01")
+	assert.NoError(t, err)
+}
+
+func TestConcurrentFailuresLock(t *testing.T) {
+	db := newTestDb(t)
+	svc := password.NewService(policy)
+	acc := account.Account{Username: "erin", Email: "erin@example.com"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC()
+	assert.NoError(t, db.Create(&acc).Error)
+	assert.NoError(t, svc.SetPassword(db, &acc, "First-Pass-01", "admin"))
+
+	// every attempt reads the account before any of them counts its failure
+	var wg sync.WaitGroup
+	errs := make([]error, policy.MaxFailedAttempts)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = svc.Authenticate(db, "erin", "wrong")
+		}(i)
+	}
+	wg.Wait()
+
+	locked := 0
+	for _, err := range errs {
+		var lockedErr *password.LockedError
+		if errors.As(err, &lockedErr) {
+			locked++
+		}
+	}
+	assert.Equal(t, 1, locked)
+	var stored account.Account
+	assert.NoError(t, db.First(&stored, "id = ?", acc.ID).Error)
+	assert.NotNil(t, stored.LockedUntil)
+	assert.Zero(t, stored.FailedAttempts)
+}
+
+func TestHistoryAndExpiry(t *testing.T) {
+	db := newTestDb(t)
+	expiring := policy
+	expiring.MaxAge = time.Hour
+	svc := password.NewService(expiring)
+	acc := account.Account{Username: "dave", Email: "dave@example.com"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC().Add(-2 * time.Hour)
+	assert.NoError(t, db.Create(&acc).Error)
+
+	// a password whose change was never tracked does not expire
+	legacy, err := password.Hash("Legacy-Pass-1")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Model(&account.Account{}).Where("id = ?", acc.ID).Update("password", legacy).Error)
+	acc.Password = legacy
+	_, err = svc.Authenticate(db, "dave", "Legacy-Pass-1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, svc.SetPassword(db, &acc, "First-Pass-01", "admin"))
+	assert.NoError(t, svc.ChangePassword(db, "dave", "First-Pass-01", "Second-Pass-02"))
+	assert.NoError(t, svc.ChangePassword(db, "dave", "Second-Pass-02", "Third-Pass-03"))
+	assert.ErrorIs(t, svc.ChangePassword(db, "dave", "Third-Pass-03", "First-Pass-01"), password.ErrPasswordReused)
+	assert.NoError(t, svc.ChangePassword(db, "dave", "Third-Pass-03", "Fourth-Pass-04"))
+	// the first password left the history window of 3
+	assert.NoError(t, svc.ChangePassword(db, "dave", "Fourth-Pass-04", "First-Pass-01"))
+
+	old := time.Now().UTC().Add(-2 * time.Hour)
+	assert.NoError(t, db.Model(&account.Account{}).Where("id = ?", acc.ID).Update("password_changed_on", old).Error)
+	var expired *password.ExpiredError
+	_, err = svc.Authenticate(db, "dave", "First-Pass-01")
+	assert.True(t, errors.As(err, &expired))
+	assert.NoError(t, svc.ChangePassword(db, "dave", "First-Pass-01", "Fifth-Pass-05"))
+	_, err = svc.Authenticate(db, "dave", "Fifth-Pass-05")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,64 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"vngom/config"
+)
+
+const defaultMinLength = 8
+
+// PolicyError lists every rule a candidate password breaks
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Violations, ", ")
+}
+
+// CheckPolicy validates a candidate password against the configured policy
+func CheckPolicy(policy config.PasswordConfig, password string, username string) error {
+	minLength := policy.MinLength
+	if minLength <= 0 {
+		minLength = defaultMinLength
+	}
+	var violations []string
+	if len([]rune(password)) < minLength {
+		violations = append(violations, fmt.Sprintf("at least %d characters", minLength))
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if policy.RequireUpper && !upper {
+		violations = append(violations, "an upper case letter")
+	}
+	if policy.RequireLower && !lower {
+		violations = append(violations, "a lower case letter")
+	}
+	if policy.RequireDigit && !digit {
+		violations = append(violations, "a digit")
+	}
+	if policy.RequireSymbol && !symbol {
+		violations = append(violations, "a symbol")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		violations = append(violations, "not containing the username")
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+// password hashes, verifies and changes the passwords of accounts.
+// It enforces the configured policy, password history, expiry and the
+// temporary lockout of accounts after too many failed logins.
+package password
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"vngom/config"
+	"vngom/models/account"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrPasswordReused     = errors.New("password was used recently")
+	ErrAccountNotFound    = errors.New("account not found")
+)
+
+// LockedError is returned while an account is locked out
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "account is locked until " + e.Until.Format(time.RFC3339)
+}
+
+// ExpiredError is returned by Authenticate when the password is correct but
+// older than the policy allows, the password must be changed before logging in.
+type ExpiredError struct {
+	Account *account.Account
+}
+
+func (e *ExpiredError) Error() string {
+	return "password has expired"
+}
+
+type Service struct {
+	policy config.PasswordConfig
+}
+
+func NewService(policy config.PasswordConfig) *Service {
+	return &Service{policy: policy}
+}
+
+func (s *Service) Policy() config.PasswordConfig {
+	return s.policy
+}
+
+// Authenticate verifies the credentials of an account, counting failures
+// towards a lockout and upgrading legacy hashes on success.
+func (s *Service) Authenticate(db *gorm.DB, username string, password string) (*account.Account, error) {
+	var acc account.Account
+	err := db.Where("username = ?", username).First(&acc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// hash anyway so unknown usernames cannot be told apart by timing
+		_, _ = Hash(password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if acc.LockedUntil != nil && acc.LockedUntil.After(now) {
+		return nil, &LockedError{Until: *acc.LockedUntil}
+	}
+	ok, needsRehash, err := Verify(password, acc.Password, acc.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.recordFailure(db, &acc, now)
+	}
+	updates := map[string]interface{}{}
+	if acc.FailedAttempts > 0 || acc.LockedUntil != nil {
+		updates["failed_attempts"] = 0
+		updates["locked_until"] = nil
+		acc.FailedAttempts = 0
+		acc.LockedUntil = nil
+	}
+	if needsRehash {
+		hash, err := Hash(password)
+		if err != nil {
+			return nil, err
+		}
+		updates["password"] = hash
+		updates["salt"] = ""
+		acc.Password = hash
+		acc.Salt = ""
+	}
+	if len(updates) > 0 {
+		if err := db.Model(&acc).UpdateColumns(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	if s.isExpired(&acc, now) {
+		return &acc, &ExpiredError{Account: &acc}
+	}
+	return &acc, nil
+}
+
+// RecordFailure counts a failed authentication step of an account, such as a
+// wrong second factor, towards the lockout. It returns ErrInvalidCredentials or
+// a *LockedError when the account gets locked.
+func (s *Service) RecordFailure(db *gorm.DB, acc *account.Account) error {
+	return s.recordFailure(db, acc, time.Now().UTC())
+}
+
+// CheckLocked returns a *LockedError while the account is locked out
+func (s *Service) CheckLocked(acc *account.Account) error {
+	if acc.LockedUntil != nil && acc.LockedUntil.After(time.Now().UTC()) {
+		return &LockedError{Until: *acc.LockedUntil}
+	}
+	return nil
+}
+
+// recordFailure counts the failure in the database so that concurrent failures
+// all count, and locks the account on the persisted count.
+func (s *Service) recordFailure(db *gorm.DB, acc *account.Account, now time.Time) error {
+	var lockedUntil *time.Time
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&account.Account{}).
+			Where("id = ? AND (locked_until IS NULL OR locked_until <= ?)", acc.ID, now).
+			UpdateColumn("failed_attempts", gorm.Expr("failed_attempts + 1")).Error
+		if err != nil {
+			return err
+		}
+		var stored account.Account
+		if err := tx.Select("failed_attempts", "locked_until").Where("id = ?", acc.ID).Take(&stored).Error; err != nil {
+			return err
+		}
+		acc.FailedAttempts = stored.FailedAttempts
+		if stored.LockedUntil != nil && stored.LockedUntil.After(now) {
+			// a concurrent failure locked the account
+			lockedUntil = stored.LockedUntil
+			return nil
+		}
+		if s.policy.MaxFailedAttempts <= 0 || stored.FailedAttempts < s.policy.MaxFailedAttempts {
+			return nil
+		}
+		until := now.Add(s.lockoutDuration())
+		lockedUntil = &until
+		acc.FailedAttempts = 0
+		return tx.Model(&account.Account{}).Where("id = ?", acc.ID).
+			UpdateColumns(map[string]interface{}{"failed_attempts": 0, "locked_until": until}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil {
+		acc.LockedUntil = lockedUntil
+		return &LockedError{Until: *lockedUntil}
+	}
+	return ErrInvalidCredentials
+}
+
+func (s *Service) lockoutDuration() time.Duration {
+	if s.policy.LockoutDuration > 0 {
+		return s.policy.LockoutDuration
+	}
+	return 15 * time.Minute
+}
+
+func (s *Service) isExpired(acc *account.Account, now time.Time) bool {
+	// passwords set before their changes were tracked have no age yet
+	if s.policy.MaxAge <= 0 || acc.PasswordChangedOn == nil {
+		return false
+	}
+	return now.Sub(*acc.PasswordChangedOn) > s.policy.MaxAge
+}
+
+// ChangePassword replaces the password of an account after checking the current one
+func (s *Service) ChangePassword(db *gorm.DB, username string, current string, newPassword string) error {
+	acc, err := s.Authenticate(db, username, current)
+	var expired *ExpiredError
+	if errors.As(err, &expired) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.SetPassword(db, acc, newPassword, acc.Username)
+}
+
+// SetPassword checks a new password against the policy and history then stores it.
+// It also lifts a lockout, which makes it suitable for administrative resets.
+func (s *Service) SetPassword(db *gorm.DB, acc *account.Account, newPassword string, changedBy string) error {
+	if err := CheckPolicy(s.policy, newPassword, acc.Username); err != nil {
+		return err
+	}
+	if err := s.checkHistory(db, acc, newPassword); err != nil {
+		return err
+	}
+	hash, err := Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if s.policy.History > 0 && acc.Password != "" && !isLegacy(acc.Password) {
+			entry := account.PasswordHistory{AccountID: acc.ID, Hash: acc.Password}
+			entry.ID = uuid.New()
+			entry.CreatedOn = now
+			entry.CreatedBy = changedBy
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+			if err := s.trimHistory(tx, acc.ID); err != nil {
+				return err
+			}
+		}
+		// millisecond precision survives every database, TokenRevision is compared exactly
+		changedOn := now.Truncate(time.Millisecond)
+		acc.Password = hash
+		acc.Salt = ""
+		acc.PasswordChangedOn = &changedOn
+		acc.FailedAttempts = 0
+		acc.LockedUntil = nil
+		acc.ModifiedOn = now
+		acc.ModifiedBy = changedBy
+		return tx.Model(acc).Select("password", "salt", "password_changed_on", "failed_attempts", "locked_until", "modified_on", "modified_by").Updates(acc).Error
+	})
+}
+
+// Unlock lifts the lockout of an account
+func (s *Service) Unlock(db *gorm.DB, accountID uuid.UUID) error {
+	return db.Model(&account.Account{}).Where("id = ?", accountID).
+		UpdateColumns(map[string]interface{}{"failed_attempts": 0, "locked_until": nil}).Error
+}
+
+func (s *Service) checkHistory(db *gorm.DB, acc *account.Account, newPassword string) error {
+	if s.policy.History <= 0 {
+		return nil
+	}
+	if ok, _, _ := Verify(newPassword, acc.Password, acc.Salt); ok && acc.Password != "" {
+		return ErrPasswordReused
+	}
+	var history []account.PasswordHistory
+	err := db.Where("account_id = ?", acc.ID).Order("created_on desc").Limit(s.policy.History - 1).Find(&history).Error
+	if err != nil {
+		return err
+	}
+	for _, h := range history {
+		if ok, _, _ := Verify(newPassword, h.Hash, ""); ok {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+// trimHistory keeps the entries needed to enforce the policy, the current
+// password counts as one of them.
+func (s *Service) trimHistory(tx *gorm.DB, accountID uuid.UUID) error {
+	keep := tx.Model(&account.PasswordHistory{}).Select("id").
+		Where("account_id = ?", accountID).Order("created_on desc").Limit(s.policy.History - 1)
+	var ids []uuid.UUID
+	if err := keep.Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	query := tx.Where("account_id = ?", accountID)
+	if len(ids) > 0 {
+		query = query.Where("id NOT IN ?", ids)
+	}
+	return query.Delete(&account.PasswordHistory{}).Error
+}
+
+// isLegacy reports bcrypt hashes, they need the account salt to be verified
+// and are not kept in the history.
+func isLegacy(hash string) bool {
+	return !strings.HasPrefix(hash, "$argon2id$")
+}
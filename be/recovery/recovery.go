@@ -0,0 +1,222 @@
+// recovery implements the email based flows of accounts: forgotten password,
+// invitation of new accounts to set their password and email verification.
+// Tokens are random, single use, expiring and stored hashed.
+package recovery
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"vngom/config"
+	"vngom/mailer"
+	"vngom/models/account"
+	"vngom/password"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultResetTTL  = time.Hour
+	defaultVerifyTTL = 48 * time.Hour
+	defaultInviteTTL = 7 * 24 * time.Hour
+)
+
+var ErrInvalidToken = errors.New("invalid, expired or already used token")
+
+type Service struct {
+	auth      config.AuthConfig
+	mail      config.MailConfig
+	mailer    mailer.Mailer
+	passwords *password.Service
+}
+
+func NewService(cfg config.IConfig, m mailer.Mailer) *Service {
+	return &Service{
+		auth:      cfg.GetAuthConfig(),
+		mail:      cfg.GetMailConfig(),
+		mailer:    m,
+		passwords: password.NewService(cfg.GetPasswordConfig()),
+	}
+}
+
+// ForgotPassword mails a reset link when the email belongs to an account.
+// Unknown emails are silently ignored so accounts cannot be enumerated, the
+// mailer must send in the background (mailer.Queue) for the timing not to tell.
+func (s *Service) ForgotPassword(ctx context.Context, db *gorm.DB, tenant string, email string) error {
+	var acc account.Account
+	err := db.Where("email = ?", email).First(&acc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := Issue(db, acc.ID, account.TokenPurposeResetPassword, ttlOr(s.auth.ResetTokenTTL, defaultResetTTL))
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Message{
+		From:    s.mail.From,
+		To:      []string{acc.Email},
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen the link below to choose a new password:\n%s\n\n"+
+			"The link expires in %s. If you did not ask for it, ignore this email.\n",
+			acc.Username, s.link("reset-password", tenant, token), ttlOr(s.auth.ResetTokenTTL, defaultResetTTL)),
+	})
+}
+
+// Invite mails a new account a link to set its first password
+func (s *Service) Invite(ctx context.Context, db *gorm.DB, tenant string, acc *account.Account) error {
+	ttl := ttlOr(s.auth.InviteTokenTTL, defaultInviteTTL)
+	token, err := Issue(db, acc.ID, account.TokenPurposeResetPassword, ttl)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Message{
+		From:    s.mail.From,
+		To:      []string{acc.Email},
+		Subject: "Welcome, set your password",
+		Body: fmt.Sprintf("Hello %s,\n\nAn account was created for you. Open the link below to set your password:\n%s\n\n"+
+			"The link expires in %s.\n",
+			acc.Username, s.link("reset-password", tenant, token), ttl),
+	})
+}
+
+// ResetPassword sets a new password with a token received by email.
+// The email address is verified at the same time since the token was delivered to it.
+func (s *Service) ResetPassword(db *gorm.DB, token string, newPassword string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		t, err := Consume(tx, account.TokenPurposeResetPassword, token)
+		if err != nil {
+			return err
+		}
+		var acc account.Account
+		if err := tx.First(&acc, "id = ?", t.AccountID).Error; err != nil {
+			return err
+		}
+		if err := s.passwords.SetPassword(tx, &acc, newPassword, acc.Username); err != nil {
+			return err
+		}
+		return markVerified(tx, &acc)
+	})
+}
+
+// SendVerification mails a link confirming the email address of an account
+func (s *Service) SendVerification(ctx context.Context, db *gorm.DB, tenant string, acc *account.Account) error {
+	ttl := ttlOr(s.auth.VerifyTokenTTL, defaultVerifyTTL)
+	token, err := Issue(db, acc.ID, account.TokenPurposeVerifyEmail, ttl)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Message{
+		From:    s.mail.From,
+		To:      []string{acc.Email},
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen the link below to confirm your email address:\n%s\n\nThe link expires in %s.\n",
+			acc.Username, s.link("verify-email", tenant, token), ttl),
+	})
+}
+
+// VerifyEmail marks the email of the account owning the token as verified
+func (s *Service) VerifyEmail(db *gorm.DB, token string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		t, err := Consume(tx, account.TokenPurposeVerifyEmail, token)
+		if err != nil {
+			return err
+		}
+		var acc account.Account
+		if err := tx.First(&acc, "id = ?", t.AccountID).Error; err != nil {
+			return err
+		}
+		return markVerified(tx, &acc)
+	})
+}
+
+func (s *Service) link(page string, tenant string, token string) string {
+	q := url.Values{}
+	q.Set("tenant", tenant)
+	q.Set("token", token)
+	return s.mail.LinkBaseURL + "/" + page + "?" + q.Encode()
+}
+
+// Issue creates a token for an account, revoking the unused tokens of the same purpose
+func Issue(db *gorm.DB, accountID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now().UTC()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&account.AccountToken{}).
+			Where("account_id = ? AND purpose = ? AND used_on IS NULL", accountID, purpose).
+			Update("used_on", now).Error
+		if err != nil {
+			return err
+		}
+		t := account.AccountToken{
+			AccountID: accountID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			ExpiresOn: now.Add(ttl),
+		}
+		t.ID = uuid.New()
+		t.CreatedOn = now
+		return tx.Create(&t).Error
+	})
+	return token, err
+}
+
+// Consume marks a valid token as used and returns it. A token can only be consumed once.
+func Consume(db *gorm.DB, purpose string, token string) (*account.AccountToken, error) {
+	var t account.AccountToken
+	err := db.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if t.UsedOn != nil || now.After(t.ExpiresOn) {
+		return nil, ErrInvalidToken
+	}
+	// the condition on used_on makes concurrent consumers race safely
+	result := db.Model(&account.AccountToken{}).Where("id = ? AND used_on IS NULL", t.ID).Update("used_on", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, ErrInvalidToken
+	}
+	t.UsedOn = &now
+	return &t, nil
+}
+
+func markVerified(tx *gorm.DB, acc *account.Account) error {
+	if acc.EmailVerified {
+		return nil
+	}
+	now := time.Now().UTC()
+	return tx.Model(acc).UpdateColumns(map[string]interface{}{"email_verified": true, "email_verified_on": now}).Error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func ttlOr(ttl time.Duration, fallback time.Duration) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	return fallback
+}
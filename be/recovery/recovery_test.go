@@ -0,0 +1,105 @@
+package recovery_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/mailer"
+	"vngom/models/account"
+	"vngom/password"
+	"vngom/recovery"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func setup(t *testing.T) (*gorm.DB, *recovery.Service, *mailer.MemoryMailer, *account.Account) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&account.Account{}, &account.PasswordHistory{}, &account.AccountToken{}); err != nil {
+		t.Fatal(err)
+	}
+	acc := &account.Account{Username: "erin", Email: "erin@example.com"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC()
+	assert.NoError(t, db.Create(acc).Error)
+
+	cfg := &config.Config{
+		Mail:     config.MailConfig{From: "hr@example.com", LinkBaseURL: "https://hr.example.com"},
+		Password: config.PasswordConfig{MinLength: 10, History: 2},
+	}
+	m := mailer.NewMemoryMailer()
+	return db, recovery.NewService(cfg, m), m, acc
+}
+
+// tokenOf extracts the token of the link in the last message sent
+func tokenOf(t *testing.T, m *mailer.MemoryMailer) string {
+	messages := m.Messages()
+	if len(messages) == 0 {
+		t.Fatal("no message sent")
+	}
+	link := regexp.MustCompile(`https://\S+`).FindString(messages[len(messages)-1].Body)
+	u, err := url.Parse(link)
+	assert.NoError(t, err)
+	return u.Query().Get("token")
+}
+
+func TestForgotAndResetPassword(t *testing.T) {
+	db, svc, m, acc := setup(t)
+
+	assert.NoError(t, svc.ForgotPassword(context.Background(), db, "acme", "nobody@example.com"))
+	assert.Empty(t, m.Messages())
+
+	assert.NoError(t, svc.ForgotPassword(context.Background(), db, "acme", acc.Email))
+	assert.Equal(t, []string{acc.Email}, m.Messages()[0].To)
+	token := tokenOf(t, m)
+
+	assert.NoError(t, svc.ResetPassword(db, token, "Brand-New-Pass-1"))
+	assert.ErrorIs(t, svc.ResetPassword(db, token, "Other-New-Pass-2"), recovery.ErrInvalidToken)
+
+	_, err := password.NewService(config.PasswordConfig{}).Authenticate(db, acc.Username, "Brand-New-Pass-1")
+	assert.NoError(t, err)
+	var stored account.Account
+	assert.NoError(t, db.First(&stored, "id = ?", acc.ID).Error)
+	assert.True(t, stored.EmailVerified)
+}
+
+func TestNewTokenRevokesPrevious(t *testing.T) {
+	db, svc, m, acc := setup(t)
+	assert.NoError(t, svc.Invite(context.Background(), db, "acme", acc))
+	first := tokenOf(t, m)
+	assert.NoError(t, svc.ForgotPassword(context.Background(), db, "acme", acc.Email))
+	second := tokenOf(t, m)
+
+	assert.ErrorIs(t, svc.ResetPassword(db, first, "Brand-New-Pass-1"), recovery.ErrInvalidToken)
+	assert.NoError(t, svc.ResetPassword(db, second, "Brand-New-Pass-1"))
+}
+
+func TestExpiredToken(t *testing.T) {
+	db, svc, _, acc := setup(t)
+	token, err := recovery.Issue(db, acc.ID, account.TokenPurposeVerifyEmail, -time.Minute)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, svc.VerifyEmail(db, token), recovery.ErrInvalidToken)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	db, svc, m, acc := setup(t)
+	assert.NoError(t, svc.SendVerification(context.Background(), db, "acme", acc))
+	token := tokenOf(t, m)
+	// a verification token cannot reset a password
+	assert.ErrorIs(t, svc.ResetPassword(db, token, "Brand-New-Pass-1"), recovery.ErrInvalidToken)
+	assert.NoError(t, svc.VerifyEmail(db, token))
+
+	var stored account.Account
+	assert.NoError(t, db.First(&stored, "id = ?", acc.ID).Error)
+	assert.True(t, stored.EmailVerified)
+	assert.NotNil(t, stored.EmailVerifiedOn)
+}
@@ -0,0 +1,129 @@
+package repo
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Connection is the server part of the database configuration, shared by every tenant database
+type Connection struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	// Options are driver specific, "key=value key=value" for postgres and
+	// "key=value&key=value" for mysql as written in config.yaml
+	Options string
+}
+
+// Dialect knows how to reach the databases of a config.DBType
+type Dialect struct {
+	// Open returns the dialector connecting to the named database
+	Open func(conn Connection, dbName string) (gorm.Dialector, error)
+	// CreateDatabase creates the named database through an open connection when it does not exist
+	CreateDatabase func(db *gorm.DB, dbName string) error
+	// DropDatabase drops the named database through an open connection when it exists
+	DropDatabase func(db *gorm.DB, dbName string) error
+	// ServerDatabase is connected to for creating and dropping databases on
+	// another server than the catalog's, empty for no database
+	ServerDatabase string
+}
+
+var (
+	dialects    = map[string]Dialect{}
+	dialectLock sync.RWMutex
+)
+
+func init() {
+	RegisterDialect("postgres", Dialect{Open: openPostgres, CreateDatabase: createPostgresDatabase, DropDatabase: dropPostgresDatabase, ServerDatabase: "postgres"})
+	RegisterDialect("mysql", Dialect{Open: openMySQL, CreateDatabase: createMySQLDatabase, DropDatabase: dropMySQLDatabase})
+}
+
+// RegisterDialect adds or replaces the dialect of a database type
+func RegisterDialect(dbType string, d Dialect) {
+	dialectLock.Lock()
+	defer dialectLock.Unlock()
+	dialects[strings.ToLower(dbType)] = d
+}
+
+func getDialect(dbType string) (Dialect, bool) {
+	dialectLock.RLock()
+	defer dialectLock.RUnlock()
+	d, ok := dialects[strings.ToLower(dbType)]
+	return d, ok
+}
+
+func openPostgres(conn Connection, dbName string) (gorm.Dialector, error) {
+	params := []string{
+		"host=" + quotePostgres(conn.Host),
+		"port=" + strconv.Itoa(conn.Port),
+		"user=" + quotePostgres(conn.User),
+		"password=" + quotePostgres(conn.Password),
+		"dbname=" + quotePostgres(dbName),
+	}
+	for _, option := range strings.Fields(conn.Options) {
+		// collation is applied when a database is created, it is not a connection parameter
+		if strings.HasPrefix(option, "collation=") {
+			continue
+		}
+		params = append(params, option)
+	}
+	return postgres.Open(strings.Join(params, " ")), nil
+}
+
+func quotePostgres(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+func createPostgresDatabase(db *gorm.DB, dbName string) error {
+	exists := func() (bool, error) {
+		var count int64
+		err := db.Raw("SELECT count(*) FROM pg_database WHERE datname = ?", dbName).Scan(&count).Error
+		return count > 0, err
+	}
+	ok, err := exists()
+	if err != nil || ok {
+		return err
+	}
+	// CREATE DATABASE takes no parameter, the name is validated by the caller and quoted
+	err = db.Exec(`CREATE DATABASE "` + strings.ReplaceAll(dbName, `"`, `""`) + `"`).Error
+	if err != nil {
+		// a concurrent call may have created it in between
+		if ok, _ := exists(); ok {
+			return nil
+		}
+	}
+	return err
+}
+
+func dropPostgresDatabase(db *gorm.DB, dbName string) error {
+	return db.Exec(`DROP DATABASE IF EXISTS "` + strings.ReplaceAll(dbName, `"`, `""`) + `"`).Error
+}
+
+func createMySQLDatabase(db *gorm.DB, dbName string) error {
+	return db.Exec("CREATE DATABASE IF NOT EXISTS `" + strings.ReplaceAll(dbName, "`", "``") + "` CHARACTER SET utf8mb4").Error
+}
+
+func dropMySQLDatabase(db *gorm.DB, dbName string) error {
+	return db.Exec("DROP DATABASE IF EXISTS `" + strings.ReplaceAll(dbName, "`", "``") + "`").Error
+}
+
+func openMySQL(conn Connection, dbName string) (gorm.Dialector, error) {
+	// ParseDSN understands options such as parseTime and loc, FormatDSN escapes the credentials
+	cfg, err := mysqldriver.ParseDSN("tcp(" + net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port)) + ")/?" + conn.Options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mysql options %q: %w", conn.Options, err)
+	}
+	cfg.User = conn.User
+	cfg.Passwd = conn.Password
+	cfg.DBName = dbName
+	return mysql.Open(cfg.FormatDSN()), nil
+}
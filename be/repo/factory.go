@@ -0,0 +1,551 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vngom/config"
+	"vngom/tenantscope"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMaxOpenConns        = 10
+	defaultMaxIdleConns        = 2
+	defaultConnMaxLifetime     = 30 * time.Minute
+	defaultConnMaxIdleTime     = 5 * time.Minute
+	defaultMaxOpenDbs          = 100
+	defaultIdleTimeout         = 30 * time.Minute
+	defaultHealthCheckInterval = time.Minute
+	pingTimeout                = 5 * time.Second
+	// retireDelay outlasts the requests that got a database before its eviction
+	retireDelay = 5 * time.Minute
+)
+
+// entry is a database opened on first use, concurrent callers wait for the same open
+type entry struct {
+	once     sync.Once
+	db       *gorm.DB
+	err      error
+	ready    atomic.Bool
+	lastUsed atomic.Int64
+}
+
+// opened returns the database once successfully opened, nil before
+func (e *entry) opened() *gorm.DB {
+	if !e.ready.Load() {
+		return nil
+	}
+	return e.db
+}
+
+// inUse reports whether a query holds a connection of the database
+func (e *entry) inUse() bool {
+	db := e.opened()
+	if db == nil {
+		return false
+	}
+	sqlDB, err := db.DB()
+	return err == nil && sqlDB.Stats().InUse > 0
+}
+
+func (e *entry) touch() {
+	e.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (e *entry) idleSince() time.Time {
+	return time.Unix(0, e.lastUsed.Load())
+}
+
+// close waits for a pending open then closes the connections.
+// sql.DB.Close lets the queries already running finish.
+func (e *entry) close() error {
+	e.once.Do(func() { e.err = ErrFactoryClosed })
+	if e.db == nil {
+		return nil
+	}
+	sqlDB, err := e.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+type RepoFactory struct {
+	dbType    string
+	conn      Connection
+	catalog   string
+	pool      config.DBPoolConfig
+	plugins   []gorm.Plugin
+	dbPlugins map[string][]gorm.Plugin
+	resolver  DbNameResolver
+	// connResolver places tenant databases on other servers, nil keeps them on conn
+	connResolver ConnectionResolver
+
+	lock    sync.Mutex
+	entries map[string]*entry
+	// retired are evicted databases waiting for their last queries before being closed
+	retired []retiredEntry
+	closed  bool
+}
+
+type retiredEntry struct {
+	dbName    string
+	entry     *entry
+	retiredOn time.Time
+}
+
+func NewRepoFactory(dbType string) IRepoFactory {
+	return &RepoFactory{
+		dbType:    dbType,
+		resolver:  defaultDbNameResolver,
+		entries:   map[string]*entry{},
+		dbPlugins: map[string][]gorm.Plugin{},
+	}
+}
+
+func (f *RepoFactory) ConfigDb(host string, port int, user string, password string) {
+	f.conn.Host = host
+	f.conn.Port = port
+	f.conn.User = user
+	f.conn.Password = password
+}
+
+func (f *RepoFactory) ConfigCatalog(name string, options string) {
+	f.catalog = name
+	f.conn.Options = options
+}
+
+func (f *RepoFactory) ConfigPool(pool config.DBPoolConfig) {
+	f.pool = pool
+}
+
+func (f *RepoFactory) SetDbNameResolver(resolver DbNameResolver) {
+	f.resolver = resolver
+}
+
+func (f *RepoFactory) SetConnectionResolver(resolver ConnectionResolver) {
+	f.connResolver = resolver
+}
+
+func (f *RepoFactory) Use(plugins ...gorm.Plugin) {
+	f.plugins = append(f.plugins, plugins...)
+}
+
+func (f *RepoFactory) UseFor(dbName string, plugins ...gorm.Plugin) {
+	f.dbPlugins[dbName] = append(f.dbPlugins[dbName], plugins...)
+}
+
+// Get returns the database of a tenant, opening it on first use
+func (f *RepoFactory) Get(tenant string) (IRepo, error) {
+	dbName, err := f.resolver(tenant)
+	if err != nil {
+		return nil, err
+	}
+	if dbName == "" || dbName == f.catalog {
+		return nil, ErrInvalidTenant
+	}
+	conn, err := f.connection(tenant)
+	if err != nil {
+		return nil, err
+	}
+	db, err := f.open(dbName, conn)
+	if err != nil {
+		return nil, err
+	}
+	if tenantscope.Installed(db) {
+		// the tenant travels in the context of the statements of a shared database
+		db = db.WithContext(tenantscope.WithTenant(context.Background(), tenant))
+	}
+	return &Repo{db: db, tenant: tenant, dbName: dbName}, nil
+}
+
+func (f *RepoFactory) GetDatabase(tenant string, dbName string) (IRepo, error) {
+	if !IsValidName(dbName) || dbName == f.catalog {
+		return nil, ErrInvalidTenant
+	}
+	conn, err := f.connection(tenant)
+	if err != nil {
+		return nil, err
+	}
+	db, err := f.open(dbName, conn)
+	if err != nil {
+		return nil, err
+	}
+	return &Repo{db: db, dbName: dbName}, nil
+}
+
+func (f *RepoFactory) Opened(dbName string) (IRepo, bool) {
+	f.lock.Lock()
+	e, ok := f.entries[dbName]
+	f.lock.Unlock()
+	if !ok || e.opened() == nil {
+		return nil, false
+	}
+	return &Repo{db: e.opened(), dbName: dbName}, true
+}
+
+// connection returns the server of the databases of tenant
+func (f *RepoFactory) connection(tenant string) (Connection, error) {
+	if f.connResolver == nil {
+		return f.conn, nil
+	}
+	return f.connResolver(tenant, f.conn)
+}
+
+// GetCatalog returns the catalog database, it is never evicted
+func (f *RepoFactory) GetCatalog() (IRepo, error) {
+	if f.catalog == "" {
+		return nil, ErrCatalogNotSet
+	}
+	db, err := f.open(f.catalog, f.conn)
+	if err != nil {
+		return nil, err
+	}
+	return &Repo{db: db, dbName: f.catalog}, nil
+}
+
+func (f *RepoFactory) CreateDatabase(tenant string, dbName string) error {
+	if !IsValidName(dbName) || dbName == f.catalog {
+		return ErrInvalidTenant
+	}
+	dialect, ok := getDialect(f.dbType)
+	if !ok || dialect.CreateDatabase == nil {
+		return fmt.Errorf("%w: %q cannot create databases", ErrUnsupportedDBType, f.dbType)
+	}
+	return f.onServer(tenant, dialect, func(db *gorm.DB) error {
+		return dialect.CreateDatabase(db, dbName)
+	})
+}
+
+// DropDatabase closes the connections of the factory to the database then drops it
+func (f *RepoFactory) DropDatabase(tenant string, dbName string) error {
+	if !IsValidName(dbName) || dbName == f.catalog {
+		return ErrInvalidTenant
+	}
+	dialect, ok := getDialect(f.dbType)
+	if !ok || dialect.DropDatabase == nil {
+		return fmt.Errorf("%w: %q cannot drop databases", ErrUnsupportedDBType, f.dbType)
+	}
+	f.lock.Lock()
+	var open []*entry
+	if e, ok := f.entries[dbName]; ok {
+		delete(f.entries, dbName)
+		open = append(open, e)
+	}
+	// the server refuses to drop a database still connected
+	kept := f.retired[:0]
+	for _, r := range f.retired {
+		if r.dbName == dbName {
+			open = append(open, r.entry)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	f.retired = kept
+	f.lock.Unlock()
+	for _, e := range open {
+		if err := e.close(); err != nil {
+			return err
+		}
+	}
+	return f.onServer(tenant, dialect, func(db *gorm.DB) error {
+		return dialect.DropDatabase(db, dbName)
+	})
+}
+
+// onServer runs fn on the server of the databases of tenant. It goes through
+// the catalog on its server, through a connection closed afterwards on another.
+func (f *RepoFactory) onServer(tenant string, dialect Dialect, fn func(db *gorm.DB) error) error {
+	conn, err := f.connection(tenant)
+	if err != nil {
+		return err
+	}
+	if conn == f.conn {
+		catalog, err := f.GetCatalog()
+		if err != nil {
+			return err
+		}
+		return fn(catalog.GetDb())
+	}
+	dialector, err := dialect.Open(conn, dialect.ServerDatabase)
+	if err != nil {
+		return err
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", conn.Host, err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	return fn(db)
+}
+
+// open returns the database, connecting it on conn when it is not open yet
+func (f *RepoFactory) open(dbName string, conn Connection) (*gorm.DB, error) {
+	f.lock.Lock()
+	if f.closed {
+		f.lock.Unlock()
+		return nil, ErrFactoryClosed
+	}
+	e, ok := f.entries[dbName]
+	if ok {
+		e.touch()
+	} else {
+		e = &entry{}
+		e.touch()
+		f.entries[dbName] = e
+		f.evictOverflow()
+	}
+	f.lock.Unlock()
+
+	e.once.Do(func() {
+		e.db, e.err = f.connect(dbName, conn)
+		e.ready.Store(e.err == nil)
+	})
+	if e.err != nil {
+		// forget the failure so the next call retries
+		f.lock.Lock()
+		if f.entries[dbName] == e {
+			delete(f.entries, dbName)
+		}
+		f.lock.Unlock()
+		return nil, e.err
+	}
+	return e.db, nil
+}
+
+func (f *RepoFactory) connect(dbName string, conn Connection) (*gorm.DB, error) {
+	dialect, ok := getDialect(f.dbType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDBType, f.dbType)
+	}
+	dialector, err := dialect.Open(conn, dbName)
+	if err != nil {
+		return nil, err
+	}
+	// TranslateError turns unique violations of every dialect into gorm.ErrDuplicatedKey
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", dbName, err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(orDefault(f.pool.MaxOpenConns, defaultMaxOpenConns))
+	sqlDB.SetMaxIdleConns(orDefault(f.pool.MaxIdleConns, defaultMaxIdleConns))
+	sqlDB.SetConnMaxLifetime(orDefault(f.pool.ConnMaxLifetime, defaultConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(orDefault(f.pool.ConnMaxIdleTime, defaultConnMaxIdleTime))
+	plugins := append(append([]gorm.Plugin{}, f.plugins...), f.dbPlugins[dbName]...)
+	for _, plugin := range plugins {
+		if err := db.Use(plugin); err != nil {
+			_ = sqlDB.Close()
+			return nil, fmt.Errorf("failed to apply plugin %s: %w", plugin.Name(), err)
+		}
+	}
+	return db, nil
+}
+
+// evictOverflow closes the least recently used tenant databases beyond MaxOpenDbs.
+// The caller holds the lock.
+func (f *RepoFactory) evictOverflow() {
+	max := orDefault(f.pool.MaxOpenDbs, defaultMaxOpenDbs)
+	tenants := f.tenantEntries()
+	if len(tenants) <= max {
+		return
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].entry.idleSince().Before(tenants[j].entry.idleSince())
+	})
+	for _, t := range tenants[:len(tenants)-max] {
+		f.evict(t.dbName, t.entry)
+	}
+}
+
+type namedEntry struct {
+	dbName string
+	entry  *entry
+}
+
+// tenantEntries lists the open databases except the catalog. The caller holds the lock.
+func (f *RepoFactory) tenantEntries() []namedEntry {
+	ret := make([]namedEntry, 0, len(f.entries))
+	for name, e := range f.entries {
+		if name != f.catalog {
+			ret = append(ret, namedEntry{dbName: name, entry: e})
+		}
+	}
+	return ret
+}
+
+// Evict closes a database, it is opened again on next use
+func (f *RepoFactory) Evict(dbName string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if e, ok := f.entries[dbName]; ok && dbName != f.catalog {
+		f.evict(dbName, e)
+	}
+}
+
+func logger() *slog.Logger {
+	return slog.Default().With("logger", "repo")
+}
+
+// evict forgets a database, the next Get opens it again. Requests may still hold
+// it so it is not closed at once: its connections are released as they become
+// idle and closeRetired closes it later. The caller holds the lock.
+func (f *RepoFactory) evict(dbName string, e *entry) {
+	delete(f.entries, dbName)
+	if db := e.opened(); db != nil {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.SetMaxIdleConns(0)
+		}
+	}
+	f.retired = append(f.retired, retiredEntry{dbName: dbName, entry: e, retiredOn: time.Now()})
+}
+
+// closeRetired closes the evicted databases retired for retireDelay and no longer in use
+func (f *RepoFactory) closeRetired() {
+	deadline := time.Now().Add(-retireDelay)
+	f.lock.Lock()
+	var expired []retiredEntry
+	kept := f.retired[:0]
+	for _, r := range f.retired {
+		if r.retiredOn.Before(deadline) && !r.entry.inUse() {
+			expired = append(expired, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	f.retired = kept
+	f.lock.Unlock()
+	for _, r := range expired {
+		if err := r.entry.close(); err != nil {
+			logger().Warn("failed to close database", "db", r.dbName, "err", err)
+		}
+	}
+}
+
+func (f *RepoFactory) Start(ctx context.Context) {
+	interval := orDefault(f.pool.HealthCheckInterval, defaultHealthCheckInterval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := f.Close(); err != nil {
+					logger().Warn("failed to close databases", "err", err)
+				}
+				return
+			case <-ticker.C:
+				f.evictIdle()
+				f.checkHealth(ctx)
+				f.closeRetired()
+			}
+		}
+	}()
+}
+
+func (f *RepoFactory) evictIdle() {
+	deadline := time.Now().Add(-orDefault(f.pool.IdleTimeout, defaultIdleTimeout))
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, t := range f.tenantEntries() {
+		if t.entry.idleSince().Before(deadline) {
+			f.evict(t.dbName, t.entry)
+		}
+	}
+}
+
+// checkHealth pings the open databases, a failing one is evicted and reopened on next use
+func (f *RepoFactory) checkHealth(ctx context.Context) {
+	f.lock.Lock()
+	opened := make([]namedEntry, 0, len(f.entries))
+	for name, e := range f.entries {
+		opened = append(opened, namedEntry{dbName: name, entry: e})
+	}
+	f.lock.Unlock()
+	for _, t := range opened {
+		db := t.entry.opened()
+		if db == nil {
+			continue
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		err := (&Repo{db: db}).Ping(pingCtx)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		logger().Warn("database failed health check, evicting it", "db", t.dbName, "err", err)
+		f.lock.Lock()
+		if f.entries[t.dbName] == t.entry {
+			f.evict(t.dbName, t.entry)
+		}
+		f.lock.Unlock()
+	}
+}
+
+// Close closes every database, Get fails afterwards
+func (f *RepoFactory) Close() error {
+	f.lock.Lock()
+	entries := f.entries
+	f.entries = map[string]*entry{}
+	retired := f.retired
+	f.retired = nil
+	f.closed = true
+	f.lock.Unlock()
+	var errs []error
+	for name, e := range entries {
+		if err := e.close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close database %s: %w", name, err))
+		}
+	}
+	for _, r := range retired {
+		if err := r.entry.close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close database %s: %w", r.dbName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *RepoFactory) Stats() []DbStats {
+	f.lock.Lock()
+	opened := make([]namedEntry, 0, len(f.entries))
+	for name, e := range f.entries {
+		opened = append(opened, namedEntry{dbName: name, entry: e})
+	}
+	f.lock.Unlock()
+	ret := make([]DbStats, 0, len(opened))
+	for _, t := range opened {
+		db := t.entry.opened()
+		if db == nil {
+			continue
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			continue
+		}
+		ret = append(ret, DbStats{DbName: t.dbName, Stats: sqlDB.Stats()})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].DbName < ret[j].DbName })
+	return ret
+}
+
+func orDefault[T int | time.Duration](value T, fallback T) T {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nttlong/regorm/expr/compiler"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter expression")
+
+// operators accepted in filters and their SQL form
+var filterOperators = map[string]string{
+	"or": "OR", "||": "OR",
+	"and": "AND", "&&": "AND",
+	"==": "=", "=": "=",
+	"<": "<", "<=": "<=", ">": ">", ">=": ">=",
+	"like": "LIKE",
+	"+":    "+", "-": "-", "*": "*", "/": "/",
+	"()": "()",
+}
+
+// functions accepted in filters, they exist in every supported database
+var filterFunctions = map[string]string{
+	"lower": "LOWER", "upper": "UPPER", "abs": "ABS", "coalesce": "COALESCE",
+}
+
+// Filter is a filter expression over the fields of a model, such as
+// "Code == ? and Level <= ?". Values are always passed as parameters.
+// The expression is compiled to SQL once per model and cached.
+type Filter struct {
+	Expr string
+	Args []interface{}
+}
+
+// Where creates a filter
+func Where(expr string, args ...interface{}) *Filter {
+	return &Filter{Expr: expr, Args: args}
+}
+
+// compiledFilters caches the SQL of an expression per dialect and model type
+var compiledFilters sync.Map
+
+type filterKey struct {
+	dialect string
+	model   reflect.Type
+	expr    string
+}
+
+type compiledFilter struct {
+	sql    string
+	params int
+}
+
+// compile translates the expression to SQL, field names are resolved through
+// the GORM schema so they match the column names of the model
+func (f *Filter) compile(db *gorm.DB, s *schema.Schema) (string, error) {
+	key := filterKey{dialect: db.Dialector.Name(), model: s.ModelType, expr: f.Expr}
+	if c, ok := compiledFilters.Load(key); ok {
+		return c.(*compiledFilter).check(f)
+	}
+	tree, err := compiler.ParseExpr(f.Expr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	params := 0
+	sql, err := compiler.Resolve(tree, func(n *compiler.SimpleExprTree) error {
+		if len(n.Ns) > 0 {
+			if n.Nt == "func" {
+				name, ok := filterFunctions[strings.ToLower(n.V)]
+				if !ok {
+					return fmt.Errorf("%w: unknown function %s", ErrInvalidFilter, n.V)
+				}
+				n.V = name
+				return nil
+			}
+			op, ok := filterOperators[strings.ToLower(n.Op)]
+			if !ok {
+				return fmt.Errorf("%w: unsupported operator %s", ErrInvalidFilter, n.Op)
+			}
+			n.Op = op
+			return nil
+		}
+		switch n.Nt {
+		case "param":
+			params++
+			return nil
+		case "const":
+			// string literals must be passed as parameters
+			if _, err := strconv.ParseFloat(n.V, 64); err != nil {
+				return fmt.Errorf("%w: constant %s must be passed as a parameter", ErrInvalidFilter, n.V)
+			}
+			return nil
+		case "field":
+			field := s.LookUpField(n.V)
+			if field == nil || field.DBName == "" {
+				return fmt.Errorf("%w: unknown field %s", ErrInvalidFilter, n.V)
+			}
+			n.V = db.Statement.Quote(field.DBName)
+			return nil
+		}
+		return fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, n.V)
+	})
+	if err != nil {
+		return "", err
+	}
+	c := &compiledFilter{sql: sql, params: params}
+	compiledFilters.Store(key, c)
+	return c.check(f)
+}
+
+func (c *compiledFilter) check(f *Filter) (string, error) {
+	if c.params != len(f.Args) {
+		return "", fmt.Errorf("%w: expected %d parameters, got %d", ErrInvalidFilter, c.params, len(f.Args))
+	}
+	return c.sql, nil
+}
@@ -0,0 +1,114 @@
+// repo gives handlers the database of their tenant. Every tenant has its own
+// database on the configured server, next to the catalog database holding
+// the list of tenants.
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+
+	"vngom/config"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidTenant      = errors.New("invalid tenant name")
+	ErrUnsupportedDBType  = errors.New("unsupported database type")
+	ErrFactoryClosed      = errors.New("repository factory is closed")
+	ErrCatalogNotSet      = errors.New("catalog database is not configured")
+	validTenantName       = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,62}$`)
+	defaultDbNameResolver = func(tenant string) (string, error) {
+		if !IsValidName(tenant) {
+			return "", ErrInvalidTenant
+		}
+		return tenant, nil
+	}
+)
+
+// IsValidName reports whether a tenant or database name is safe to use as a database identifier
+func IsValidName(name string) bool {
+	return validTenantName.MatchString(name)
+}
+
+// IRepo is the database of a tenant
+type IRepo interface {
+	GetDb() *gorm.DB
+	GetTenant() string
+	GetDbName() string
+	Ping(ctx context.Context) error
+}
+
+// DbNameResolver returns the database name of a tenant
+type DbNameResolver func(tenant string) (string, error)
+
+// ConnectionResolver returns the server of the database of a tenant given the default one
+type ConnectionResolver func(tenant string, conn Connection) (Connection, error)
+
+// DbStats describes the connection pool of an open database
+type DbStats struct {
+	DbName string
+	Stats  sql.DBStats
+}
+
+// IRepoFactory opens and caches the databases of tenants.
+// The Config methods and Use must be called before the first Get.
+type IRepoFactory interface {
+	ConfigDb(host string, port int, user string, password string)
+	// ConfigCatalog sets the name of the catalog database and the driver options
+	ConfigCatalog(name string, options string)
+	ConfigPool(pool config.DBPoolConfig)
+	// SetDbNameResolver replaces the default mapping of a tenant to a database of the same name
+	SetDbNameResolver(resolver DbNameResolver)
+	// SetConnectionResolver lets tenants have their database on another server than the catalog
+	SetConnectionResolver(resolver ConnectionResolver)
+	// Use applies GORM plugins to every database opened by the factory
+	Use(plugins ...gorm.Plugin)
+	// UseFor applies GORM plugins to one database only
+	UseFor(dbName string, plugins ...gorm.Plugin)
+	Get(tenant string) (IRepo, error)
+	// GetDatabase returns a database by name on the server of tenant, for the work on
+	// the database of a tenant that cannot be resolved anymore, such as a purge
+	GetDatabase(tenant string, dbName string) (IRepo, error)
+	// Opened returns a database already open, without opening it
+	Opened(dbName string) (IRepo, bool)
+	GetCatalog() (IRepo, error)
+	// CreateDatabase creates a database on the server of tenant when it does not exist
+	CreateDatabase(tenant string, dbName string) error
+	// DropDatabase closes and drops a database on the server of tenant, it cannot be undone
+	DropDatabase(tenant string, dbName string) error
+	// Evict closes an open database so the next Get connects again, after its server changed
+	Evict(dbName string)
+	// Start runs the idle eviction and health checks until ctx is done, then closes every database
+	Start(ctx context.Context)
+	Close() error
+	Stats() []DbStats
+}
+
+type Repo struct {
+	db     *gorm.DB
+	tenant string
+	dbName string
+}
+
+func (r *Repo) GetDb() *gorm.DB {
+	return r.db
+}
+
+func (r *Repo) GetTenant() string {
+	return r.tenant
+}
+
+func (r *Repo) GetDbName() string {
+	return r.dbName
+}
+
+func (r *Repo) Ping(ctx context.Context) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
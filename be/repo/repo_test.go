@@ -0,0 +1,145 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/repo"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func init() {
+	repo.RegisterDialect("sqlite", repo.Dialect{
+		Open: func(conn repo.Connection, dbName string) (gorm.Dialector, error) {
+			return sqlite.Open("file:" + dbName + "?mode=memory&cache=shared"), nil
+		},
+	})
+}
+
+func newFactory(pool config.DBPoolConfig) repo.IRepoFactory {
+	f := repo.NewRepoFactory("sqlite")
+	f.ConfigCatalog("catalog", "")
+	f.ConfigPool(pool)
+	return f
+}
+
+func dbNames(f repo.IRepoFactory) []string {
+	var names []string
+	for _, s := range f.Stats() {
+		names = append(names, s.DbName)
+	}
+	return names
+}
+
+type countingPlugin struct {
+	count int
+}
+
+func (p *countingPlugin) Name() string { return "counting" }
+
+func (p *countingPlugin) Initialize(db *gorm.DB) error {
+	p.count++
+	return nil
+}
+
+func TestGetCachesOneDbPerTenant(t *testing.T) {
+	f := newFactory(config.DBPoolConfig{})
+	defer f.Close()
+	plugin := &countingPlugin{}
+	f.Use(plugin)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := f.Get("acme")
+			assert.NoError(t, err)
+			assert.Equal(t, "acme", r.GetDbName())
+		}()
+	}
+	wg.Wait()
+	a1, _ := f.Get("acme")
+	a2, _ := f.Get("acme")
+	b, err := f.Get("globex")
+	assert.NoError(t, err)
+	assert.Same(t, a1.GetDb(), a2.GetDb())
+	assert.NotSame(t, a1.GetDb(), b.GetDb())
+	assert.NoError(t, a1.Ping(context.Background()))
+	assert.Equal(t, 2, plugin.count)
+
+	catalog, err := f.GetCatalog()
+	assert.NoError(t, err)
+	assert.Equal(t, "catalog", catalog.GetDbName())
+	assert.Equal(t, []string{"acme", "catalog", "globex"}, dbNames(f))
+}
+
+func TestInvalidTenants(t *testing.T) {
+	f := newFactory(config.DBPoolConfig{})
+	defer f.Close()
+	for _, tenant := range []string{"", "catalog", "a b", "x;drop", "1abc"} {
+		_, err := f.Get(tenant)
+		assert.ErrorIs(t, err, repo.ErrInvalidTenant, tenant)
+	}
+
+	f.SetDbNameResolver(func(tenant string) (string, error) { return "db_" + tenant, nil })
+	r, err := f.Get("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", r.GetTenant())
+	assert.Equal(t, "db_acme", r.GetDbName())
+
+	unknown := repo.NewRepoFactory("oracle")
+	_, err = unknown.Get("acme")
+	assert.ErrorIs(t, err, repo.ErrUnsupportedDBType)
+}
+
+func TestMaxOpenDbsEvictsLeastRecentlyUsed(t *testing.T) {
+	f := newFactory(config.DBPoolConfig{MaxOpenDbs: 2})
+	defer f.Close()
+	_, _ = f.GetCatalog()
+	for _, tenant := range []string{"t1", "t2", "t1", "t3"} {
+		_, err := f.Get(tenant)
+		assert.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+	// the catalog does not count and is never evicted
+	assert.Equal(t, []string{"catalog", "t1", "t3"}, dbNames(f))
+}
+
+func TestStartEvictsIdleAndClosesOnCancel(t *testing.T) {
+	f := newFactory(config.DBPoolConfig{IdleTimeout: 20 * time.Millisecond, HealthCheckInterval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	f.Start(ctx)
+	_, err := f.Get("idle")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(f.Stats()) == 0 }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		_, err := f.Get("idle")
+		return errors.Is(err, repo.ErrFactoryClosed)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestEvictedDbServesItsRequests(t *testing.T) {
+	f := newFactory(config.DBPoolConfig{MaxOpenDbs: 1})
+	t1, err := f.Get("t1")
+	assert.NoError(t, err)
+	_, err = f.Get("t2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"t2"}, dbNames(f))
+
+	// a request holding the evicted database keeps using it
+	assert.NoError(t, t1.Ping(context.Background()))
+	assert.NoError(t, t1.GetDb().Exec("SELECT 1").Error)
+
+	assert.NoError(t, f.Close())
+	assert.Error(t, t1.Ping(context.Background()))
+}
@@ -0,0 +1,285 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a record was changed since it was read or a unique key is taken
+	ErrConflict     = errors.New("record was modified or conflicts with an existing one")
+	ErrInvalidField = errors.New("invalid field")
+)
+
+// fields that Update never changes
+var readOnlyFields = map[string]bool{"CreatedOn": true, "CreatedBy": true}
+
+const versionField = "ModifiedOn"
+
+// Query selects a page of records. Order lists field names, prefixed with "-" for descending order.
+type Query struct {
+	Filter *Filter
+	Order  []string
+	Limit  int
+	Offset int
+}
+
+// Repository gives typed access to the records of a model, for models keyed by
+// bases.BaseModel (UUID) as well as by an auto incremented ID.
+type Repository[T any] struct {
+	db *gorm.DB
+}
+
+// For returns the repository of a model in the database of a tenant
+func For[T any](r IRepo) *Repository[T] {
+	return &Repository[T]{db: r.GetDb()}
+}
+
+// WithContext returns a repository whose queries carry ctx, such as the data scope of the caller
+func (r *Repository[T]) WithContext(ctx context.Context) *Repository[T] {
+	return &Repository[T]{db: r.db.WithContext(ctx)}
+}
+
+// Get returns the record with the given primary key
+func (r *Repository[T]) Get(id interface{}) (*T, error) {
+	s, err := r.schema()
+	if err != nil {
+		return nil, err
+	}
+	var item T
+	err = r.db.Where(primaryKeyEq(s, id)).Take(&item).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &item, nil
+}
+
+// List returns the records matching the query
+func (r *Repository[T]) List(q Query) ([]T, error) {
+	s, err := r.schema()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := r.where(s, q.Filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range q.Order {
+		desc := strings.HasPrefix(o, "-")
+		field := s.LookUpField(strings.TrimPrefix(o, "-"))
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidField, o)
+		}
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: field.DBName}, Desc: desc})
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+	var items []T
+	if err := tx.Find(&items).Error; err != nil {
+		return nil, translate(err)
+	}
+	return items, nil
+}
+
+// Count returns the number of records matching the filter, nil counts every record
+func (r *Repository[T]) Count(filter *Filter) (int64, error) {
+	s, err := r.schema()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := r.where(s, filter)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = tx.Model(new(T)).Count(&count).Error
+	return count, translate(err)
+}
+
+// Exists reports whether a record matches the filter
+func (r *Repository[T]) Exists(filter *Filter) (bool, error) {
+	count, err := r.Count(filter)
+	return count > 0, err
+}
+
+// Create inserts a record, a taken unique key returns ErrConflict
+func (r *Repository[T]) Create(item *T) error {
+	return translate(r.db.Create(item).Error)
+}
+
+// CreateBatch inserts records in batches of batchSize within one transaction
+func (r *Repository[T]) CreateBatch(items []T, batchSize int) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return translate(r.db.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(items, batchSize).Error
+	}))
+}
+
+// Update applies partial changes, keyed by field or column name, to a record.
+// version is the ModifiedOn value read by the caller: the update fails with
+// ErrConflict when the record changed since. A zero version skips the check.
+func (r *Repository[T]) Update(id interface{}, version time.Time, changes map[string]interface{}) (*T, error) {
+	s, err := r.schema()
+	if err != nil {
+		return nil, err
+	}
+	values, err := columns(s, changes)
+	if err != nil {
+		return nil, err
+	}
+	tx := r.db.Model(new(T)).Where(primaryKeyEq(s, id))
+	if field := s.LookUpField(versionField); field != nil {
+		if !version.IsZero() {
+			tx = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: version})
+		}
+		values[field.DBName] = nextVersion(version)
+	}
+	result := tx.Updates(values)
+	if result.Error != nil {
+		return nil, translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if _, err := r.Get(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrConflict
+	}
+	return r.Get(id)
+}
+
+// UpdateBatch applies the same changes to every record matching the filter and returns their number
+func (r *Repository[T]) UpdateBatch(filter *Filter, changes map[string]interface{}) (int64, error) {
+	s, err := r.schema()
+	if err != nil {
+		return 0, err
+	}
+	if filter == nil {
+		return 0, fmt.Errorf("%w: a batch update needs a filter", ErrInvalidFilter)
+	}
+	values, err := columns(s, changes)
+	if err != nil {
+		return 0, err
+	}
+	if field := s.LookUpField(versionField); field != nil {
+		values[field.DBName] = nextVersion(time.Time{})
+	}
+	tx, err := r.where(s, filter)
+	if err != nil {
+		return 0, err
+	}
+	result := tx.Model(new(T)).Updates(values)
+	return result.RowsAffected, translate(result.Error)
+}
+
+// Delete removes the record with the given primary key
+func (r *Repository[T]) Delete(id interface{}) error {
+	s, err := r.schema()
+	if err != nil {
+		return err
+	}
+	result := r.db.Where(primaryKeyEq(s, id)).Delete(new(T))
+	if result.Error != nil {
+		return translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteBatch removes every record matching the filter and returns their number
+func (r *Repository[T]) DeleteBatch(filter *Filter) (int64, error) {
+	s, err := r.schema()
+	if err != nil {
+		return 0, err
+	}
+	if filter == nil {
+		return 0, fmt.Errorf("%w: a batch delete needs a filter", ErrInvalidFilter)
+	}
+	tx, err := r.where(s, filter)
+	if err != nil {
+		return 0, err
+	}
+	result := tx.Delete(new(T))
+	return result.RowsAffected, translate(result.Error)
+}
+
+func (r *Repository[T]) schema() (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: r.db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	if stmt.Schema.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("model %s has no primary key", stmt.Schema.Name)
+	}
+	return stmt.Schema, nil
+}
+
+func (r *Repository[T]) where(s *schema.Schema, filter *Filter) (*gorm.DB, error) {
+	if filter == nil {
+		return r.db, nil
+	}
+	sql, err := filter.compile(r.db, s)
+	if err != nil {
+		return nil, err
+	}
+	return r.db.Where(sql, filter.Args...), nil
+}
+
+func primaryKeyEq(s *schema.Schema, id interface{}) clause.Eq {
+	return clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: s.PrioritizedPrimaryField.DBName}, Value: id}
+}
+
+// columns maps the changes to column names, refusing keys and unknown fields
+func columns(s *schema.Schema, changes map[string]interface{}) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(changes)+1)
+	for name, value := range changes {
+		field := s.LookUpField(name)
+		if field == nil || field.DBName == "" || field.PrimaryKey || readOnlyFields[field.Name] || field.Name == versionField {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidField, name)
+		}
+		values[field.DBName] = value
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%w: no change", ErrInvalidField)
+	}
+	return values, nil
+}
+
+// translate maps GORM errors to the errors of the package
+func translate(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
+
+// nextVersion is the ModifiedOn of an update, always after the version it replaces
+// so that a second update within the same millisecond is still detected as stale
+func nextVersion(version time.Time) time.Time {
+	// millisecond precision survives the round trip through every supported database
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	if !now.After(version) {
+		now = version.UTC().Truncate(time.Millisecond).Add(time.Millisecond)
+	}
+	return now
+}
@@ -0,0 +1,140 @@
+package repo_test
+
+import (
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/models/account"
+	"vngom/models/department"
+	"vngom/repo"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTenantRepo(t *testing.T, tenant string) repo.IRepo {
+	f := newFactory(config.DBPoolConfig{})
+	t.Cleanup(func() { f.Close() })
+	r, err := f.Get(tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.GetDb().AutoMigrate(&account.Account{}, &department.Department{}); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func newAccount(username string) account.Account {
+	acc := account.Account{Username: username, Email: username + "@example.com"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC()
+	acc.ModifiedOn = acc.CreatedOn.Truncate(time.Millisecond)
+	return acc
+}
+
+func TestRepositoryWithUUIDKeys(t *testing.T) {
+	accounts := repo.For[account.Account](newTenantRepo(t, "uuidkeys"))
+
+	alice := newAccount("alice")
+	assert.NoError(t, accounts.Create(&alice))
+	duplicate := newAccount("alice")
+	assert.ErrorIs(t, accounts.Create(&duplicate), repo.ErrConflict)
+	assert.NoError(t, accounts.CreateBatch([]account.Account{newAccount("bob"), newAccount("carol")}, 10))
+
+	got, err := accounts.Get(alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", got.Username)
+	_, err = accounts.Get(uuid.New())
+	assert.ErrorIs(t, err, repo.ErrNotFound)
+
+	count, err := accounts.Count(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	exists, err := accounts.Exists(repo.Where("Username == ? or Email == ?", "bob", "nobody"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// optimistic concurrency on ModifiedOn
+	updated, err := accounts.Update(alice.ID, got.ModifiedOn, map[string]interface{}{"Email": "alice@corp.example"})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@corp.example", updated.Email)
+	_, err = accounts.Update(alice.ID, got.ModifiedOn, map[string]interface{}{"Email": "stale@example.com"})
+	assert.ErrorIs(t, err, repo.ErrConflict)
+	_, err = accounts.Update(uuid.New(), time.Time{}, map[string]interface{}{"Email": "x@example.com"})
+	assert.ErrorIs(t, err, repo.ErrNotFound)
+	_, err = accounts.Update(alice.ID, time.Time{}, map[string]interface{}{"ID": uuid.New()})
+	assert.ErrorIs(t, err, repo.ErrInvalidField)
+
+	n, err := accounts.UpdateBatch(repo.Where("Username like ?", "%o%"), map[string]interface{}{"email_verified": true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	assert.NoError(t, accounts.Delete(alice.ID))
+	assert.ErrorIs(t, accounts.Delete(alice.ID), repo.ErrNotFound)
+	n, err = accounts.DeleteBatch(repo.Where("EmailVerified == ?", true))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
+
+func TestRepositoryWithUintKeys(t *testing.T) {
+	departments := repo.For[department.Department](newTenantRepo(t, "uintkeys"))
+	for i, code := range []string{"HR", "IT", "OPS"} {
+		d := department.Department{Code: code, Name: code, Level: uint(i), LevelCode: code}
+		assert.NoError(t, departments.Create(&d))
+		assert.NotZero(t, d.ID)
+	}
+
+	items, err := departments.List(repo.Query{
+		Filter: repo.Where("(Level >= ? and Level <= 2) and LevelCode == upper(?)", 1, "ops"),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "OPS", items[0].Code)
+
+	items, err = departments.List(repo.Query{Order: []string{"-Code"}, Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"IT", "HR"}, []string{items[0].Code, items[1].Code})
+
+	got, err := departments.Get(items[0].ID)
+	assert.NoError(t, err)
+	updated, err := departments.Update(got.ID, got.ModifiedOn, map[string]interface{}{"Name": "Information technology"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Information technology", updated.Name)
+	assert.True(t, updated.ModifiedOn.After(got.ModifiedOn))
+}
+
+func TestStaleUpdateWithinTheSameMillisecond(t *testing.T) {
+	accounts := repo.For[account.Account](newTenantRepo(t, "samemillisecond"))
+	alice := newAccount("alice")
+	assert.NoError(t, accounts.Create(&alice))
+	// both updates read the same version and run back to back, usually within one millisecond
+	for i := 0; i < 20; i++ {
+		got, err := accounts.Get(alice.ID)
+		assert.NoError(t, err)
+		updated, err := accounts.Update(alice.ID, got.ModifiedOn, map[string]interface{}{"FailedAttempts": i})
+		assert.NoError(t, err)
+		assert.True(t, updated.ModifiedOn.After(got.ModifiedOn))
+		_, err = accounts.Update(alice.ID, got.ModifiedOn, map[string]interface{}{"FailedAttempts": -1})
+		assert.ErrorIs(t, err, repo.ErrConflict)
+	}
+}
+
+func TestFilterValidation(t *testing.T) {
+	departments := repo.For[department.Department](newTenantRepo(t, "filters"))
+	for _, expr := range []string{
+		"Missing == ?",
+		"Code == 'x'",
+		"sleep(?) == 1",
+		"Code == ? and Name == ?",
+		"Level ^ 2 > ?",
+	} {
+		_, err := departments.List(repo.Query{Filter: repo.Where(expr, "x")})
+		assert.ErrorIs(t, err, repo.ErrInvalidFilter, expr)
+	}
+	_, err := departments.List(repo.Query{Order: []string{"Unknown"}})
+	assert.ErrorIs(t, err, repo.ErrInvalidField)
+	_, err = departments.DeleteBatch(nil)
+	assert.ErrorIs(t, err, repo.ErrInvalidFilter)
+}
@@ -0,0 +1,98 @@
+package accounts
+
+import (
+	"errors"
+
+	"vngom/fiber_wrapper"
+	"vngom/models/account"
+	"vngom/password"
+	"vngom/repo"
+	"vngom/routers/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type resetPasswordRequest struct {
+	NewPassword string `json:"newPassword"`
+}
+
+// ResetPassword lets an administrator set the password of an account, lifting any lockout
+func ResetPassword(c fiber_wrapper.IAppContext) error {
+	id, err := uuid.Parse(c.GetApp().Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid account id")
+	}
+	var req resetPasswordRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := repo.For[account.Account](r).Get(id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return auth.PasswordError(password.ErrAccountNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	passwords, err := auth.NewPasswordService(c)
+	if err != nil {
+		return err
+	}
+	err = passwords.SetPassword(r.GetDb().WithContext(c.GetContext()), acc, req.NewPassword, c.GetUser().Username)
+	if err != nil {
+		return auth.PasswordError(err)
+	}
+	auth.RevokeTokens(c)
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+// Invite mails an account a link to set its password, for new staff accounts
+func Invite(c fiber_wrapper.IAppContext) error {
+	id, err := uuid.Parse(c.GetApp().Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid account id")
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := repo.For[account.Account](r).Get(id)
+	if errors.Is(err, repo.ErrNotFound) {
+		return auth.PasswordError(password.ErrAccountNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	svc, err := auth.NewRecoveryService(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.Invite(c.GetContext(), r.GetDb().WithContext(c.GetContext()), c.GetTenant(), acc); err != nil {
+		return err
+	}
+	return c.GetApp().SendStatus(fiber.StatusAccepted)
+}
+
+// Unlock lifts the lockout of an account after too many failed logins
+func Unlock(c fiber_wrapper.IAppContext) error {
+	id, err := uuid.Parse(c.GetApp().Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid account id")
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	passwords, err := auth.NewPasswordService(c)
+	if err != nil {
+		return err
+	}
+	if err := passwords.Unlock(r.GetDb().WithContext(c.GetContext()), id); err != nil {
+		return err
+	}
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
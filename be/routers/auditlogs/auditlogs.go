@@ -0,0 +1,54 @@
+package auditlogs
+
+import (
+	"encoding/json"
+	"time"
+
+	"vngom/audittrail"
+	"vngom/fiber_wrapper"
+	"vngom/models/audit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type record struct {
+	audit.AuditLog
+	Changes json.RawMessage `json:"changes"`
+}
+
+// List returns the audit records of the tenant, filtered by the query parameters
+// entity, entityId, action, actor, requestId, from and to (RFC 3339)
+func List(c fiber_wrapper.IAppContext) error {
+	app := c.GetApp()
+	q := audittrail.Query{
+		Entity:    app.Query("entity"),
+		EntityID:  app.Query("entityId"),
+		Action:    app.Query("action"),
+		Actor:     app.Query("actor"),
+		RequestID: app.Query("requestId"),
+		Limit:     app.QueryInt("limit", 50),
+		Offset:    app.QueryInt("offset", 0),
+	}
+	for name, bound := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if value := app.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid "+name+", expected an RFC 3339 time")
+			}
+			*bound = t
+		}
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	logs, err := audittrail.List(c.GetContext(), r, q)
+	if err != nil {
+		return err
+	}
+	records := make([]record, len(logs))
+	for i, entry := range logs {
+		records[i] = record{AuditLog: entry, Changes: json.RawMessage(entry.Changes)}
+	}
+	return app.JSON(records)
+}
@@ -1,17 +1,303 @@
 package auth
 
 import (
+	"errors"
+	"vngom/config"
 	"vngom/fiber_wrapper"
+	"vngom/models/account"
+	"vngom/password"
+	"vngom/recovery"
+	"vngom/repo"
+	"vngom/security"
+	"vngom/twofactor"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type changePasswordRequest struct {
+	// Username is ignored when the caller is authenticated
+	Username        string `json:"username"`
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+	// Code is a TOTP or recovery code, required without an access token when two-factor is enabled
+	Code string `json:"code"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
 func Login(c fiber_wrapper.IAppContext) error {
-	c.GetApp().Status(200).JSON(fiber.Map{
+	var req loginRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	passwords, err := NewPasswordService(c)
+	if err != nil {
+		return err
+	}
+	acc, err := passwords.Authenticate(r.GetDb().WithContext(c.GetContext()), req.Username, req.Password)
+	if err != nil {
+		return PasswordError(err)
+	}
+	tokens := security.NewTokenService(c.GetConfig().GetAuthConfig())
+	if acc.TwoFactorEnabled {
+		challenge, err := tokens.IssueChallenge(acc.ID, acc.Username, c.GetTenant(), acc.TokenRevision(), security.PurposeTwoFactor)
+		if err != nil {
+			return err
+		}
+		return c.GetApp().Status(200).JSON(fiber.Map{
+			"message":           "two-factor code required, send it to /auth/login/2fa",
+			"twoFactorRequired": true,
+			"challengeToken":    challenge,
+		})
+	}
+	required, err := twofactor.Required(r.GetDb().WithContext(c.GetContext()), acc.ID)
+	if err != nil {
+		return err
+	}
+	if required {
+		challenge, err := tokens.IssueChallenge(acc.ID, acc.Username, c.GetTenant(), acc.TokenRevision(), security.PurposeTwoFactorEnroll)
+		if err != nil {
+			return err
+		}
+		return c.GetApp().Status(200).JSON(fiber.Map{
+			"message":                 "two-factor enrollment required, use /auth/2fa/enroll",
+			"twoFactorEnrollRequired": true,
+			"challengeToken":          challenge,
+		})
+	}
+	token, err := tokens.Issue(acc.ID, acc.Username, c.GetTenant(), acc.TokenRevision())
+	if err != nil {
+		return err
+	}
+	return c.GetApp().Status(200).JSON(fiber.Map{
 		"message": "login success",
+		"token":   token,
 	})
 
 }
+
+// ChangePassword lets an account replace its password by proving the current one.
+// An access token is not required so that an expired password can be replaced,
+// without one an account with two-factor enabled also proves its second factor.
+// The tokens issued before the change are revoked.
+func ChangePassword(c fiber_wrapper.IAppContext) error {
+	var req changePasswordRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	user := c.GetUser()
+	if user != nil {
+		if user.Tenant != c.GetTenant() {
+			return fiber.ErrForbidden
+		}
+		req.Username = user.Username
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	passwords, err := NewPasswordService(c)
+	if err != nil {
+		return err
+	}
+	db := r.GetDb().WithContext(c.GetContext())
+	acc, err := passwords.Authenticate(db, req.Username, req.CurrentPassword)
+	var expired *password.ExpiredError
+	if errors.As(err, &expired) {
+		err = nil
+	}
+	if err != nil {
+		return PasswordError(err)
+	}
+	if user != nil && user.Revision != acc.TokenRevision() {
+		return fiber.ErrUnauthorized
+	}
+	if user == nil && acc.TwoFactorEnabled {
+		svc, err := newTwoFactorService(c)
+		if err != nil {
+			return err
+		}
+		if err := svc.Verify(db, acc, req.Code); err != nil {
+			return TwoFactorError(err)
+		}
+	}
+	if err := passwords.SetPassword(db, acc, req.NewPassword, acc.Username); err != nil {
+		return PasswordError(err)
+	}
+	RevokeTokens(c)
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeTokens refuses the tokens issued before a password change, which
+// carry an older account.TokenRevision, without waiting for the permission cache to expire
+func RevokeTokens(c fiber_wrapper.IAppContext) {
+	if auth := c.GetAuthorizer(); auth != nil {
+		auth.Invalidate(c.GetTenant())
+	}
+}
+
+// ForgotPassword mails a reset link, it answers the same whether the email is known or not
+func ForgotPassword(c fiber_wrapper.IAppContext) error {
+	var req forgotPasswordRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	svc, err := NewRecoveryService(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.ForgotPassword(c.GetContext(), r.GetDb().WithContext(c.GetContext()), c.GetTenant(), req.Email); err != nil {
+		return err
+	}
+	return c.GetApp().SendStatus(fiber.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token of a reset or invitation email
+func ResetPassword(c fiber_wrapper.IAppContext) error {
+	var req resetPasswordRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	svc, err := NewRecoveryService(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.ResetPassword(r.GetDb().WithContext(c.GetContext()), req.Token, req.NewPassword); err != nil {
+		return RecoveryError(err)
+	}
+	RevokeTokens(c)
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+// VerifyEmail confirms the email address with the token of a verification email
+func VerifyEmail(c fiber_wrapper.IAppContext) error {
+	var req verifyEmailRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	svc, err := NewRecoveryService(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.VerifyEmail(r.GetDb().WithContext(c.GetContext()), req.Token); err != nil {
+		return RecoveryError(err)
+	}
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+// SendVerification mails a verification link to the email of the caller
+func SendVerification(c fiber_wrapper.IAppContext) error {
+	user := c.GetUser()
+	if user == nil {
+		return fiber.ErrUnauthorized
+	}
+	// a token is only valid for the tenant it was issued by
+	if user.Tenant != c.GetTenant() {
+		return fiber.ErrForbidden
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := repo.For[account.Account](r).Get(user.AccountID)
+	if err != nil {
+		return err
+	}
+	if user.Revision != acc.TokenRevision() {
+		return fiber.ErrUnauthorized
+	}
+	if acc.EmailVerified {
+		return c.GetApp().SendStatus(fiber.StatusNoContent)
+	}
+	svc, err := NewRecoveryService(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.SendVerification(c.GetContext(), r.GetDb().WithContext(c.GetContext()), c.GetTenant(), acc); err != nil {
+		return err
+	}
+	return c.GetApp().SendStatus(fiber.StatusAccepted)
+}
+
+func NewRecoveryService(c fiber_wrapper.IAppContext) (*recovery.Service, error) {
+	tenantCfg, err := c.GetTenantConfig()
+	if err != nil {
+		return nil, err
+	}
+	return recovery.NewService(config.WithTenant(c.GetConfig(), tenantCfg), c.GetMailer()), nil
+}
+
+// NewPasswordService applies the password policy of the tenant
+func NewPasswordService(c fiber_wrapper.IAppContext) (*password.Service, error) {
+	tenantCfg, err := c.GetTenantConfig()
+	if err != nil {
+		return nil, err
+	}
+	return password.NewService(tenantCfg.Password), nil
+}
+
+// RecoveryError maps errors of the recovery flows to HTTP errors
+func RecoveryError(err error) error {
+	if errors.Is(err, recovery.ErrInvalidToken) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return PasswordError(err)
+}
+
 func GetTenant(c fiber_wrapper.IAppContext) error {
 	return c.GetApp().SendString(c.GetTenant())
 }
+
+// PasswordError maps errors of the password service to HTTP errors
+func PasswordError(err error) error {
+	var locked *password.LockedError
+	var expired *password.ExpiredError
+	var policy *password.PolicyError
+	switch {
+	case errors.Is(err, password.ErrInvalidCredentials):
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	case errors.Is(err, password.ErrAccountNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, password.ErrPasswordReused):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &locked):
+		return fiber.NewError(fiber.StatusLocked, err.Error())
+	case errors.As(err, &expired):
+		return fiber.NewError(fiber.StatusForbidden, err.Error()+", change it with /auth/change-password")
+	case errors.As(err, &policy):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
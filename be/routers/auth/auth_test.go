@@ -0,0 +1,159 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/fiber_wrapper"
+	"vngom/mailer"
+	"vngom/models/account"
+	"vngom/password"
+	"vngom/repo"
+	"vngom/routers/auth"
+	"vngom/security"
+	"vngom/twofactor"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type testRepo struct {
+	db *gorm.DB
+}
+
+func (r *testRepo) GetDb() *gorm.DB                { return r.db }
+func (r *testRepo) GetTenant() string              { return "acme" }
+func (r *testRepo) GetDbName() string              { return "acme" }
+func (r *testRepo) Ping(ctx context.Context) error { return nil }
+
+// testAuthorizer counts the invalidations of the permission cache
+type testAuthorizer struct {
+	invalidated int
+}
+
+func (a *testAuthorizer) Authorize(c fiber_wrapper.IAppContext, permission string) error { return nil }
+func (a *testAuthorizer) Invalidate(tenant string)                                       { a.invalidated++ }
+
+// testContext is the context of a request of the tenant acme
+type testContext struct {
+	app  *fiber.Ctx
+	user *security.Claims
+	env  *testEnv
+}
+
+func (c *testContext) GetApp() *fiber.Ctx                      { return c.app }
+func (c *testContext) GetTenant() string                       { return "acme" }
+func (c *testContext) SetTenant(tenant string)                 {}
+func (c *testContext) GetConfig() config.IConfig               { return c.env.cfg }
+func (c *testContext) GetRepo() (repo.IRepo, error)            { return &testRepo{db: c.env.db}, nil }
+func (c *testContext) GetUser() *security.Claims               { return c.user }
+func (c *testContext) GetContext() context.Context             { return context.Background() }
+func (c *testContext) GetLogger() *slog.Logger                 { return slog.Default() }
+func (c *testContext) GetMailer() mailer.Mailer                { return c.env.mail }
+func (c *testContext) GetAuthorizer() fiber_wrapper.Authorizer { return c.env.auth }
+func (c *testContext) GetTenantConfig() (config.TenantConfig, error) {
+	return c.env.cfg.GetTenantDefaults(), nil
+}
+
+type testEnv struct {
+	db   *gorm.DB
+	cfg  *config.Config
+	mail *mailer.MemoryMailer
+	auth *testAuthorizer
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&account.Account{}, &account.PasswordHistory{}, &account.RecoveryCode{}, &account.AccountToken{}); err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{
+		db:   db,
+		cfg:  &config.Config{Password: config.PasswordConfig{MinLength: 10}},
+		mail: mailer.NewMemoryMailer(),
+		auth: &testAuthorizer{},
+	}
+}
+
+func (e *testEnv) newAccount(t *testing.T, username string, pass string) *account.Account {
+	acc := account.Account{Username: username, Email: username + "@example.com"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC()
+	assert.NoError(t, e.db.Create(&acc).Error)
+	assert.NoError(t, password.NewService(e.cfg.Password).SetPassword(e.db, &acc, pass, "admin"))
+	return &acc
+}
+
+// call invokes handler as user, nil for an anonymous request, and returns the status
+func (e *testEnv) call(t *testing.T, handler fiber_wrapper.Handler, user *security.Claims, body interface{}) int {
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		return handler(&testContext{app: c, user: user, env: e})
+	})
+	encoded, err := json.Marshal(body)
+	assert.NoError(t, err)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp.StatusCode
+}
+
+func claimsOf(acc *account.Account, tenant string) *security.Claims {
+	return &security.Claims{AccountID: acc.ID, Username: acc.Username, Tenant: tenant, Revision: acc.TokenRevision()}
+}
+
+func TestChangePassword(t *testing.T) {
+	env := newTestEnv(t)
+	acc := env.newAccount(t, "alice", "First-Pass-01")
+	secret, err := twofactor.GenerateSecret()
+	assert.NoError(t, err)
+	assert.NoError(t, env.db.Model(acc).UpdateColumns(map[string]interface{}{"two_factor_enabled": true, "two_factor_secret": secret}).Error)
+
+	// the password alone does not change the password of an account with two-factor enabled
+	change := map[string]string{"username": "alice", "currentPassword": "First-Pass-01", "newPassword": "Second-Pass-02"}
+	assert.Equal(t, fiber.StatusUnauthorized, env.call(t, auth.ChangePassword, nil, change))
+	code, err := twofactor.Code(secret, twofactor.Step(time.Now()))
+	assert.NoError(t, err)
+	change["code"] = code
+	assert.Equal(t, fiber.StatusNoContent, env.call(t, auth.ChangePassword, nil, change))
+	assert.Equal(t, 1, env.auth.invalidated)
+
+	// the token issued before the change is revoked
+	change = map[string]string{"currentPassword": "Second-Pass-02", "newPassword": "Third-Pass-03"}
+	assert.Equal(t, fiber.StatusUnauthorized, env.call(t, auth.ChangePassword, claimsOf(acc, "acme"), change))
+	var stored account.Account
+	assert.NoError(t, env.db.First(&stored, "id = ?", acc.ID).Error)
+	assert.NotEqual(t, acc.TokenRevision(), stored.TokenRevision())
+
+	// a current token stands in for the second factor, only in its tenant
+	assert.Equal(t, fiber.StatusForbidden, env.call(t, auth.ChangePassword, claimsOf(&stored, "other"), change))
+	assert.Equal(t, fiber.StatusNoContent, env.call(t, auth.ChangePassword, claimsOf(&stored, "acme"), change))
+}
+
+func TestSendVerification(t *testing.T) {
+	env := newTestEnv(t)
+	acc := env.newAccount(t, "bob", "First-Pass-01")
+
+	assert.Equal(t, fiber.StatusUnauthorized, env.call(t, auth.SendVerification, nil, nil))
+	// a token of another tenant is refused even when the account ID exists here
+	assert.Equal(t, fiber.StatusForbidden, env.call(t, auth.SendVerification, claimsOf(acc, "other"), nil))
+	assert.Empty(t, env.mail.Messages())
+
+	assert.Equal(t, fiber.StatusAccepted, env.call(t, auth.SendVerification, claimsOf(acc, "acme"), nil))
+	if assert.Len(t, env.mail.Messages(), 1) {
+		assert.Equal(t, []string{acc.Email}, env.mail.Messages()[0].To)
+	}
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"errors"
+	"vngom/fiber_wrapper"
+	"vngom/models/account"
+	"vngom/repo"
+	"vngom/security"
+	"vngom/twofactor"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type twoFactorRequest struct {
+	// ChallengeToken is returned by /auth/login, it stands in for the access token
+	// of an account that has to enroll before it can log in
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+// LoginTwoFactor completes a login with a TOTP or recovery code and issues the access token
+func LoginTwoFactor(c fiber_wrapper.IAppContext) error {
+	var req twoFactorRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	tokens := security.NewTokenService(c.GetConfig().GetAuthConfig())
+	claims, err := tokens.ParseChallenge(req.ChallengeToken, security.PurposeTwoFactor)
+	if err != nil || claims.Tenant != c.GetTenant() {
+		return fiber.NewError(fiber.StatusUnauthorized, security.ErrInvalidToken.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := repo.For[account.Account](r).Get(claims.AccountID)
+	if err != nil {
+		return err
+	}
+	if claims.Revision != acc.TokenRevision() {
+		return fiber.NewError(fiber.StatusUnauthorized, security.ErrInvalidToken.Error())
+	}
+	svc, err := newTwoFactorService(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.Verify(r.GetDb().WithContext(c.GetContext()), acc, req.Code); err != nil {
+		return TwoFactorError(err)
+	}
+	token, err := tokens.Issue(acc.ID, acc.Username, c.GetTenant(), acc.TokenRevision())
+	if err != nil {
+		return err
+	}
+	return c.GetApp().Status(200).JSON(fiber.Map{
+		"message": "login success",
+		"token":   token,
+	})
+}
+
+// EnrollTwoFactor generates a TOTP secret and the otpauth:// URI to render as a QR code
+func EnrollTwoFactor(c fiber_wrapper.IAppContext) error {
+	var req twoFactorRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := twoFactorAccount(c, req.ChallengeToken)
+	if err != nil {
+		return err
+	}
+	svc, err := newTwoFactorService(c)
+	if err != nil {
+		return err
+	}
+	enrollment, err := svc.Enroll(r.GetDb().WithContext(c.GetContext()), acc)
+	if err != nil {
+		return TwoFactorError(err)
+	}
+	return c.GetApp().JSON(enrollment)
+}
+
+// ConfirmTwoFactor activates the enrolled secret and returns the recovery codes.
+// An account enrolling during a login also receives its access token.
+func ConfirmTwoFactor(c fiber_wrapper.IAppContext) error {
+	var req twoFactorRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := twoFactorAccount(c, req.ChallengeToken)
+	if err != nil {
+		return err
+	}
+	svc, err := newTwoFactorService(c)
+	if err != nil {
+		return err
+	}
+	codes, err := svc.Confirm(r.GetDb().WithContext(c.GetContext()), acc, req.Code)
+	if err != nil {
+		return TwoFactorError(err)
+	}
+	res := fiber.Map{"recoveryCodes": codes}
+	if c.GetUser() == nil {
+		token, err := security.NewTokenService(c.GetConfig().GetAuthConfig()).Issue(acc.ID, acc.Username, c.GetTenant(), acc.TokenRevision())
+		if err != nil {
+			return err
+		}
+		res["token"] = token
+	}
+	return c.GetApp().JSON(res)
+}
+
+// DisableTwoFactor turns two-factor authentication off for the caller
+func DisableTwoFactor(c fiber_wrapper.IAppContext) error {
+	var req twoFactorRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := twoFactorAccount(c, "")
+	if err != nil {
+		return err
+	}
+	svc, err := newTwoFactorService(c)
+	if err != nil {
+		return err
+	}
+	if err := svc.Disable(r.GetDb().WithContext(c.GetContext()), acc, req.Code); err != nil {
+		return TwoFactorError(err)
+	}
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the caller
+func RegenerateRecoveryCodes(c fiber_wrapper.IAppContext) error {
+	var req twoFactorRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	acc, err := twoFactorAccount(c, "")
+	if err != nil {
+		return err
+	}
+	svc, err := newTwoFactorService(c)
+	if err != nil {
+		return err
+	}
+	codes, err := svc.RegenerateRecoveryCodes(r.GetDb().WithContext(c.GetContext()), acc, req.Code)
+	if err != nil {
+		return TwoFactorError(err)
+	}
+	return c.GetApp().JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+// twoFactorAccount loads the account of the caller, identified by its access
+// token or by the enrollment challenge token of a login
+func twoFactorAccount(c fiber_wrapper.IAppContext, challengeToken string) (*account.Account, error) {
+	r, err := c.GetRepo()
+	if err != nil {
+		return nil, err
+	}
+	user := c.GetUser()
+	if user == nil && challengeToken != "" {
+		claims, err := security.NewTokenService(c.GetConfig().GetAuthConfig()).ParseChallenge(challengeToken, security.PurposeTwoFactorEnroll)
+		if err == nil && claims.Tenant == c.GetTenant() {
+			user = claims
+		}
+	}
+	if user == nil {
+		return nil, fiber.ErrUnauthorized
+	}
+	// a token is only valid for the tenant it was issued by
+	if user.Tenant != c.GetTenant() {
+		return nil, fiber.ErrForbidden
+	}
+	acc, err := repo.For[account.Account](r).Get(user.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	// the password changed since the token was issued
+	if user.Revision != acc.TokenRevision() {
+		return nil, fiber.ErrUnauthorized
+	}
+	return acc, nil
+}
+
+func newTwoFactorService(c fiber_wrapper.IAppContext) (*twofactor.Service, error) {
+	tenantCfg, err := c.GetTenantConfig()
+	if err != nil {
+		return nil, err
+	}
+	return twofactor.NewService(c.GetConfig().GetAuthConfig(), tenantCfg.Password), nil
+}
+
+// TwoFactorError maps errors of the two-factor service to HTTP errors
+func TwoFactorError(err error) error {
+	switch {
+	case errors.Is(err, twofactor.ErrInvalidCode):
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	case errors.Is(err, twofactor.ErrNotEnrolled), errors.Is(err, twofactor.ErrAlreadyEnabled):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, twofactor.ErrRequired):
+		return fiber.NewError(fiber.StatusForbidden, err.Error())
+	}
+	return PasswordError(err)
+}
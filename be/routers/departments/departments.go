@@ -0,0 +1,24 @@
+package departments
+
+import (
+	"vngom/fiber_wrapper"
+	"vngom/models/department"
+	"vngom/repo"
+)
+
+// List returns the departments visible to the caller, restricted to its data scope
+func List(c fiber_wrapper.IAppContext) error {
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	items, err := repo.For[department.Department](r).WithContext(c.GetContext()).List(repo.Query{
+		Order:  []string{"LevelCode"},
+		Limit:  c.GetApp().QueryInt("limit", 50),
+		Offset: c.GetApp().QueryInt("offset", 0),
+	})
+	if err != nil {
+		return err
+	}
+	return c.GetApp().JSON(items)
+}
@@ -0,0 +1,24 @@
+package employees
+
+import (
+	"vngom/fiber_wrapper"
+	"vngom/models/employee"
+	"vngom/repo"
+)
+
+// List returns the employees visible to the caller, restricted to its data scope
+func List(c fiber_wrapper.IAppContext) error {
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	items, err := repo.For[employee.Employee](r).WithContext(c.GetContext()).List(repo.Query{
+		Order:  []string{"Code"},
+		Limit:  c.GetApp().QueryInt("limit", 50),
+		Offset: c.GetApp().QueryInt("offset", 0),
+	})
+	if err != nil {
+		return err
+	}
+	return c.GetApp().JSON(items)
+}
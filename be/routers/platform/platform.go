@@ -0,0 +1,210 @@
+// platform is the administration API of the tenants, used by sales and support.
+// It lives outside /api/:tenant and is protected by the platform admin key.
+package platform
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"vngom/config"
+	"vngom/routers/auth"
+	"vngom/tenancy"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	keyHeader    = "X-Platform-Key"
+	actorHeader  = "X-Platform-Actor"
+	defaultActor = "platform"
+)
+
+type transitionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type settingsRequest struct {
+	// Version is the version of the overrides being replaced, 0 for a tenant without overrides
+	Version   int                    `json:"version"`
+	Overrides config.TenantOverrides `json:"overrides"`
+}
+
+type api struct {
+	svc      *tenancy.Service
+	settings *tenancy.Settings
+}
+
+// Install mounts the API under /platform/tenants
+func Install(app *fiber.App, cfg config.PlatformConfig, svc *tenancy.Service, settings *tenancy.Settings) {
+	a := &api{svc: svc, settings: settings}
+	g := app.Group("/platform", RequireAdminKey(cfg.AdminKey.Value()))
+	g.Get("/tenants", a.list)
+	g.Post("/tenants", a.create)
+	g.Post("/tenants/purge-expired", a.purgeExpired)
+	g.Get("/tenants/:name", a.get)
+	g.Get("/tenants/:name/events", a.events)
+	g.Get("/tenants/:name/config", a.getConfig)
+	g.Put("/tenants/:name/config", a.updateConfig)
+	g.Post("/tenants/:name/suspend", a.suspend)
+	g.Post("/tenants/:name/reactivate", a.reactivate)
+	g.Delete("/tenants/:name", a.delete)
+	g.Post("/tenants/:name/purge", a.purge)
+}
+
+// RequireAdminKey rejects the requests without the platform admin key, all of them when the key is not configured
+func RequireAdminKey(key string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if key == "" {
+			return fiber.ErrNotFound
+		}
+		if subtle.ConstantTimeCompare([]byte(c.Get(keyHeader)), []byte(key)) != 1 {
+			return fiber.ErrUnauthorized
+		}
+		return c.Next()
+	}
+}
+
+// actor names the operator in the audit trail
+func actor(c *fiber.Ctx) string {
+	if name := c.Get(actorHeader); name != "" {
+		return name
+	}
+	return defaultActor
+}
+
+func (a *api) list(c *fiber.Ctx) error {
+	list, err := a.svc.List(c.UserContext(), c.QueryBool("deleted"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(list)
+}
+
+func (a *api) get(c *fiber.Ctx) error {
+	tenant, err := a.svc.Get(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return toFiberError(err)
+	}
+	return c.JSON(tenant)
+}
+
+func (a *api) events(c *fiber.Ctx) error {
+	events, err := a.svc.Events(c.UserContext(), c.Params("name"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(events)
+}
+
+// create provisions a tenant, calling it again for a failed tenant retries the provisioning
+func (a *api) create(c *fiber.Ctx) error {
+	var req tenancy.ProvisionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	req.CreatedBy = actor(c)
+	tenant, err := a.svc.Provision(c.UserContext(), req)
+	if err != nil && tenant != nil {
+		// the tenant is registered as Failed with the reason
+		return c.Status(fiber.StatusInternalServerError).JSON(tenant)
+	}
+	if err != nil {
+		return toFiberError(err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(tenant)
+}
+
+func (a *api) suspend(c *fiber.Ctx) error {
+	var req transitionRequest
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	tenant, err := a.svc.Suspend(c.UserContext(), c.Params("name"), actor(c), req.Reason)
+	if err != nil {
+		return toFiberError(err)
+	}
+	return c.JSON(tenant)
+}
+
+func (a *api) reactivate(c *fiber.Ctx) error {
+	var req transitionRequest
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	tenant, err := a.svc.Reactivate(c.UserContext(), c.Params("name"), actor(c), req.Reason)
+	if err != nil {
+		return toFiberError(err)
+	}
+	return c.JSON(tenant)
+}
+
+func (a *api) delete(c *fiber.Ctx) error {
+	var req transitionRequest
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	tenant, err := a.svc.Delete(c.UserContext(), c.Params("name"), actor(c), req.Reason)
+	if err != nil {
+		return toFiberError(err)
+	}
+	return c.JSON(tenant)
+}
+
+func (a *api) purge(c *fiber.Ctx) error {
+	if err := a.svc.Purge(c.UserContext(), c.Params("name"), actor(c)); err != nil {
+		return toFiberError(err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (a *api) purgeExpired(c *fiber.Ctx) error {
+	purged, err := a.svc.PurgeExpired(c.UserContext(), actor(c))
+	res := fiber.Map{"purged": purged}
+	if err != nil {
+		res["error"] = err.Error()
+		return c.Status(fiber.StatusInternalServerError).JSON(res)
+	}
+	return c.JSON(res)
+}
+
+// getConfig returns the overrides of the tenant with their version and the resulting configuration
+func (a *api) getConfig(c *fiber.Ctx) error {
+	name := c.Params("name")
+	overrides, version, err := a.settings.Current(c.UserContext(), name)
+	if err != nil {
+		return toFiberError(err)
+	}
+	effective, err := a.settings.Get(name)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"version": version, "overrides": overrides, "effective": effective})
+}
+
+// updateConfig replaces the overrides of the tenant, the version read by getConfig is required
+func (a *api) updateConfig(c *fiber.Ctx) error {
+	var req settingsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	version, err := a.settings.Update(c.UserContext(), c.Params("name"), req.Version, req.Overrides, actor(c))
+	if err != nil {
+		return toFiberError(err)
+	}
+	return c.JSON(fiber.Map{"version": version})
+}
+
+// toFiberError maps errors of the tenancy service to HTTP errors
+func toFiberError(err error) error {
+	switch {
+	case errors.Is(err, tenancy.ErrTenantNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, tenancy.ErrInvalidTransition), errors.Is(err, tenancy.ErrRetention), errors.Is(err, tenancy.ErrTenantDeleted),
+		errors.Is(err, tenancy.ErrStaleSettings), errors.Is(err, tenancy.ErrNameTaken):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, tenancy.ErrInvalidName), errors.Is(err, tenancy.ErrAdminRequired), errors.Is(err, tenancy.ErrIsolation),
+		errors.Is(err, tenancy.ErrSharedDisabled), errors.Is(err, tenancy.ErrInvalidSettings), errors.Is(err, tenancy.ErrSharedDBHost):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return auth.PasswordError(err)
+}
@@ -0,0 +1,178 @@
+package roles
+
+import (
+	"errors"
+
+	"vngom/authz"
+	"vngom/fiber_wrapper"
+	"vngom/models/rbac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type roleRequest struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	DataScope   string `json:"dataScope"`
+	// RequireTwoFactor makes two-factor authentication mandatory for the holders of the role
+	RequireTwoFactor bool     `json:"requireTwoFactor"`
+	Permissions      []string `json:"permissions"`
+}
+
+type assignmentRequest struct {
+	AccountID uuid.UUID `json:"accountID"`
+	RoleID    uuid.UUID `json:"roleID"`
+}
+
+func ListRoles(c fiber_wrapper.IAppContext) error {
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	roles, err := authz.ListRoles(r.GetDb())
+	if err != nil {
+		return err
+	}
+	return c.GetApp().JSON(roles)
+}
+
+func ListPermissions(c fiber_wrapper.IAppContext) error {
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	permissions, err := authz.ListPermissions(r.GetDb())
+	if err != nil {
+		return err
+	}
+	return c.GetApp().JSON(permissions)
+}
+
+func CreateRole(c fiber_wrapper.IAppContext) error {
+	var req roleRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if req.Code == "" || req.Name == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "code and name are required")
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	role := rbac.Role{Code: req.Code, Name: req.Name, Description: req.Description, DataScope: req.DataScope, RequireTwoFactor: req.RequireTwoFactor}
+	if err := authz.CreateRole(r.GetDb(), &role, req.Permissions, c.GetUser().Username); err != nil {
+		return toFiberError(err)
+	}
+	invalidate(c)
+	return c.GetApp().Status(fiber.StatusCreated).JSON(role)
+}
+
+func UpdateRole(c fiber_wrapper.IAppContext) error {
+	id, err := uuid.Parse(c.GetApp().Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid role id")
+	}
+	var req roleRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	changes := rbac.Role{Name: req.Name, Description: req.Description, DataScope: req.DataScope, RequireTwoFactor: req.RequireTwoFactor}
+	role, err := authz.UpdateRole(r.GetDb(), id, changes, req.Permissions, c.GetUser().Username)
+	if err != nil {
+		return toFiberError(err)
+	}
+	invalidate(c)
+	return c.GetApp().JSON(role)
+}
+
+func DeleteRole(c fiber_wrapper.IAppContext) error {
+	id, err := uuid.Parse(c.GetApp().Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid role id")
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	if err := authz.DeleteRole(r.GetDb(), id); err != nil {
+		return toFiberError(err)
+	}
+	invalidate(c)
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+func AssignRole(c fiber_wrapper.IAppContext) error {
+	var req assignmentRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	if err := authz.AssignRole(r.GetDb(), req.AccountID, req.RoleID); err != nil {
+		return toFiberError(err)
+	}
+	invalidate(c)
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+func RevokeRole(c fiber_wrapper.IAppContext) error {
+	var req assignmentRequest
+	if err := c.GetApp().BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	if err := authz.RevokeRole(r.GetDb(), req.AccountID, req.RoleID); err != nil {
+		return toFiberError(err)
+	}
+	invalidate(c)
+	return c.GetApp().SendStatus(fiber.StatusNoContent)
+}
+
+func GetAccountRoles(c fiber_wrapper.IAppContext) error {
+	accountID, err := uuid.Parse(c.GetApp().Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid account id")
+	}
+	r, err := c.GetRepo()
+	if err != nil {
+		return err
+	}
+	roles, err := authz.GetAccountRoles(r.GetDb(), accountID)
+	if err != nil {
+		return err
+	}
+	return c.GetApp().JSON(roles)
+}
+
+// invalidate drops cached permissions after the roles of the tenant changed
+func invalidate(c fiber_wrapper.IAppContext) {
+	if auth := c.GetAuthorizer(); auth != nil {
+		auth.Invalidate(c.GetTenant())
+	}
+}
+
+func toFiberError(err error) error {
+	switch {
+	case errors.Is(err, authz.ErrRoleNotFound), errors.Is(err, authz.ErrNoAccount):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, authz.ErrSystemRole):
+		return fiber.NewError(fiber.StatusForbidden, err.Error())
+	case errors.Is(err, authz.ErrUnknownPerm), errors.Is(err, authz.ErrUnknownScope):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, authz.ErrRoleCodeExists):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	return err
+}
@@ -1,20 +1,143 @@
 package routers
 
 import (
+	"vngom/authz"
 	"vngom/fiber_wrapper"
+	"vngom/routers/accounts"
+	"vngom/routers/auditlogs"
 	"vngom/routers/auth"
+	"vngom/routers/departments"
+	"vngom/routers/employees"
+	"vngom/routers/roles"
+	"vngom/routers/settings"
 )
 
 var Routes map[string]fiber_wrapper.Router = make(map[string]fiber_wrapper.Router)
 
 func init() {
 	Routes["/auth/login"] = fiber_wrapper.Router{
-		Method:  "GET",
+		Method:  "POST",
 		Handler: auth.Login,
 	}
+	Routes["/auth/login/2fa"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.LoginTwoFactor,
+	}
+	Routes["/auth/2fa/enroll"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.EnrollTwoFactor,
+		Feature: "twoFactor",
+	}
+	Routes["/auth/2fa/confirm"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.ConfirmTwoFactor,
+		Feature: "twoFactor",
+	}
+	Routes["/auth/2fa/disable"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.DisableTwoFactor,
+	}
+	Routes["/auth/2fa/recovery-codes"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.RegenerateRecoveryCodes,
+	}
+	Routes["/auth/change-password"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.ChangePassword,
+	}
+	Routes["/auth/forgot-password"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.ForgotPassword,
+	}
+	Routes["/auth/reset-password"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.ResetPassword,
+	}
+	Routes["/auth/verify-email"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.VerifyEmail,
+	}
+	Routes["/auth/send-verification"] = fiber_wrapper.Router{
+		Method:  "POST",
+		Handler: auth.SendVerification,
+	}
+	Routes["/accounts/invite/:id"] = fiber_wrapper.Router{
+		Method:     "POST",
+		Handler:    accounts.Invite,
+		Permission: authz.PermAccountWrite,
+	}
+	Routes["/accounts/reset-password/:id"] = fiber_wrapper.Router{
+		Method:     "POST",
+		Handler:    accounts.ResetPassword,
+		Permission: authz.PermAccountWrite,
+	}
+	Routes["/accounts/unlock/:id"] = fiber_wrapper.Router{
+		Method:     "POST",
+		Handler:    accounts.Unlock,
+		Permission: authz.PermAccountWrite,
+	}
 	Routes["/auth/get-tenant"] = fiber_wrapper.Router{
 		Method:  "GET",
 		Handler: auth.GetTenant,
 	}
+	Routes["/settings"] = fiber_wrapper.Router{
+		Method:  "GET",
+		Handler: settings.Get,
+	}
+	Routes["/roles/list"] = fiber_wrapper.Router{
+		Method:     "GET",
+		Handler:    roles.ListRoles,
+		Permission: authz.PermRoleRead,
+	}
+	Routes["/roles/permissions"] = fiber_wrapper.Router{
+		Method:     "GET",
+		Handler:    roles.ListPermissions,
+		Permission: authz.PermRoleRead,
+	}
+	Routes["/roles/create"] = fiber_wrapper.Router{
+		Method:     "POST",
+		Handler:    roles.CreateRole,
+		Permission: authz.PermRoleWrite,
+	}
+	Routes["/roles/update/:id"] = fiber_wrapper.Router{
+		Method:     "PUT",
+		Handler:    roles.UpdateRole,
+		Permission: authz.PermRoleWrite,
+	}
+	Routes["/roles/delete/:id"] = fiber_wrapper.Router{
+		Method:     "DELETE",
+		Handler:    roles.DeleteRole,
+		Permission: authz.PermRoleWrite,
+	}
+	Routes["/roles/assign"] = fiber_wrapper.Router{
+		Method:     "POST",
+		Handler:    roles.AssignRole,
+		Permission: authz.PermRoleWrite,
+	}
+	Routes["/roles/revoke"] = fiber_wrapper.Router{
+		Method:     "POST",
+		Handler:    roles.RevokeRole,
+		Permission: authz.PermRoleWrite,
+	}
+	Routes["/roles/account/:id"] = fiber_wrapper.Router{
+		Method:     "GET",
+		Handler:    roles.GetAccountRoles,
+		Permission: authz.PermRoleRead,
+	}
+	Routes["/employees/list"] = fiber_wrapper.Router{
+		Method:     "GET",
+		Handler:    employees.List,
+		Permission: authz.PermEmployeeRead,
+	}
+	Routes["/departments/list"] = fiber_wrapper.Router{
+		Method:     "GET",
+		Handler:    departments.List,
+		Permission: authz.PermDepartmentRead,
+	}
+	Routes["/audit/list"] = fiber_wrapper.Router{
+		Method:     "GET",
+		Handler:    auditlogs.List,
+		Permission: authz.PermAuditRead,
+	}
 
 }
@@ -0,0 +1,29 @@
+package settings
+
+import (
+	"vngom/fiber_wrapper"
+)
+
+// clientSettings are the settings of the tenant the clients apply, the
+// server side ones such as the database server are not disclosed
+type clientSettings struct {
+	Locale        string          `json:"locale"`
+	Timezone      string          `json:"timezone"`
+	Features      map[string]bool `json:"features"`
+	MaxUploadSize int64           `json:"maxUploadSize"`
+}
+
+// Get returns the locale, timezone, features and upload limit of the tenant.
+// It is public, the login page is shown in the locale of the tenant.
+func Get(c fiber_wrapper.IAppContext) error {
+	tenantCfg, err := c.GetTenantConfig()
+	if err != nil {
+		return err
+	}
+	return c.GetApp().JSON(clientSettings{
+		Locale:        tenantCfg.Locale,
+		Timezone:      tenantCfg.Timezone,
+		Features:      tenantCfg.Features,
+		MaxUploadSize: tenantCfg.MaxUploadSize,
+	})
+}
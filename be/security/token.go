@@ -0,0 +1,175 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"vngom/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ClaimsKey is the fiber Locals key holding the *Claims of the authenticated caller
+const ClaimsKey = "security.claims"
+
+const (
+	defaultTokenTTL     = 8 * time.Hour
+	defaultChallengeTTL = 5 * time.Minute
+)
+
+// Purposes of a challenge token, access tokens have no purpose
+const (
+	PurposeTwoFactor       = "2fa"
+	PurposeTwoFactorEnroll = "2fa_enroll"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrNoSecret     = errors.New("auth.jwtSecret is not configured")
+)
+
+// Claims is the payload of an access token issued by /auth/login.
+// A challenge token, issued between the password and the second factor of a
+// login, carries a Purpose and is never accepted as an access token.
+type Claims struct {
+	AccountID uuid.UUID `json:"aid"`
+	Username  string    `json:"usr"`
+	Tenant    string    `json:"tnt"`
+	Purpose   string    `json:"pur,omitempty"`
+	// Revision is the account.TokenRevision at issue, the token is revoked once it moved
+	Revision int64 `json:"rev,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// TokenService issues and verifies HS256 signed access tokens
+type TokenService struct {
+	secret       []byte
+	ttl          time.Duration
+	challengeTTL time.Duration
+}
+
+func NewTokenService(cfg config.AuthConfig) *TokenService {
+	ttl := cfg.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	challengeTTL := cfg.ChallengeTTL
+	if challengeTTL <= 0 {
+		challengeTTL = defaultChallengeTTL
+	}
+	return &TokenService{
+		secret:       []byte(cfg.JwtSecret.Value()),
+		ttl:          ttl,
+		challengeTTL: challengeTTL,
+	}
+}
+
+// Issue creates a signed token for the given account of a tenant
+func (s *TokenService) Issue(accountID uuid.UUID, username string, tenant string, revision int64) (string, error) {
+	return s.sign(accountID, username, tenant, "", revision, s.ttl)
+}
+
+// IssueChallenge creates a short lived token proving the password step of a login
+func (s *TokenService) IssueChallenge(accountID uuid.UUID, username string, tenant string, revision int64, purpose string) (string, error) {
+	return s.sign(accountID, username, tenant, purpose, revision, s.challengeTTL)
+}
+
+// ParseChallenge verifies a challenge token issued for the given purpose
+func (s *TokenService) ParseChallenge(tokenString string, purpose string) (*Claims, error) {
+	claims, err := s.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *TokenService) sign(accountID uuid.UUID, username string, tenant string, purpose string, revision int64, ttl time.Duration) (string, error) {
+	if len(s.secret) == 0 {
+		return "", ErrNoSecret
+	}
+	now := time.Now().UTC()
+	claims := Claims{
+		AccountID: accountID,
+		Username:  username,
+		Tenant:    tenant,
+		Purpose:   purpose,
+		Revision:  revision,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   accountID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+// Parse verifies the signature and expiry of a token and returns its claims
+func (s *TokenService) Parse(tokenString string) (*Claims, error) {
+	if len(s.secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Authenticate is a fiber middleware reading the bearer token of the request.
+// A valid token stores its claims in Locals under ClaimsKey and in the user
+// context, requests without a token pass through anonymously and it is up to
+// the route to reject them.
+func Authenticate(s *TokenService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := c.Get(fiber.HeaderAuthorization)
+		if header == "" {
+			return c.Next()
+		}
+		tokenString, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return fiber.NewError(fiber.StatusUnauthorized, "unsupported authorization scheme")
+		}
+		claims, err := s.Parse(tokenString)
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		}
+		if claims.Purpose != "" {
+			return fiber.NewError(fiber.StatusUnauthorized, "not an access token")
+		}
+		c.Locals(ClaimsKey, claims)
+		c.SetUserContext(WithClaims(c.UserContext(), claims))
+		return c.Next()
+	}
+}
+
+// GetClaims returns the claims of the authenticated caller or nil
+func GetClaims(c *fiber.Ctx) *Claims {
+	claims, _ := c.Locals(ClaimsKey).(*Claims)
+	return claims
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a context carrying the claims of the caller, for the work done on its behalf
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims of the caller of the context, nil for anonymous work
+func ClaimsFromContext(ctx context.Context) *Claims {
+	if ctx == nil {
+		return nil
+	}
+	claims, _ := ctx.Value(claimsKey{}).(*Claims)
+	return claims
+}
@@ -0,0 +1,67 @@
+// Package server builds and starts the HTTP server from config.ServerConfig.
+package server
+
+import (
+	"net"
+	"strconv"
+
+	"vngom/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+)
+
+// Config returns the fiber settings of cfg
+func Config(cfg config.ServerConfig) fiber.Config {
+	return fiber.Config{
+		Prefork:                 cfg.Prefork,
+		ReadTimeout:             cfg.ReadTimeout,
+		WriteTimeout:            cfg.WriteTimeout,
+		IdleTimeout:             cfg.IdleTimeout,
+		Concurrency:             cfg.Concurrency,
+		BodyLimit:               cfg.BodyLimit,
+		EnableTrustedProxyCheck: len(cfg.TrustedProxies) > 0,
+		TrustedProxies:          cfg.TrustedProxies,
+		ProxyHeader:             proxyHeader(cfg),
+	}
+}
+
+// the proxy header is only read behind trusted proxies, clients could forge it otherwise
+func proxyHeader(cfg config.ServerConfig) string {
+	if len(cfg.TrustedProxies) == 0 {
+		return ""
+	}
+	return cfg.ProxyHeader
+}
+
+// Compress returns the compression middleware, nil when it is disabled
+func Compress(cfg config.CompressionConfig) fiber.Handler {
+	if !cfg.Enabled {
+		return nil
+	}
+	level := compress.LevelDefault
+	switch cfg.Level {
+	case "speed":
+		level = compress.LevelBestSpeed
+	case "best":
+		level = compress.LevelBestCompression
+	}
+	return compress.New(compress.Config{Level: level})
+}
+
+// Address is the host:port the server listens on
+func Address(cfg config.ServerConfig) string {
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+}
+
+// Listen serves app over HTTP, HTTPS or mutual TLS depending on cfg.TLS
+func Listen(app *fiber.App, cfg config.ServerConfig) error {
+	tls := cfg.TLS
+	switch {
+	case tls.ClientCAFile != "":
+		return app.ListenMutualTLS(Address(cfg), tls.CertFile, tls.KeyFile, tls.ClientCAFile)
+	case tls.Enabled():
+		return app.ListenTLS(Address(cfg), tls.CertFile, tls.KeyFile)
+	}
+	return app.Listen(Address(cfg))
+}
@@ -0,0 +1,34 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	cfg := config.Defaults().Server
+	cfg.ReadTimeout = 5 * time.Second
+	cfg.BodyLimit = 1024
+
+	c := server.Config(cfg)
+	assert.Equal(t, 5*time.Second, c.ReadTimeout)
+	assert.Equal(t, 1024, c.BodyLimit)
+	// the forwarded address is ignored without trusted proxies
+	assert.False(t, c.EnableTrustedProxyCheck)
+	assert.Empty(t, c.ProxyHeader)
+
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+	c = server.Config(cfg)
+	assert.True(t, c.EnableTrustedProxyCheck)
+	assert.Equal(t, "X-Forwarded-For", c.ProxyHeader)
+
+	assert.Equal(t, "0.0.0.0:8080", server.Address(cfg))
+	assert.Nil(t, server.Compress(cfg.Compression))
+	cfg.Compression.Enabled = true
+	assert.NotNil(t, server.Compress(cfg.Compression))
+}
@@ -0,0 +1,80 @@
+package tenancy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"vngom/models/tenants"
+	"vngom/repo"
+
+	"gorm.io/gorm"
+)
+
+const defaultCacheTTL = time.Minute
+
+// Catalog looks tenants up in the catalog database. Found tenants are cached for
+// ttl, unknown names are not so a tenant is usable as soon as it is registered.
+type Catalog struct {
+	rf    repo.IRepoFactory
+	ttl   time.Duration
+	cache sync.Map // tenant name -> cacheEntry
+}
+
+type cacheEntry struct {
+	tenant  tenants.TenantInfo
+	expires time.Time
+}
+
+func NewCatalog(rf repo.IRepoFactory, ttl time.Duration) *Catalog {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return &Catalog{rf: rf, ttl: ttl}
+}
+
+// Lookup returns the tenant registered under name, repo.ErrInvalidTenant when
+// there is none or it is deleted
+func (c *Catalog) Lookup(name string) (*tenants.TenantInfo, error) {
+	if cached, ok := c.cache.Load(name); ok {
+		entry := cached.(cacheEntry)
+		if time.Now().Before(entry.expires) {
+			return &entry.tenant, nil
+		}
+	}
+	if !repo.IsValidName(name) {
+		return nil, repo.ErrInvalidTenant
+	}
+	catalog, err := c.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var tenant tenants.TenantInfo
+	err = catalog.GetDb().Where(map[string]interface{}{"name": name, "DeletedAt": nil}).First(&tenant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, repo.ErrInvalidTenant
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.cache.Store(name, cacheEntry{tenant: tenant, expires: time.Now().Add(c.ttl)})
+	return &tenant, nil
+}
+
+// Forget drops the cached entry of a tenant after it changed in the catalog
+func (c *Catalog) Forget(name string) {
+	c.cache.Delete(name)
+}
+
+// DbName resolves the database of a tenant, it is a repo.DbNameResolver
+func (c *Catalog) DbName(tenant string) (string, error) {
+	info, err := c.Lookup(tenant)
+	if err != nil {
+		return "", err
+	}
+	if info.DbTenant == "" {
+		// tenants registered before provisioning existed use a database named after them
+		return info.Name, nil
+	}
+	return info.DbTenant, nil
+}
@@ -0,0 +1,271 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"vngom/audittrail"
+	"vngom/datascope"
+	"vngom/models"
+	"vngom/models/tenants"
+	"vngom/tenantscope"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultPurgeRetention = 30 * 24 * time.Hour
+
+var (
+	ErrTenantNotFound    = errors.New("tenant not found")
+	ErrInvalidTransition = errors.New("tenant cannot make this transition in its current state")
+	ErrRetention         = errors.New("tenant is still in its retention period")
+)
+
+// List returns the tenants of the catalog ordered by name, deleted ones only when asked
+func (s *Service) List(ctx context.Context, includeDeleted bool) ([]tenants.TenantInfo, error) {
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	query := catalog.GetDb().WithContext(ctx).Order("name")
+	if !includeDeleted {
+		query = query.Where(map[string]interface{}{"DeletedAt": nil})
+	}
+	var ret []tenants.TenantInfo
+	return ret, query.Find(&ret).Error
+}
+
+// Get returns a tenant of the catalog, deleted or not
+func (s *Service) Get(ctx context.Context, name string) (*tenants.TenantInfo, error) {
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var tenant tenants.TenantInfo
+	err = catalog.GetDb().WithContext(ctx).Where("name = ?", name).First(&tenant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// Events returns the audit trail of a tenant, most recent first
+func (s *Service) Events(ctx context.Context, name string) ([]tenants.TenantEvent, error) {
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var ret []tenants.TenantEvent
+	err = catalog.GetDb().WithContext(ctx).Where("tenant = ?", name).Order("created_on DESC").Find(&ret).Error
+	return ret, err
+}
+
+// Suspend blocks every request of an active tenant, its data is kept
+func (s *Service) Suspend(ctx context.Context, name string, actor string, reason string) (*tenants.TenantInfo, error) {
+	return s.change(ctx, name, func(db *gorm.DB, tenant *tenants.TenantInfo) error {
+		if tenant.DeletedAt != nil || tenant.Status != tenants.StatusActive {
+			return ErrInvalidTransition
+		}
+		return s.transition(db, tenant, tenants.ActionSuspend, tenants.StatusInactive, reason, actor, nil)
+	})
+}
+
+// Reactivate makes a suspended or deleted, not yet purged, tenant active again
+func (s *Service) Reactivate(ctx context.Context, name string, actor string, reason string) (*tenants.TenantInfo, error) {
+	return s.change(ctx, name, func(db *gorm.DB, tenant *tenants.TenantInfo) error {
+		if tenant.DeletedAt == nil && tenant.Status != tenants.StatusInactive {
+			return ErrInvalidTransition
+		}
+		if tenant.Status != tenants.StatusInactive && tenant.Status != tenants.StatusActive {
+			// a tenant deleted before being provisioned has nothing to reactivate
+			return ErrInvalidTransition
+		}
+		err := s.transition(db, tenant, tenants.ActionReactivate, tenants.StatusActive, reason, actor, func(tx *gorm.DB) error {
+			return tx.Model(tenant).UpdateColumns(map[string]interface{}{"DeletedAt": nil, "DeletedBy": nil}).Error
+		})
+		if err == nil {
+			tenant.DeletedAt, tenant.DeletedBy = nil, nil
+		}
+		return err
+	})
+}
+
+// Delete soft-deletes a tenant, its requests get 404 and its database is kept
+// until it is purged after the retention period
+func (s *Service) Delete(ctx context.Context, name string, actor string, reason string) (*tenants.TenantInfo, error) {
+	return s.change(ctx, name, func(db *gorm.DB, tenant *tenants.TenantInfo) error {
+		if tenant.DeletedAt != nil {
+			return ErrInvalidTransition
+		}
+		now := time.Now().UTC()
+		err := s.transition(db, tenant, tenants.ActionDelete, tenant.Status, reason, actor, func(tx *gorm.DB) error {
+			return tx.Model(tenant).UpdateColumns(map[string]interface{}{"DeletedAt": now, "DeletedBy": actor}).Error
+		})
+		if err == nil {
+			tenant.DeletedAt, tenant.DeletedBy = &now, &actor
+		}
+		return err
+	})
+}
+
+// Purge drops the database of a tenant deleted for longer than the retention
+// period, or its rows of the shared database, and removes it from the catalog.
+// Its events are kept.
+func (s *Service) Purge(ctx context.Context, name string, actor string) error {
+	_, err := s.change(ctx, name, func(db *gorm.DB, tenant *tenants.TenantInfo) error {
+		if tenant.DeletedAt == nil {
+			return ErrInvalidTransition
+		}
+		if time.Since(*tenant.DeletedAt) < s.retention() {
+			return ErrRetention
+		}
+		if tenant.Isolation == tenants.IsolationShared {
+			if err := s.purgeShared(ctx, tenant); err != nil {
+				return fmt.Errorf("failed to delete the rows of %s: %w", tenant.Name, err)
+			}
+		} else if tenant.DbTenant != "" {
+			if err := s.rf.DropDatabase(tenant.Name, tenant.DbTenant); err != nil {
+				return fmt.Errorf("failed to drop database %s: %w", tenant.DbTenant, err)
+			}
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := s.record(tx, tenant, tenants.ActionPurge, tenant.Status, tenant.Status, "", actor); err != nil {
+				return err
+			}
+			return tx.Delete(tenant).Error
+		})
+		s.forget(tenant)
+		return err
+	})
+	return err
+}
+
+// purgeShared deletes the rows of the tenant from every table of the shared database
+func (s *Service) purgeShared(ctx context.Context, tenant *tenants.TenantInfo) error {
+	r, err := s.rf.GetDatabase(tenant.Name, tenant.DbTenant)
+	if err != nil {
+		return err
+	}
+	// every row of the tenant goes, whichever department it belongs to, its audit trail with it
+	ctx = datascope.WithScope(tenantscope.WithTenant(ctx, tenant.Name), datascope.Unrestricted)
+	ctx = audittrail.WithPurge(ctx)
+	db := r.GetDb().WithContext(ctx)
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range models.TenantModels() {
+			// the scope of the plugin limits the delete to the rows of the tenant
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PurgeExpired purges every tenant whose retention period is over and returns their names.
+// It goes on after a failure and returns the failures joined.
+func (s *Service) PurgeExpired(ctx context.Context, actor string) ([]string, error) {
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	err = catalog.GetDb().WithContext(ctx).Model(&tenants.TenantInfo{}).
+		Where(clause.Lte{Column: clause.Column{Name: "DeletedAt"}, Value: time.Now().UTC().Add(-s.retention())}).
+		Order("name").Pluck("name", &names).Error
+	if err != nil {
+		return nil, err
+	}
+	var purged []string
+	var errs []error
+	for _, name := range names {
+		if err := s.Purge(ctx, name, actor); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+			continue
+		}
+		purged = append(purged, name)
+	}
+	return purged, errors.Join(errs...)
+}
+
+func (s *Service) retention() time.Duration {
+	if r := s.cfg.GetPlatformConfig().PurgeRetention; r > 0 {
+		return r
+	}
+	return defaultPurgeRetention
+}
+
+// change loads a tenant from the catalog and applies fn to it
+func (s *Service) change(ctx context.Context, name string, fn func(db *gorm.DB, tenant *tenants.TenantInfo) error) (*tenants.TenantInfo, error) {
+	tenant, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(catalog.GetDb().WithContext(ctx), tenant); err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// transition sets the status of a tenant, applies the other changes of update
+// and records the event in one transaction
+func (s *Service) transition(db *gorm.DB, tenant *tenants.TenantInfo, action string, status int, reason string, actor string, update func(tx *gorm.DB) error) error {
+	now := time.Now().UTC()
+	from := tenant.Status
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(tenant).UpdateColumns(map[string]interface{}{
+			"Status":      status,
+			"modified_on": now,
+			"modified_by": actor,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if update != nil {
+			if err := update(tx); err != nil {
+				return err
+			}
+		}
+		return s.record(tx, tenant, action, from, status, reason, actor)
+	})
+	s.forget(tenant)
+	if err != nil {
+		return err
+	}
+	tenant.Status = status
+	tenant.ModifiedOn = &now
+	tenant.ModifiedBy = actor
+	return nil
+}
+
+// record writes the audit event of a transition
+func (s *Service) record(tx *gorm.DB, tenant *tenants.TenantInfo, action string, from int, to int, reason string, actor string) error {
+	return tx.Create(&tenants.TenantEvent{
+		ID:         uuid.New(),
+		TenantID:   tenant.ID,
+		Tenant:     tenant.Name,
+		Action:     action,
+		FromStatus: from,
+		ToStatus:   to,
+		Reason:     reason,
+		Actor:      actor,
+		CreatedOn:  time.Now().UTC(),
+	}).Error
+}
+
+// forget drops the cached catalog entry of a tenant once it changed
+func (s *Service) forget(tenant *tenants.TenantInfo) {
+	if s.catalog != nil {
+		s.catalog.Forget(tenant.Name)
+	}
+}
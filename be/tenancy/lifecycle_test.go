@@ -0,0 +1,77 @@
+package tenancy_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vngom/models/tenants"
+	"vngom/tenancy"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycle(t *testing.T) {
+	_, catalog, svc, _ := setupWithCatalog(t)
+	ctx := context.Background()
+	_, err := svc.Provision(ctx, tenancy.ProvisionRequest{Name: "umbrella", AdminUsername: "admin", AdminEmail: "admin@umbrella.example", AdminPassword: "correct horse battery"})
+	assert.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/api/:tenant/ping", func(c *fiber.Ctx) error {
+		if _, err := tenancy.NewResolver(catalog, tenancy.PathStrategy()).Resolve(c); err != nil {
+			return err
+		}
+		return c.SendString("pong")
+	})
+	status := func() int {
+		res, err := app.Test(httptest.NewRequest("GET", "/api/umbrella/ping", nil))
+		assert.NoError(t, err)
+		return res.StatusCode
+	}
+	assert.Equal(t, fiber.StatusOK, status())
+
+	tenant, err := svc.Suspend(ctx, "umbrella", "support", "unpaid invoice")
+	assert.NoError(t, err)
+	assert.Equal(t, tenants.StatusInactive, tenant.Status)
+	assert.Equal(t, fiber.StatusLocked, status())
+	_, err = svc.Suspend(ctx, "umbrella", "support", "")
+	assert.ErrorIs(t, err, tenancy.ErrInvalidTransition)
+
+	_, err = svc.Reactivate(ctx, "umbrella", "support", "paid")
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, status())
+
+	tenant, err = svc.Delete(ctx, "umbrella", "sales", "churned")
+	assert.NoError(t, err)
+	assert.NotNil(t, tenant.DeletedAt)
+	assert.Equal(t, fiber.StatusNotFound, status())
+	active, err := svc.List(ctx, false)
+	assert.NoError(t, err)
+	assert.Empty(t, active)
+	all, err := svc.List(ctx, true)
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	assert.ErrorIs(t, svc.Purge(ctx, "umbrella", "sales"), tenancy.ErrRetention)
+	time.Sleep(60 * time.Millisecond)
+	purged, err := svc.PurgeExpired(ctx, "sales")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"umbrella"}, purged)
+	assert.Contains(t, dropped, "tenant_umbrella")
+	_, err = svc.Get(ctx, "umbrella")
+	assert.ErrorIs(t, err, tenancy.ErrTenantNotFound)
+
+	events, err := svc.Events(ctx, "umbrella")
+	assert.NoError(t, err)
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	assert.ElementsMatch(t, []string{
+		tenants.ActionProvision, tenants.ActionProvision,
+		tenants.ActionSuspend, tenants.ActionReactivate, tenants.ActionDelete, tenants.ActionPurge,
+	}, actions)
+}
@@ -0,0 +1,254 @@
+// tenancy manages the tenants listed in the catalog database: provisioning
+// of their database and the mapping of a tenant to its database.
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"vngom/authz"
+	"vngom/config"
+	"vngom/mailer"
+	"vngom/migrations"
+	"vngom/models/account"
+	"vngom/models/rbac"
+	"vngom/models/tenants"
+	"vngom/password"
+	"vngom/recovery"
+	"vngom/repo"
+	"vngom/tenantscope"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidName    = errors.New("tenant name must start with a letter, contain only letters, digits and underscores and have at most 56 characters")
+	ErrNameTaken      = errors.New("tenant name differs only by case from an existing tenant")
+	ErrAdminRequired  = errors.New("admin username and email are required")
+	ErrTenantDeleted  = errors.New("tenant is deleted")
+	ErrIsolation      = errors.New("isolation must be database or shared")
+	ErrSharedDisabled = errors.New("shared tenants require tenancy.sharedDatabase")
+)
+
+// ProvisionRequest describes a new tenant and its first administrator.
+// Without AdminPassword the administrator is invited by email to choose one.
+// Isolation is tenants.IsolationDatabase, the default, or tenants.IsolationShared.
+type ProvisionRequest struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Isolation     string `json:"isolation"`
+	AdminUsername string `json:"adminUsername"`
+	AdminEmail    string `json:"adminEmail"`
+	AdminPassword string `json:"adminPassword"`
+	CreatedBy     string `json:"-"`
+}
+
+// Service provisions tenants and manages their lifecycle. Every change of status
+// is recorded as a TenantEvent and drops the cached catalog entry of the tenant.
+type Service struct {
+	rf      repo.IRepoFactory
+	catalog *Catalog
+	cfg     config.IConfig
+	mailer  mailer.Mailer
+}
+
+func NewService(rf repo.IRepoFactory, catalog *Catalog, cfg config.IConfig, m mailer.Mailer) *Service {
+	return &Service{rf: rf, catalog: catalog, cfg: cfg, mailer: m}
+}
+
+// MigrateCatalog applies the pending migrations of the catalog database
+func MigrateCatalog(ctx context.Context, rf repo.IRepoFactory) error {
+	catalog, err := rf.GetCatalog()
+	if err != nil {
+		return err
+	}
+	_, err = migrations.Apply(ctx, catalog.GetDb(), migrations.Catalog)
+	return err
+}
+
+// Provision registers a tenant in the catalog, creates and migrates its database,
+// seeds the default roles and its administrator then marks it Active.
+// A failed or interrupted provisioning is resumed by calling it again.
+func (s *Service) Provision(ctx context.Context, req ProvisionRequest) (*tenants.TenantInfo, error) {
+	if !repo.IsValidName(req.Name) || len(req.Name) > maxNameLength {
+		return nil, ErrInvalidName
+	}
+	if req.AdminUsername == "" || req.AdminEmail == "" {
+		return nil, ErrAdminRequired
+	}
+	dbName, err := s.dbNameOf(&req)
+	if err != nil {
+		return nil, err
+	}
+	if req.AdminPassword != "" {
+		if err := password.CheckPolicy(s.cfg.GetPasswordConfig(), req.AdminPassword, req.AdminUsername); err != nil {
+			return nil, err
+		}
+	}
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := s.register(catalog.GetDb(), req, dbName)
+	if err != nil {
+		return nil, err
+	}
+	if tenant.Status == tenants.StatusActive {
+		return tenant, nil
+	}
+	if err := s.provision(ctx, tenant, req); err != nil {
+		statusErr := s.transition(catalog.GetDb(), tenant, tenants.ActionProvision, tenants.StatusFailed, err.Error(), req.CreatedBy, func(tx *gorm.DB) error {
+			return tx.Model(tenant).UpdateColumn("last_error", err.Error()).Error
+		})
+		if statusErr != nil {
+			return nil, errors.Join(err, statusErr)
+		}
+		tenant.LastError = err.Error()
+		return tenant, fmt.Errorf("provisioning of tenant %s failed: %w", tenant.Name, err)
+	}
+	err = s.transition(catalog.GetDb(), tenant, tenants.ActionProvision, tenants.StatusActive, "", req.CreatedBy, func(tx *gorm.DB) error {
+		return tx.Model(tenant).UpdateColumn("last_error", "").Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	tenant.LastError = ""
+	return tenant, nil
+}
+
+// register finds or creates the catalog entry of the tenant, in Provisioning status
+func (s *Service) register(db *gorm.DB, req ProvisionRequest, dbName string) (*tenants.TenantInfo, error) {
+	var tenant tenants.TenantInfo
+	err := db.Where("name = ?", req.Name).First(&tenant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Acme and acme would share the database tenant_acme, and be confused
+		// by case insensitive lookups of the rows of a shared database
+		taken := db.Model(&tenants.TenantInfo{}).Where("LOWER(name) = ?", strings.ToLower(req.Name))
+		if req.Isolation == tenants.IsolationDatabase {
+			taken = taken.Or("db_tenant = ?", dbName)
+		}
+		var owners int64
+		if err := taken.Count(&owners).Error; err != nil {
+			return nil, err
+		}
+		if owners > 0 {
+			return nil, ErrNameTaken
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		tenant = tenants.TenantInfo{
+			ID:          uuid.New(),
+			Name:        req.Name,
+			Description: req.Description,
+			Status:      tenants.StatusProvisioning,
+			DbTenant:    dbName,
+			Isolation:   req.Isolation,
+			CreatedOn:   time.Now().UTC(),
+			CreatedBy:   req.CreatedBy,
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&tenant).Error; err != nil {
+				return err
+			}
+			return s.record(tx, &tenant, tenants.ActionProvision, tenants.StatusProvisioning, tenants.StatusProvisioning, "", req.CreatedBy)
+		})
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			// registered concurrently, resume with the existing entry
+			err = db.Where("name = ?", req.Name).First(&tenant).Error
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tenant.DeletedAt != nil {
+		return nil, ErrTenantDeleted
+	}
+	if tenant.Status == tenants.StatusInactive {
+		return nil, ErrInvalidTransition
+	}
+	if tenant.Status == tenants.StatusFailed {
+		if err := s.transition(db, &tenant, tenants.ActionProvision, tenants.StatusProvisioning, "retry", req.CreatedBy, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &tenant, nil
+}
+
+func (s *Service) provision(ctx context.Context, tenant *tenants.TenantInfo, req ProvisionRequest) error {
+	if err := s.rf.CreateDatabase(tenant.Name, tenant.DbTenant); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", tenant.DbTenant, err)
+	}
+	r, err := s.rf.Get(tenant.Name)
+	if err != nil {
+		return err
+	}
+	// the schema of a shared database belongs to no tenant, the seeds belong to this one
+	if _, err := migrations.Apply(ctx, r.GetDb(), migrations.Tenant); err != nil {
+		return err
+	}
+	db := r.GetDb().WithContext(tenantscope.WithTenant(ctx, tenant.Name))
+	if err := authz.SeedDefaultRoles(db); err != nil {
+		return fmt.Errorf("failed to seed roles: %w", err)
+	}
+	return s.seedAdmin(ctx, db, tenant, req)
+}
+
+// seedAdmin creates the administrator account once and grants it the tenant-admin role
+func (s *Service) seedAdmin(ctx context.Context, db *gorm.DB, tenant *tenants.TenantInfo, req ProvisionRequest) error {
+	var admin account.Account
+	err := db.Where("username = ?", req.AdminUsername).First(&admin).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		admin = account.Account{Username: req.AdminUsername, Email: req.AdminEmail}
+		admin.ID = uuid.New()
+		admin.CreatedOn = time.Now().UTC()
+		admin.CreatedBy = req.CreatedBy
+		err = db.Create(&admin).Error
+	}
+	if err != nil {
+		return err
+	}
+	var role rbac.Role
+	if err := db.Where(&rbac.Role{Code: authz.TenantAdminRole}).First(&role).Error; err != nil {
+		return err
+	}
+	if err := authz.AssignRole(db, admin.ID, role.ID); err != nil {
+		return err
+	}
+	if admin.Password != "" {
+		return nil
+	}
+	if req.AdminPassword != "" {
+		return password.NewService(s.cfg.GetPasswordConfig()).SetPassword(db, &admin, req.AdminPassword, req.CreatedBy)
+	}
+	return recovery.NewService(s.cfg, s.mailer).Invite(ctx, db, tenant.Name, &admin)
+}
+
+// dbNameOf returns the database of the tenant to provision and defaults its isolation
+func (s *Service) dbNameOf(req *ProvisionRequest) (string, error) {
+	switch req.Isolation {
+	case "", tenants.IsolationDatabase:
+		req.Isolation = tenants.IsolationDatabase
+		return DbNameOf(req.Name), nil
+	case tenants.IsolationShared:
+		shared := s.cfg.GetTenancyConfig().SharedDatabase
+		if shared == "" {
+			return "", ErrSharedDisabled
+		}
+		return shared, nil
+	}
+	return "", ErrIsolation
+}
+
+const dbNamePrefix = "tenant_"
+
+// maxNameLength keeps the database of a tenant within the 63 characters accepted by repo.IsValidName
+const maxNameLength = 63 - len(dbNamePrefix)
+
+// DbNameOf returns the database name given to a new tenant
+func DbNameOf(name string) string {
+	return dbNamePrefix + strings.ToLower(name)
+}
@@ -0,0 +1,232 @@
+package tenancy_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"vngom/audittrail"
+	"vngom/authz"
+	"vngom/config"
+	"vngom/datascope"
+	"vngom/mailer"
+	"vngom/models/account"
+	"vngom/models/bases"
+	"vngom/models/tenants"
+	"vngom/repo"
+	"vngom/tenancy"
+	"vngom/tenantscope"
+	"vngom/tracing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var (
+	errBroken = errors.New("disk full")
+	dropped   []string
+	// opened lists the databases opened as host/name
+	opened []string
+)
+
+func init() {
+	repo.RegisterDialect("sqlite", repo.Dialect{
+		Open: func(conn repo.Connection, dbName string) (gorm.Dialector, error) {
+			opened = append(opened, conn.Host+"/"+dbName)
+			return sqlite.Open("file:" + dbName + "?mode=memory&cache=shared"), nil
+		},
+		// in memory databases exist once opened, names containing "broken" fail
+		CreateDatabase: func(db *gorm.DB, dbName string) error {
+			if strings.Contains(dbName, "broken") {
+				return errBroken
+			}
+			return nil
+		},
+		DropDatabase: func(db *gorm.DB, dbName string) error {
+			dropped = append(dropped, dbName)
+			return nil
+		},
+		ServerDatabase: "server",
+	})
+}
+
+func setup(t *testing.T) (repo.IRepoFactory, *tenancy.Service, *mailer.MemoryMailer) {
+	f, _, svc, m := setupWithCatalog(t)
+	return f, svc, m
+}
+
+func setupWithCatalog(t *testing.T) (repo.IRepoFactory, *tenancy.Catalog, *tenancy.Service, *mailer.MemoryMailer) {
+	prefix := strings.ReplaceAll(t.Name(), "/", "_")
+	f := repo.NewRepoFactory("sqlite")
+	f.ConfigCatalog(prefix+"_catalog", "")
+	// the plugins of main, their scopes apply to the service as in production
+	f.Use(bases.NewPlugin(), datascope.NewPlugin(), audittrail.NewPlugin(), tracing.NewPlugin())
+	f.UseFor(prefix+"_shared", tenantscope.NewPlugin())
+	catalog := tenancy.NewCatalog(f, 0)
+	f.SetDbNameResolver(catalog.DbName)
+	t.Cleanup(func() { f.Close() })
+	if err := tenancy.MigrateCatalog(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Mail:     config.MailConfig{From: "hr@example.com", LinkBaseURL: "https://hr.example.com"},
+		Password: config.PasswordConfig{MinLength: 10},
+		Platform: config.PlatformConfig{PurgeRetention: 50 * time.Millisecond},
+		Tenancy:  config.TenancyConfig{SharedDatabase: prefix + "_shared"},
+	}
+	m := mailer.NewMemoryMailer()
+	return f, catalog, tenancy.NewService(f, catalog, cfg, m), m
+}
+
+func TestProvision(t *testing.T) {
+	f, svc, m := setup(t)
+	req := tenancy.ProvisionRequest{Name: "Acme", AdminUsername: "admin", AdminEmail: "admin@acme.example", AdminPassword: "correct horse battery"}
+
+	tenant, err := svc.Provision(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, tenants.StatusActive, tenant.Status)
+	assert.Equal(t, "tenant_acme", tenant.DbTenant)
+	assert.Empty(t, m.Messages())
+
+	r, err := f.Get("Acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant_acme", r.GetDbName())
+	var admin account.Account
+	assert.NoError(t, r.GetDb().Where("username = ?", "admin").First(&admin).Error)
+	assert.NotEmpty(t, admin.Password)
+	permissions, err := authz.GetPermissions(r.GetDb(), admin.ID)
+	assert.NoError(t, err)
+	assert.Contains(t, permissions, authz.PermAll)
+
+	// provisioning again is a no-op
+	again, err := svc.Provision(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, tenant.ID, again.ID)
+
+	_, err = f.Get("Unknown")
+	assert.ErrorIs(t, err, repo.ErrInvalidTenant)
+
+	// another tenant cannot take the same database
+	other := req
+	other.Name = "ACME"
+	_, err = svc.Provision(context.Background(), other)
+	assert.ErrorIs(t, err, tenancy.ErrNameTaken)
+	catalog, err := f.GetCatalog()
+	assert.NoError(t, err)
+	var count int64
+	catalog.GetDb().Model(&tenants.TenantInfo{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// the database name of the tenant stays a valid identifier
+	other.Name = "a" + strings.Repeat("b", 56)
+	_, err = svc.Provision(context.Background(), other)
+	assert.ErrorIs(t, err, tenancy.ErrInvalidName)
+}
+
+func TestProvisionInvitesAdminWithoutPassword(t *testing.T) {
+	_, svc, m := setup(t)
+	_, err := svc.Provision(context.Background(), tenancy.ProvisionRequest{Name: "Globex", AdminUsername: "admin", AdminEmail: "admin@globex.example"})
+	assert.NoError(t, err)
+	if assert.Len(t, m.Messages(), 1) {
+		assert.Equal(t, []string{"admin@globex.example"}, m.Messages()[0].To)
+	}
+}
+
+func TestProvisionFailureIsRecordedAndRetried(t *testing.T) {
+	f, svc, _ := setup(t)
+	req := tenancy.ProvisionRequest{Name: "broken", AdminUsername: "admin", AdminEmail: "admin@broken.example", AdminPassword: "correct horse battery"}
+
+	tenant, err := svc.Provision(context.Background(), req)
+	assert.ErrorIs(t, err, errBroken)
+	assert.Equal(t, tenants.StatusFailed, tenant.Status)
+	catalog, err := f.GetCatalog()
+	assert.NoError(t, err)
+	var stored tenants.TenantInfo
+	assert.NoError(t, catalog.GetDb().Where("name = ?", "broken").First(&stored).Error)
+	assert.Equal(t, tenants.StatusFailed, stored.Status)
+	assert.Contains(t, stored.LastError, errBroken.Error())
+
+	// the retry resumes with the same catalog entry
+	_, err = svc.Provision(context.Background(), req)
+	assert.ErrorIs(t, err, errBroken)
+	var count int64
+	catalog.GetDb().Model(&tenants.TenantInfo{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	_, err = svc.Provision(context.Background(), tenancy.ProvisionRequest{Name: "no-dash", AdminUsername: "a", AdminEmail: "a@example.com"})
+	assert.ErrorIs(t, err, tenancy.ErrInvalidName)
+}
+
+func TestProvisionOnAnotherServer(t *testing.T) {
+	f, svc, _ := setup(t)
+	ctx := context.Background()
+	f.SetConnectionResolver(func(tenant string, conn repo.Connection) (repo.Connection, error) {
+		if tenant == "hooli" {
+			conn.Host = "db-large.internal"
+		}
+		return conn, nil
+	})
+	opened = nil
+	_, err := svc.Provision(ctx, tenancy.ProvisionRequest{Name: "hooli", AdminUsername: "admin", AdminEmail: "admin@hooli.example", AdminPassword: "correct horse battery"})
+	assert.NoError(t, err)
+	// the database is created and migrated on the server of the tenant
+	assert.Equal(t, []string{"db-large.internal/server", "db-large.internal/tenant_hooli"}, opened)
+
+	_, err = svc.Delete(ctx, "hooli", "sales", "churned")
+	assert.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	opened = nil
+	assert.NoError(t, svc.Purge(ctx, "hooli", "sales"))
+	assert.Contains(t, dropped, "tenant_hooli")
+	assert.Equal(t, []string{"db-large.internal/server"}, opened)
+}
+
+func TestProvisionShared(t *testing.T) {
+	f, svc, _ := setup(t)
+	ctx := context.Background()
+	for _, name := range []string{"wayne", "stark"} {
+		tenant, err := svc.Provision(ctx, tenancy.ProvisionRequest{Name: name, Isolation: tenants.IsolationShared, AdminUsername: "admin", AdminEmail: "admin@" + name + ".example", AdminPassword: "correct horse battery"})
+		assert.NoError(t, err)
+		assert.Equal(t, t.Name()+"_shared", tenant.DbTenant)
+		assert.Equal(t, tenants.IsolationShared, tenant.Isolation)
+	}
+	// names differing by case are refused in a shared database too
+	_, err := svc.Provision(ctx, tenancy.ProvisionRequest{Name: "Wayne", Isolation: tenants.IsolationShared, AdminUsername: "admin", AdminEmail: "admin@wayne.example", AdminPassword: "correct horse battery"})
+	assert.ErrorIs(t, err, tenancy.ErrNameTaken)
+
+	// both tenants have an admin, each one only sees its own
+	r, err := f.Get("wayne")
+	assert.NoError(t, err)
+	var accounts []account.Account
+	assert.NoError(t, r.GetDb().Find(&accounts).Error)
+	if assert.Len(t, accounts, 1) {
+		assert.Equal(t, "admin@wayne.example", accounts[0].Email)
+	}
+	roles, err := authz.GetAccountRoles(r.GetDb(), accounts[0].ID)
+	assert.NoError(t, err)
+	assert.Len(t, roles, 1)
+
+	_, err = svc.Delete(ctx, "wayne", "sales", "churned")
+	assert.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, svc.Purge(ctx, "wayne", "sales"))
+	assert.NotContains(t, dropped, t.Name()+"_shared")
+
+	shared, err := f.GetDatabase("stark", t.Name()+"_shared")
+	assert.NoError(t, err)
+	count := func(table string, tenant string) (n int64) {
+		shared.GetDb().Table(table).Where("tenant_id = ?", tenant).Count(&n)
+		return n
+	}
+	// the audit trail of the admin account goes with the tenant
+	assert.Equal(t, int64(0), count("accounts", "wayne"))
+	assert.Equal(t, int64(0), count("AuditLog", "wayne"))
+	assert.Equal(t, int64(1), count("accounts", "stark"))
+	assert.NotZero(t, count("AuditLog", "stark"))
+
+	_, err = svc.Provision(ctx, tenancy.ProvisionRequest{Name: "oscorp", Isolation: "cloud", AdminUsername: "a", AdminEmail: "a@example.com"})
+	assert.ErrorIs(t, err, tenancy.ErrIsolation)
+}
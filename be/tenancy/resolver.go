@@ -0,0 +1,148 @@
+package tenancy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"vngom/config"
+	"vngom/models/tenants"
+	"vngom/repo"
+	"vngom/security"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultTenantHeader = "X-Tenant-ID"
+
+var (
+	ErrTenantRequired  = errors.New("tenant is not specified")
+	ErrTenantInactive  = errors.New("tenant is not active")
+	ErrTenantSuspended = errors.New("tenant is suspended")
+)
+
+// Strategy finds the tenant name of a request, it returns "" when the request does not carry it
+type Strategy func(c *fiber.Ctx) string
+
+// PathStrategy reads the :tenant segment of the route
+func PathStrategy() Strategy {
+	return func(c *fiber.Ctx) string {
+		return c.Params("tenant")
+	}
+}
+
+// SubdomainStrategy reads the label before baseDomain, acme.hr.example.com gives acme
+func SubdomainStrategy(baseDomain string) Strategy {
+	suffix := "." + strings.ToLower(strings.Trim(baseDomain, "."))
+	return func(c *fiber.Ctx) string {
+		host := strings.ToLower(c.Hostname())
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+		sub, ok := strings.CutSuffix(host, suffix)
+		if !ok || strings.Contains(sub, ".") {
+			return ""
+		}
+		return sub
+	}
+}
+
+// HeaderStrategy reads a request header
+func HeaderStrategy(header string) Strategy {
+	if header == "" {
+		header = defaultTenantHeader
+	}
+	return func(c *fiber.Ctx) string {
+		return strings.TrimSpace(c.Get(header))
+	}
+}
+
+// JWTStrategy reads the tenant claim of the access token, security.Authenticate has to run first
+func JWTStrategy() Strategy {
+	return func(c *fiber.Ctx) string {
+		if claims := security.GetClaims(c); claims != nil {
+			return claims.Tenant
+		}
+		return ""
+	}
+}
+
+// Resolver finds the tenant of a request and checks it in the catalog.
+// It implements fiber_wrapper.TenantResolver.
+type Resolver struct {
+	strategies []Strategy
+	catalog    *Catalog
+}
+
+func NewResolver(catalog *Catalog, strategies ...Strategy) *Resolver {
+	return &Resolver{strategies: strategies, catalog: catalog}
+}
+
+// NewResolverFromConfig builds the strategies listed in the configuration, the path one by default
+func NewResolverFromConfig(cfg config.TenancyConfig, catalog *Catalog) (*Resolver, error) {
+	names := cfg.Strategies
+	if len(names) == 0 {
+		names = []string{"path"}
+	}
+	strategies := make([]Strategy, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "path":
+			strategies = append(strategies, PathStrategy())
+		case "subdomain":
+			if cfg.BaseDomain == "" {
+				return nil, errors.New("the subdomain tenant strategy requires tenancy.baseDomain")
+			}
+			strategies = append(strategies, SubdomainStrategy(cfg.BaseDomain))
+		case "header":
+			strategies = append(strategies, HeaderStrategy(cfg.Header))
+		case "jwt":
+			strategies = append(strategies, JWTStrategy())
+		default:
+			return nil, fmt.Errorf("unknown tenant strategy %q", name)
+		}
+	}
+	return NewResolver(catalog, strategies...), nil
+}
+
+// BasePath is where the tenant routes are mounted, /api/:tenant when the path strategy is used
+func BasePath(cfg config.TenancyConfig) string {
+	if len(cfg.Strategies) == 0 {
+		return "/api/:tenant"
+	}
+	for _, name := range cfg.Strategies {
+		if strings.EqualFold(name, "path") {
+			return "/api/:tenant"
+		}
+	}
+	return "/api"
+}
+
+// Resolve returns the tenant of the request. Unknown and deleted tenants get 404,
+// suspended tenants 423 and tenants that are not provisioned yet 403.
+func (r *Resolver) Resolve(c *fiber.Ctx) (string, error) {
+	name := ""
+	for _, strategy := range r.strategies {
+		if name = strategy(c); name != "" {
+			break
+		}
+	}
+	if name == "" {
+		return "", fiber.NewError(fiber.StatusNotFound, ErrTenantRequired.Error())
+	}
+	tenant, err := r.catalog.Lookup(name)
+	if errors.Is(err, repo.ErrInvalidTenant) {
+		return "", fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return "", err
+	}
+	switch tenant.Status {
+	case tenants.StatusActive:
+	case tenants.StatusInactive:
+		return "", fiber.NewError(fiber.StatusLocked, ErrTenantSuspended.Error())
+	default:
+		return "", fiber.NewError(fiber.StatusForbidden, ErrTenantInactive.Error())
+	}
+	return tenant.Name, nil
+}
@@ -0,0 +1,78 @@
+package tenancy_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/models/tenants"
+	"vngom/tenancy"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver(t *testing.T) {
+	f, _, _ := setup(t)
+	catalog, err := f.GetCatalog()
+	assert.NoError(t, err)
+	deletedAt := time.Now().UTC()
+	for _, tenant := range []tenants.TenantInfo{
+		{Name: "acme", Status: tenants.StatusActive},
+		{Name: "globex", Status: tenants.StatusProvisioning},
+		{Name: "initech", Status: tenants.StatusActive, DeletedAt: &deletedAt},
+	} {
+		tenant.ID = uuid.New()
+		tenant.DbTenant = tenancy.DbNameOf(tenant.Name)
+		assert.NoError(t, catalog.GetDb().Create(&tenant).Error)
+	}
+
+	cfg := config.TenancyConfig{Strategies: []string{"subdomain", "header"}, BaseDomain: "hr.example.com"}
+	resolver, err := tenancy.NewResolverFromConfig(cfg, tenancy.NewCatalog(f, time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, "/api", tenancy.BasePath(cfg))
+
+	app := fiber.New()
+	app.Get("/api/whoami", func(c *fiber.Ctx) error {
+		tenant, err := resolver.Resolve(c)
+		if err != nil {
+			return err
+		}
+		return c.SendString(tenant)
+	})
+	for _, tc := range []struct {
+		host   string
+		header string
+		status int
+		body   string
+	}{
+		{host: "acme.hr.example.com", status: fiber.StatusOK, body: "acme"},
+		{host: "acme.hr.example.com:8080", status: fiber.StatusOK, body: "acme"},
+		{host: "localhost", header: "acme", status: fiber.StatusOK, body: "acme"},
+		{host: "globex.hr.example.com", status: fiber.StatusForbidden},
+		{host: "initech.hr.example.com", status: fiber.StatusNotFound},
+		{host: "unknown.hr.example.com", status: fiber.StatusNotFound},
+		{host: "a.b.hr.example.com", status: fiber.StatusNotFound},
+		{host: "localhost", status: fiber.StatusNotFound},
+	} {
+		req := httptest.NewRequest("GET", "/api/whoami", nil)
+		req.Host = tc.host
+		if tc.header != "" {
+			req.Header.Set("X-Tenant-ID", tc.header)
+		}
+		res, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.status, res.StatusCode, tc.host)
+		if tc.body != "" {
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, tc.body, string(body))
+		}
+	}
+
+	_, err = tenancy.NewResolverFromConfig(config.TenancyConfig{Strategies: []string{"cookie"}}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, "/api/:tenant", tenancy.BasePath(config.TenancyConfig{}))
+}
@@ -0,0 +1,285 @@
+package tenancy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"vngom/config"
+	"vngom/models/tenants"
+	"vngom/repo"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidSettings = errors.New("invalid tenant settings")
+	ErrStaleSettings   = errors.New("tenant settings were changed in the meantime, reload them")
+	ErrSharedDBHost    = errors.New("tenants of the shared database cannot have their own database server")
+)
+
+// ConfigChange is sent to the subscribers once the settings of a tenant changed
+type ConfigChange struct {
+	Tenant string
+	Old    config.TenantConfig
+	New    config.TenantConfig
+}
+
+// Settings stores the configuration overrides of the tenants in the catalog.
+// Every update is a new version, the overrides are cached for the TTL of the
+// catalog. Subscribers are notified of the updates made by this instance at
+// once, of the ones made by other instances when Watch polls the catalog.
+type Settings struct {
+	rf      repo.IRepoFactory
+	catalog *Catalog
+	cfg     config.IConfig
+	ttl     time.Duration
+	cache   sync.Map // tenant name -> settingsEntry
+
+	lock        sync.Mutex
+	subscribers []func(ConfigChange)
+	// seen is the last version of the settings of a tenant the subscribers were told of
+	seen map[string]seenSettings
+}
+
+type seenSettings struct {
+	version int
+	config  config.TenantConfig
+}
+
+type settingsEntry struct {
+	overrides config.TenantOverrides
+	version   int
+	expires   time.Time
+}
+
+func NewSettings(rf repo.IRepoFactory, catalog *Catalog, cfg config.IConfig) *Settings {
+	ttl := cfg.GetTenancyConfig().CacheTTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	return &Settings{rf: rf, catalog: catalog, cfg: cfg, ttl: ttl, seen: map[string]seenSettings{}}
+}
+
+// Get returns the configuration of a tenant: the defaults with its overrides applied
+func (s *Settings) Get(tenant string) (config.TenantConfig, error) {
+	overrides, _, err := s.load(tenant)
+	if err != nil {
+		return config.TenantConfig{}, err
+	}
+	return overrides.Apply(s.cfg.GetTenantDefaults()), nil
+}
+
+// Current returns the overrides of a tenant and their version, 0 when it has none
+func (s *Settings) Current(ctx context.Context, tenant string) (config.TenantOverrides, int, error) {
+	if _, err := s.catalog.Lookup(tenant); err != nil {
+		return config.TenantOverrides{}, 0, notFound(err)
+	}
+	return s.read(ctx, tenant)
+}
+
+// Update stores overrides as the next version of the settings of a tenant.
+// version is the one the caller read, ErrStaleSettings is returned when
+// another update was made since.
+func (s *Settings) Update(ctx context.Context, tenant string, version int, overrides config.TenantOverrides, actor string) (int, error) {
+	if err := overrides.Validate(); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidSettings, err)
+	}
+	info, err := s.catalog.Lookup(tenant)
+	if err != nil {
+		return 0, notFound(err)
+	}
+	if overrides.OverridesDB() && info.Isolation == tenants.IsolationShared {
+		return 0, ErrSharedDBHost
+	}
+	old, err := s.Get(tenant)
+	if err != nil {
+		return 0, err
+	}
+	content, err := json.Marshal(overrides)
+	if err != nil {
+		return 0, err
+	}
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return 0, err
+	}
+	err = catalog.GetDb().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current int
+		err := tx.Model(&tenants.TenantSetting{}).Where("tenant = ?", tenant).
+			Select("COALESCE(MAX(version), 0)").Scan(&current).Error
+		if err != nil {
+			return err
+		}
+		if current != version {
+			return ErrStaleSettings
+		}
+		return tx.Create(&tenants.TenantSetting{
+			Tenant:     tenant,
+			Version:    version + 1,
+			Overrides:  string(content),
+			ModifiedOn: time.Now().UTC(),
+			ModifiedBy: actor,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// another instance stored the same version first
+		err = ErrStaleSettings
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.cache.Delete(tenant)
+	current := overrides.Apply(s.cfg.GetTenantDefaults())
+	if known, ok := s.see(tenant, version+1, current, old); ok {
+		s.notify(ConfigChange{Tenant: tenant, Old: known, New: current})
+	}
+	return version + 1, nil
+}
+
+// Watch polls the catalog every TTL until ctx is done and notifies the
+// subscribers of the updates made by other instances
+func (s *Settings) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.ttl)
+	defer ticker.Stop()
+	for {
+		if err := s.Poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Default().Warn("failed to poll the tenant settings", "logger", "tenancy", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll notifies the subscribers of the settings changed since the last poll or update
+func (s *Settings) Poll(ctx context.Context) error {
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return err
+	}
+	var latest []struct {
+		Tenant  string
+		Version int
+	}
+	err = catalog.GetDb().WithContext(ctx).Model(&tenants.TenantSetting{}).
+		Select("tenant, MAX(version) AS version").Group("tenant").Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+	defaults := s.cfg.GetTenantDefaults()
+	for _, l := range latest {
+		s.lock.Lock()
+		seen, ok := s.seen[l.Tenant]
+		s.lock.Unlock()
+		if ok && seen.version >= l.Version {
+			continue
+		}
+		overrides, version, err := s.read(ctx, l.Tenant)
+		if err != nil {
+			return err
+		}
+		s.cache.Delete(l.Tenant)
+		// a tenant not seen yet is told as changed from the defaults
+		current := overrides.Apply(defaults)
+		if old, ok := s.see(l.Tenant, version, current, defaults); ok {
+			s.notify(ConfigChange{Tenant: l.Tenant, Old: old, New: current})
+		}
+	}
+	return nil
+}
+
+// see records version as the one told to the subscribers unless a later one was.
+// It returns the configuration they knew, unseen for a tenant they were not told of.
+func (s *Settings) see(tenant string, version int, current config.TenantConfig, unseen config.TenantConfig) (config.TenantConfig, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	seen, ok := s.seen[tenant]
+	if ok && seen.version >= version {
+		return config.TenantConfig{}, false
+	}
+	s.seen[tenant] = seenSettings{version: version, config: current}
+	if ok {
+		return seen.config, true
+	}
+	return unseen, true
+}
+
+// Subscribe calls fn after every update of the settings of a tenant
+func (s *Settings) Subscribe(fn func(ConfigChange)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// ResolveConnection is a repo.ConnectionResolver placing the database of a
+// tenant on the server of its settings
+func (s *Settings) ResolveConnection(tenant string, conn repo.Connection) (repo.Connection, error) {
+	overrides, _, err := s.load(tenant)
+	if err != nil {
+		return conn, err
+	}
+	if overrides.DBHost != nil {
+		conn.Host = *overrides.DBHost
+	}
+	if overrides.DBPort != nil {
+		conn.Port = *overrides.DBPort
+	}
+	return conn, nil
+}
+
+func (s *Settings) notify(change ConfigChange) {
+	s.lock.Lock()
+	subscribers := append([]func(ConfigChange){}, s.subscribers...)
+	s.lock.Unlock()
+	for _, fn := range subscribers {
+		fn(change)
+	}
+}
+
+// load returns the cached overrides of a tenant
+func (s *Settings) load(tenant string) (config.TenantOverrides, int, error) {
+	if cached, ok := s.cache.Load(tenant); ok {
+		entry := cached.(settingsEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.overrides, entry.version, nil
+		}
+	}
+	overrides, version, err := s.read(context.Background(), tenant)
+	if err != nil {
+		return overrides, version, err
+	}
+	s.cache.Store(tenant, settingsEntry{overrides: overrides, version: version, expires: time.Now().Add(s.ttl)})
+	return overrides, version, nil
+}
+
+// read returns the last version of the overrides of a tenant from the catalog
+func (s *Settings) read(ctx context.Context, tenant string) (config.TenantOverrides, int, error) {
+	var overrides config.TenantOverrides
+	catalog, err := s.rf.GetCatalog()
+	if err != nil {
+		return overrides, 0, err
+	}
+	var setting tenants.TenantSetting
+	res := catalog.GetDb().WithContext(ctx).Where("tenant = ?", tenant).Order("version DESC").Limit(1).Find(&setting)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return overrides, 0, res.Error
+	}
+	if err := json.Unmarshal([]byte(setting.Overrides), &overrides); err != nil {
+		return overrides, 0, err
+	}
+	return overrides, setting.Version, nil
+}
+
+func notFound(err error) error {
+	if errors.Is(err, repo.ErrInvalidTenant) {
+		return ErrTenantNotFound
+	}
+	return err
+}
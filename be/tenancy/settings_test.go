@@ -0,0 +1,118 @@
+package tenancy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/repo"
+	"vngom/tenancy"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings(t *testing.T) {
+	f, catalog, svc, _ := setupWithCatalog(t)
+	ctx := context.Background()
+	_, err := svc.Provision(ctx, tenancy.ProvisionRequest{Name: "acme", AdminUsername: "admin", AdminEmail: "admin@acme.example", AdminPassword: "correct horse battery"})
+	assert.NoError(t, err)
+	cfg := &config.Config{
+		DB:             config.DBConfig{Host: "db.internal", Port: 5432},
+		Password:       config.PasswordConfig{MinLength: 10},
+		TenantDefaults: config.TenantDefaults{Locale: "vi-VN", Timezone: "Asia/Ho_Chi_Minh", Features: map[string]bool{"payroll": true}},
+	}
+	settings := tenancy.NewSettings(f, catalog, cfg)
+	var changes []tenancy.ConfigChange
+	settings.Subscribe(func(change tenancy.ConfigChange) {
+		changes = append(changes, change)
+	})
+
+	defaults, err := settings.Get("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "vi-VN", defaults.Locale)
+	assert.True(t, defaults.Feature("payroll"))
+
+	locale, host := "en-US", "db-large.internal"
+	version, err := settings.Update(ctx, "acme", 0, config.TenantOverrides{
+		Locale:   &locale,
+		Features: map[string]bool{"payroll": false, "recruiting": true},
+		Password: &config.PasswordConfig{MinLength: 14},
+		DBHost:   &host,
+	}, "support")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	acme, err := settings.Get("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "en-US", acme.Locale)
+	assert.Equal(t, "Asia/Ho_Chi_Minh", acme.Timezone)
+	assert.False(t, acme.Feature("payroll"))
+	assert.True(t, acme.Feature("recruiting"))
+	assert.Equal(t, 14, acme.Password.MinLength)
+	// the defaults are not changed by the overrides
+	assert.True(t, cfg.GetTenantDefaults().Feature("payroll"))
+	conn, err := settings.ResolveConnection("acme", repo.Connection{Host: "db.internal", Port: 5432})
+	assert.NoError(t, err)
+	assert.Equal(t, repo.Connection{Host: "db-large.internal", Port: 5432}, conn)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, "db.internal", changes[0].Old.DBHost)
+		assert.Equal(t, "db-large.internal", changes[0].New.DBHost)
+	}
+
+	// an update based on an old version is refused
+	_, err = settings.Update(ctx, "acme", 0, config.TenantOverrides{}, "support")
+	assert.ErrorIs(t, err, tenancy.ErrStaleSettings)
+	overrides, version, err := settings.Current(ctx, "acme")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+	assert.Equal(t, &locale, overrides.Locale)
+
+	zone := "Mars/Olympus"
+	_, err = settings.Update(ctx, "acme", 1, config.TenantOverrides{Timezone: &zone}, "support")
+	assert.ErrorIs(t, err, tenancy.ErrInvalidSettings)
+	_, err = settings.Update(ctx, "globex", 0, config.TenantOverrides{}, "support")
+	assert.ErrorIs(t, err, tenancy.ErrTenantNotFound)
+}
+
+func TestSettingsOfAnotherInstance(t *testing.T) {
+	f, catalog, svc, _ := setupWithCatalog(t)
+	ctx := context.Background()
+	_, err := svc.Provision(ctx, tenancy.ProvisionRequest{Name: "acme", AdminUsername: "admin", AdminEmail: "admin@acme.example", AdminPassword: "correct horse battery"})
+	assert.NoError(t, err)
+	cfg := &config.Config{DB: config.DBConfig{Host: "db.internal", Port: 5432}, Tenancy: config.TenancyConfig{CacheTTL: time.Hour}}
+	// two instances of the application sharing the catalog
+	here, there := tenancy.NewSettings(f, catalog, cfg), tenancy.NewSettings(f, catalog, cfg)
+	var changes []tenancy.ConfigChange
+	there.Subscribe(func(change tenancy.ConfigChange) {
+		changes = append(changes, change)
+	})
+	assert.NoError(t, there.Poll(ctx))
+	assert.Empty(t, changes)
+	before, err := there.Get("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "db.internal", before.DBHost)
+
+	host := "db-large.internal"
+	_, err = here.Update(ctx, "acme", 0, config.TenantOverrides{DBHost: &host}, "support")
+	assert.NoError(t, err)
+	assert.NoError(t, there.Poll(ctx))
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, "db.internal", changes[0].Old.DBHost)
+		assert.Equal(t, "db-large.internal", changes[0].New.DBHost)
+	}
+	// the cache of the other instance is refreshed before its TTL
+	after, err := there.Get("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "db-large.internal", after.DBHost)
+
+	// the updates are told once, the ones of the instance itself too
+	assert.NoError(t, there.Poll(ctx))
+	_, err = there.Update(ctx, "acme", 1, config.TenantOverrides{}, "support")
+	assert.NoError(t, err)
+	assert.NoError(t, there.Poll(ctx))
+	if assert.Len(t, changes, 2) {
+		assert.Equal(t, "db-large.internal", changes[1].Old.DBHost)
+		assert.Equal(t, "db.internal", changes[1].New.DBHost)
+	}
+}
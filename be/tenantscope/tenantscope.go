@@ -0,0 +1,179 @@
+// tenantscope isolates the tenants sharing a database. Every model with a
+// TenantID field is scoped to the tenant travelling in the statement context:
+// queries, updates and deletes only reach its rows and inserts are stamped
+// with it.
+//
+// The Plugin is only installed on shared databases. It fails closed: a
+// statement on a scoped model without a tenant in its context is refused, and
+// so is raw SQL issued on behalf of a tenant since it cannot be scoped.
+package tenantscope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Field is the name of the field holding the tenant of a row
+const Field = "TenantID"
+
+const pluginName = "tenantscope"
+
+var (
+	ErrNoTenant    = errors.New("statement on a shared database has no tenant")
+	ErrRawSQL      = errors.New("raw SQL cannot be scoped to a tenant of a shared database")
+	ErrCrossTenant = errors.New("row belongs to another tenant")
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context scoping the statements run with it to tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// FromContext returns the tenant of the context, "" when none was set
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// Plugin scopes the statements of a shared database to their tenant
+type Plugin struct{}
+
+func NewPlugin() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return pluginName
+}
+
+// Installed reports whether db is a shared database scoped by the Plugin
+func Installed(db *gorm.DB) bool {
+	_, ok := db.Config.Plugins[pluginName]
+	return ok
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenantscope:create", stamp); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenantscope:query", scope); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenantscope:row", scope); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenantscope:update", scopeUpdate); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("tenantscope:delete", scope); err != nil {
+		return err
+	}
+	return callbacks.Raw().Before("gorm:raw").Register("tenantscope:raw", refuseRaw)
+}
+
+// tenantField returns the TenantID field of the model and the tenant of the
+// statement, nil when the model is not scoped
+func tenantField(db *gorm.DB) (*schema.Field, string, bool) {
+	if db.Error != nil {
+		return nil, "", false
+	}
+	// Raw(...).Scan or Find run their own SQL, the clauses added here would be ignored
+	if db.Statement.SQL.Len() > 0 {
+		refuseRaw(db)
+		return nil, "", false
+	}
+	if db.Statement.Schema == nil {
+		return nil, "", false
+	}
+	field := db.Statement.Schema.LookUpField(Field)
+	if field == nil {
+		return nil, "", false
+	}
+	tenant := FromContext(db.Statement.Context)
+	if tenant == "" {
+		db.AddError(fmt.Errorf("%w: %s", ErrNoTenant, db.Statement.Schema.Table))
+		return nil, "", false
+	}
+	return field, tenant, true
+}
+
+func scope(db *gorm.DB) {
+	field, tenant, ok := tenantField(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenant},
+	}})
+}
+
+// stamp sets the tenant of the rows to insert, rows of another tenant are refused
+func stamp(db *gorm.DB) {
+	field, tenant, ok := tenantField(db)
+	if !ok {
+		return
+	}
+	setTenant(db, field, tenant)
+}
+
+func scopeUpdate(db *gorm.DB) {
+	field, tenant, ok := tenantField(db)
+	if !ok {
+		return
+	}
+	switch dest := db.Statement.Dest.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{field.Name, field.DBName} {
+			if value, found := dest[key]; found && value != tenant {
+				db.AddError(ErrCrossTenant)
+				return
+			}
+		}
+	default:
+		setTenant(db, field, tenant)
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenant},
+	}})
+}
+
+// setTenant writes tenant into the TenantID of the rows of the statement
+func setTenant(db *gorm.DB, field *schema.Field, tenant string) {
+	rv := db.Statement.ReflectValue
+	set := func(row reflect.Value) {
+		current, zero := field.ValueOf(db.Statement.Context, row)
+		if !zero && current != tenant {
+			db.AddError(ErrCrossTenant)
+			return
+		}
+		if err := field.Set(db.Statement.Context, row, tenant); err != nil {
+			db.AddError(err)
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			set(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		set(rv)
+	}
+}
+
+func refuseRaw(db *gorm.DB) {
+	if db.Error == nil && FromContext(db.Statement.Context) != "" {
+		db.AddError(ErrRawSQL)
+	}
+}
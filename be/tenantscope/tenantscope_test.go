@@ -0,0 +1,78 @@
+package tenantscope_test
+
+import (
+	"context"
+	"testing"
+
+	"vngom/tenantscope"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type note struct {
+	ID       uint
+	TenantID string
+	Text     string
+}
+
+func open(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&note{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(tenantscope.NewPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestScope(t *testing.T) {
+	db := open(t)
+	acme := db.WithContext(tenantscope.WithTenant(context.Background(), "acme"))
+	globex := db.WithContext(tenantscope.WithTenant(context.Background(), "globex"))
+
+	first := note{Text: "acme"}
+	assert.NoError(t, acme.Create(&first).Error)
+	assert.Equal(t, "acme", first.TenantID)
+	assert.NoError(t, globex.Create(&[]note{{Text: "globex"}, {Text: "globex"}}).Error)
+
+	var notes []note
+	assert.NoError(t, acme.Find(&notes).Error)
+	assert.Len(t, notes, 1)
+	var count int64
+	assert.NoError(t, globex.Model(&note{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+
+	// rows of another tenant cannot be reached by their key
+	assert.ErrorIs(t, globex.First(&note{}, first.ID).Error, gorm.ErrRecordNotFound)
+	res := globex.Model(&note{ID: first.ID}).Update("Text", "stolen")
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(0), res.RowsAffected)
+	res = globex.Delete(&note{}, first.ID)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, int64(0), res.RowsAffected)
+
+	// nor moved to another tenant
+	assert.ErrorIs(t, globex.Create(&note{TenantID: "acme"}).Error, tenantscope.ErrCrossTenant)
+	assert.ErrorIs(t, acme.Model(&first).Updates(map[string]interface{}{"TenantID": "globex"}).Error, tenantscope.ErrCrossTenant)
+	first.Text = "saved"
+	assert.NoError(t, acme.Save(&first).Error)
+	assert.Equal(t, "acme", first.TenantID)
+}
+
+func TestScopeFailsClosed(t *testing.T) {
+	db := open(t)
+	acme := db.WithContext(tenantscope.WithTenant(context.Background(), "acme"))
+
+	assert.ErrorIs(t, db.Find(&[]note{}).Error, tenantscope.ErrNoTenant)
+	assert.ErrorIs(t, db.Create(&note{Text: "orphan"}).Error, tenantscope.ErrNoTenant)
+	assert.ErrorIs(t, acme.Raw("SELECT * FROM notes").Scan(&[]note{}).Error, tenantscope.ErrRawSQL)
+	assert.ErrorIs(t, acme.Exec("DELETE FROM notes").Error, tenantscope.ErrRawSQL)
+	// raw SQL without tenant is the work of the platform, such as migrations
+	assert.NoError(t, db.Exec("DELETE FROM notes").Error)
+}
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"strings"
+
+	"vngom/security"
+	"vngom/tenantscope"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier reads and writes the trace context in the request headers
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (h headerCarrier) Get(key string) string {
+	return string(h.header.Peek(key))
+}
+
+func (h headerCarrier) Set(key string, value string) {
+	h.header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	var keys []string
+	h.header.VisitAll(func(key []byte, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// Middleware starts a server span per request, continuing the trace of an
+// incoming traceparent header. The span is named by the route template and
+// tagged with the tenant and the user once the route returned, its context is
+// the user context of the request so the statements of the route are children.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), headerCarrier{&c.Request().Header})
+		method := strings.Clone(c.Method())
+		ctx, span := tracer().Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(method), semconv.ClientAddress(c.IP())))
+		defer span.End()
+		own := c.Route()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		if route := c.Route(); route != own {
+			span.SetName(method + " " + route.Path)
+			span.SetAttributes(semconv.HTTPRoute(route.Path))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if tenant := tenantscope.FromContext(c.UserContext()); tenant != "" {
+			span.SetAttributes(TenantKey.String(tenant))
+		}
+		if claims := security.GetClaims(c); claims != nil {
+			span.SetAttributes(semconv.EnduserID(claims.Username))
+		}
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, "")
+			if err != nil {
+				span.RecordError(err)
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// TenantKey tags the spans with the tenant of the request
+const TenantKey = attribute.Key("tenant")
+
+const (
+	spanKey  = "tracing:span"
+	startKey = "tracing:start"
+)
+
+// Plugin records a child span per statement of a request and adds its duration to the Server-Timing of the request
+type Plugin struct{}
+
+func NewPlugin() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return "tracing"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("tracing:before_create", start("create")),
+		cb.Create().After("*").Register("tracing:after_create", end("create")),
+		cb.Query().Before("*").Register("tracing:before_query", start("query")),
+		cb.Query().After("*").Register("tracing:after_query", end("query")),
+		cb.Update().Before("*").Register("tracing:before_update", start("update")),
+		cb.Update().After("*").Register("tracing:after_update", end("update")),
+		cb.Delete().Before("*").Register("tracing:before_delete", start("delete")),
+		cb.Delete().After("*").Register("tracing:after_delete", end("delete")),
+		cb.Row().Before("*").Register("tracing:before_row", start("row")),
+		cb.Row().After("*").Register("tracing:after_row", end("row")),
+		cb.Raw().Before("*").Register("tracing:before_raw", start("raw")),
+		cb.Raw().After("*").Register("tracing:after_raw", end("raw")),
+	)
+}
+
+func start(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		db.InstanceSet(startKey, time.Now())
+		ctx := db.Statement.Context
+		if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+			// statements outside of a request, such as migrations, are not traced
+			return
+		}
+		_, span := tracer().Start(ctx, "db."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemKey.String(db.Dialector.Name()), semconv.DBOperationName(operation)))
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func end(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		finish(db, operation)
+	}
+}
+
+func finish(db *gorm.DB, operation string) {
+	if value, ok := db.InstanceGet(startKey); ok {
+		if timings := timingsFrom(db.Statement.Context); timings != nil {
+			timings.addDB(time.Since(value.(time.Time)))
+		}
+	}
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+	if table := db.Statement.Table; table != "" {
+		span.SetName("db." + operation + " " + table)
+		span.SetAttributes(semconv.DBCollectionName(table))
+	}
+	// the statement holds placeholders, never the values
+	span.SetAttributes(semconv.DBQueryText(db.Statement.SQL.String()), attribute.Int64("db.rows_affected", db.RowsAffected))
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type timingsKey struct{}
+
+// timings accumulates the time a request spent in the database
+type timings struct {
+	db atomic.Int64
+}
+
+func (t *timings) addDB(d time.Duration) {
+	t.db.Add(int64(d))
+}
+
+func timingsFrom(ctx context.Context) *timings {
+	t, _ := ctx.Value(timingsKey{}).(*timings)
+	return t
+}
+
+// ServerTiming reports the duration of the request in the Server-Timing header,
+// broken down into the time spent in the database and in the handler
+func ServerTiming() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		t := &timings{}
+		c.SetUserContext(context.WithValue(c.UserContext(), timingsKey{}, t))
+
+		err := c.Next()
+
+		total := time.Since(start)
+		db := time.Duration(t.db.Load())
+		c.Append(fiber.HeaderServerTiming,
+			fmt.Sprintf("db;dur=%.1f", ms(db)),
+			fmt.Sprintf("handler;dur=%.1f", ms(total-db)),
+			fmt.Sprintf("total;dur=%.1f", ms(total)))
+		return err
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
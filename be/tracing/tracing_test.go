@@ -0,0 +1,62 @@
+package tracing_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"vngom/config"
+	"vngom/tenantscope"
+	"vngom/tracing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+type Item struct {
+	ID   uint
+	Name string
+}
+
+func TestRequestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	// disabled, only the propagator is installed
+	_, err := tracing.Setup(config.TracingConfig{})
+	assert.NoError(t, err)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&Item{}))
+	assert.NoError(t, db.Use(tracing.NewPlugin()))
+
+	app := fiber.New()
+	app.Use(tracing.Middleware())
+	app.Use(tracing.ServerTiming())
+	app.Get("/api/:tenant/items", func(c *fiber.Ctx) error {
+		c.SetUserContext(tenantscope.WithTenant(c.UserContext(), "acme"))
+		var items []Item
+		return db.WithContext(c.UserContext()).Find(&items).Error
+	})
+	req := httptest.NewRequest("GET", "/api/acme/items", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^db;dur=[0-9.]+, handler;dur=[0-9.]+, total;dur=[0-9.]+$`, resp.Header.Get("Server-Timing"))
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		query, request := spans[0], spans[1]
+		assert.Equal(t, "GET /api/:tenant/items", request.Name())
+		// the trace of the caller is continued
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+		assert.Contains(t, request.Attributes(), tracing.TenantKey.String("acme"))
+		assert.Equal(t, "db.query items", query.Name())
+		assert.Equal(t, request.SpanContext().SpanID(), query.Parent().SpanID())
+	}
+}
@@ -0,0 +1,269 @@
+// twofactor implements optional TOTP (RFC 6238) two-factor authentication of
+// accounts: enrollment, verification of codes at login, single use recovery
+// codes and roles making the second factor mandatory.
+package twofactor
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"vngom/config"
+	"vngom/models/account"
+	"vngom/models/rbac"
+	"vngom/password"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultIssuer     = "vngom"
+	recoveryCodeCount = 10
+	// recoveryAlphabet has 32 characters so every random byte maps without bias
+	recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+var (
+	ErrInvalidCode    = errors.New("invalid two-factor code")
+	ErrNotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrRequired       = errors.New("two-factor authentication is required by a role of the account")
+)
+
+// Enrollment is the pending secret of an account, confirmed by a first code
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type Service struct {
+	issuer    string
+	passwords *password.Service
+}
+
+func NewService(auth config.AuthConfig, policy config.PasswordConfig) *Service {
+	issuer := auth.TwoFactorIssuer
+	if issuer == "" {
+		issuer = defaultIssuer
+	}
+	return &Service{issuer: issuer, passwords: password.NewService(policy)}
+}
+
+// Required reports whether a role of the account makes two-factor authentication mandatory
+func Required(db *gorm.DB, accountID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&rbac.Role{}).
+		Where("require_two_factor = ?", true).
+		Where("id IN (?)", db.Model(&rbac.AccountRole{}).Select("role_id").Where("account_id = ?", accountID)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Enroll generates a new secret for the account. It stays inactive until Confirm.
+func (s *Service) Enroll(db *gorm.DB, acc *account.Account) (*Enrollment, error) {
+	if acc.TwoFactorEnabled {
+		return nil, ErrAlreadyEnabled
+	}
+	secret, err := GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Model(acc).UpdateColumn("two_factor_secret", secret).Error; err != nil {
+		return nil, err
+	}
+	acc.TwoFactorSecret = secret
+	return &Enrollment{Secret: secret, URI: ProvisioningURI(s.issuer, acc.Username, secret)}, nil
+}
+
+// Confirm activates the pending secret with a code of the authenticator app
+// and returns the recovery codes, they are only shown this once.
+func (s *Service) Confirm(db *gorm.DB, acc *account.Account, code string) ([]string, error) {
+	if acc.TwoFactorEnabled {
+		return nil, ErrAlreadyEnabled
+	}
+	if acc.TwoFactorSecret == "" {
+		return nil, ErrNotEnrolled
+	}
+	step, ok := Validate(acc.TwoFactorSecret, code, time.Now(), acc.TwoFactorLastStep)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(acc).UpdateColumns(map[string]interface{}{
+			"two_factor_enabled":   true,
+			"two_factor_last_step": step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, acc)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	acc.TwoFactorEnabled = true
+	acc.TwoFactorLastStep = step
+	return codes, nil
+}
+
+// Verify checks the second factor of a login, either a TOTP code or an unused
+// recovery code. Wrong codes count towards the lockout of the account.
+func (s *Service) Verify(db *gorm.DB, acc *account.Account, code string) error {
+	if !acc.TwoFactorEnabled {
+		return ErrNotEnrolled
+	}
+	if err := s.passwords.CheckLocked(acc); err != nil {
+		return err
+	}
+	ok, err := s.check(db, acc, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		err := s.passwords.RecordFailure(db, acc)
+		if errors.Is(err, password.ErrInvalidCredentials) {
+			return ErrInvalidCode
+		}
+		return err
+	}
+	if acc.FailedAttempts > 0 {
+		acc.FailedAttempts = 0
+		return db.Model(acc).UpdateColumn("failed_attempts", 0).Error
+	}
+	return nil
+}
+
+// Disable turns two-factor authentication off after checking a code,
+// unless a role of the account requires it.
+func (s *Service) Disable(db *gorm.DB, acc *account.Account, code string) error {
+	required, err := Required(db, acc.ID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrRequired
+	}
+	if err := s.Verify(db, acc, code); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(acc).UpdateColumns(map[string]interface{}{
+			"two_factor_enabled":   false,
+			"two_factor_secret":    "",
+			"two_factor_last_step": 0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		acc.TwoFactorEnabled = false
+		acc.TwoFactorSecret = ""
+		acc.TwoFactorLastStep = 0
+		return tx.Where("account_id = ?", acc.ID).Delete(&account.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of an account after checking a code
+func (s *Service) RegenerateRecoveryCodes(db *gorm.DB, acc *account.Account, code string) ([]string, error) {
+	if err := s.Verify(db, acc, code); err != nil {
+		return nil, err
+	}
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, acc)
+		return err
+	})
+	return codes, err
+}
+
+// check accepts a TOTP code or consumes a recovery code
+func (s *Service) check(db *gorm.DB, acc *account.Account, code string) (bool, error) {
+	if step, ok := Validate(acc.TwoFactorSecret, code, time.Now(), acc.TwoFactorLastStep); ok {
+		// the condition on the last step makes concurrent logins with the same code race safely
+		result := db.Model(&account.Account{}).
+			Where("id = ? AND two_factor_last_step < ?", acc.ID, step).
+			UpdateColumn("two_factor_last_step", step)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		acc.TwoFactorLastStep = step
+		return result.RowsAffected == 1, nil
+	}
+	return useRecoveryCode(db, acc, code)
+}
+
+func useRecoveryCode(db *gorm.DB, acc *account.Account, code string) (bool, error) {
+	code = normalizeRecoveryCode(code)
+	if len(code) != 10 {
+		return false, nil
+	}
+	var codes []account.RecoveryCode
+	if err := db.Where("account_id = ? AND used_on IS NULL", acc.ID).Find(&codes).Error; err != nil {
+		return false, err
+	}
+	for _, rc := range codes {
+		ok, _, err := password.Verify(code, rc.CodeHash, "")
+		if err != nil || !ok {
+			continue
+		}
+		result := db.Model(&account.RecoveryCode{}).Where("id = ? AND used_on IS NULL", rc.ID).
+			UpdateColumn("used_on", time.Now().UTC())
+		if result.Error != nil {
+			return false, result.Error
+		}
+		return result.RowsAffected == 1, nil
+	}
+	return false, nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, acc *account.Account) ([]string, error) {
+	if err := tx.Where("account_id = ?", acc.ID).Delete(&account.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		// recovery codes are hashed like passwords, only their owner ever sees them
+		hash, err := password.Hash(normalizeRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+		rc := account.RecoveryCode{AccountID: acc.ID, CodeHash: hash}
+		rc.ID = uuid.New()
+		rc.CreatedOn = now
+		rc.CreatedBy = acc.Username
+		if err := tx.Create(&rc).Error; err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a code formatted as xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, v := range raw {
+		if i == 5 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(recoveryAlphabet[v&31])
+	}
+	return b.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
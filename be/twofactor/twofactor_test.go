@@ -0,0 +1,128 @@
+package twofactor_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"vngom/config"
+	"vngom/models/account"
+	"vngom/models/rbac"
+	"vngom/twofactor"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&account.Account{}, &account.RecoveryCode{}, &rbac.Role{}, &rbac.AccountRole{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newAccount(t *testing.T, db *gorm.DB, username string) *account.Account {
+	acc := account.Account{Username: username, Email: username + "@example.com"}
+	acc.ID = uuid.New()
+	acc.CreatedOn = time.Now().UTC()
+	assert.NoError(t, db.Create(&acc).Error)
+	return &acc
+}
+
+func codeAt(t *testing.T, secret string, step int64) string {
+	code, err := twofactor.Code(secret, step)
+	assert.NoError(t, err)
+	return code
+}
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	// the last 6 digits of the SHA1 vectors of RFC 6238 appendix B
+	assert.Equal(t, "287082", codeAt(t, secret, twofactor.Step(time.Unix(59, 0))))
+	assert.Equal(t, "081804", codeAt(t, secret, twofactor.Step(time.Unix(1111111109, 0))))
+	assert.Equal(t, "005924", codeAt(t, secret, twofactor.Step(time.Unix(1234567890, 0))))
+
+	uri := twofactor.ProvisioningURI("vngom HRM", "alice", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/vngom%20HRM:alice?"))
+	assert.Contains(t, uri, "secret="+secret)
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	db := newTestDb(t)
+	svc := twofactor.NewService(config.AuthConfig{}, config.PasswordConfig{MaxFailedAttempts: 3})
+	acc := newAccount(t, db, "alice")
+
+	enrollment, err := svc.Enroll(db, acc)
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/")
+	assert.ErrorIs(t, svc.Verify(db, acc, "000000"), twofactor.ErrNotEnrolled)
+
+	now := twofactor.Step(time.Now())
+	_, err = svc.Confirm(db, acc, "000000")
+	assert.ErrorIs(t, err, twofactor.ErrInvalidCode)
+	codes, err := svc.Confirm(db, acc, codeAt(t, enrollment.Secret, now))
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	var stored account.Account
+	assert.NoError(t, db.First(&stored, "id = ?", acc.ID).Error)
+	assert.True(t, stored.TwoFactorEnabled)
+
+	// the code used to confirm cannot be replayed, the next step is accepted
+	assert.ErrorIs(t, svc.Verify(db, &stored, codeAt(t, enrollment.Secret, now)), twofactor.ErrInvalidCode)
+	assert.NoError(t, svc.Verify(db, &stored, codeAt(t, enrollment.Secret, now+1)))
+
+	// a recovery code works once
+	assert.NoError(t, svc.Verify(db, &stored, strings.ToUpper(codes[0])))
+	assert.ErrorIs(t, svc.Verify(db, &stored, codes[0]), twofactor.ErrInvalidCode)
+}
+
+func TestWrongCodesLockTheAccount(t *testing.T) {
+	db := newTestDb(t)
+	svc := twofactor.NewService(config.AuthConfig{}, config.PasswordConfig{MaxFailedAttempts: 2, LockoutDuration: time.Minute})
+	acc := newAccount(t, db, "bob")
+	enrollment, err := svc.Enroll(db, acc)
+	assert.NoError(t, err)
+	_, err = svc.Confirm(db, acc, codeAt(t, enrollment.Secret, twofactor.Step(time.Now())))
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, svc.Verify(db, acc, "111111"), twofactor.ErrInvalidCode)
+	assert.Error(t, svc.Verify(db, acc, "111111"))
+	assert.NotNil(t, acc.LockedUntil)
+	// a valid code is refused while locked
+	err = svc.Verify(db, acc, codeAt(t, enrollment.Secret, twofactor.Step(time.Now())+1))
+	assert.ErrorContains(t, err, "locked")
+}
+
+func TestRequiredByRole(t *testing.T) {
+	db := newTestDb(t)
+	svc := twofactor.NewService(config.AuthConfig{}, config.PasswordConfig{})
+	acc := newAccount(t, db, "carol")
+
+	required, err := twofactor.Required(db, acc.ID)
+	assert.NoError(t, err)
+	assert.False(t, required)
+
+	role := rbac.Role{Code: "payroll", Name: "Payroll", RequireTwoFactor: true}
+	role.ID = uuid.New()
+	assert.NoError(t, db.Create(&role).Error)
+	assert.NoError(t, db.Create(&rbac.AccountRole{AccountID: acc.ID, RoleID: role.ID}).Error)
+
+	required, err = twofactor.Required(db, acc.ID)
+	assert.NoError(t, err)
+	assert.True(t, required)
+
+	enrollment, err := svc.Enroll(db, acc)
+	assert.NoError(t, err)
+	_, err = svc.Confirm(db, acc, codeAt(t, enrollment.Secret, twofactor.Step(time.Now())))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, svc.Disable(db, acc, codeAt(t, enrollment.Secret, twofactor.Step(time.Now())+1)), twofactor.ErrRequired)
+}